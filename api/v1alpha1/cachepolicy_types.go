@@ -0,0 +1,101 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RefreshPattern is a squid refresh_pattern for the URLs matching Regex.
+// Times are in minutes.
+type RefreshPattern struct {
+	// Regex is a POSIX extended regular expression matched against URLs. It
+	// has to start with the scheme and the literal host up to the first /,
+	// e.g. ^https://registry\.example\.com/, whose host the namespace's
+	// allowedDomains must cover, and can't be a top-level alternation, so
+	// that it targets the tenant's own sites.
+	// +kubebuilder:validation:MinLength=1
+	Regex string `json:"regex"`
+	// CaseInsensitive matches Regex ignoring case
+	// +optional
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+	// Min is how long objects without an explicit expiry are fresh at least
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Min int32 `json:"min,omitempty"`
+	// Percent of the object's age since last modification it is fresh for
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percent int32 `json:"percent,omitempty"`
+	// Max is how long objects without an explicit expiry are fresh at most
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Max int32 `json:"max,omitempty"`
+	// MaxStale is how long stale objects are served while their origin fails
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxStale *int32 `json:"maxStale,omitempty"`
+}
+
+// CachePolicySpec declares the caching rules of a tenant
+type CachePolicySpec struct {
+	// RefreshPatterns are tried, in order, after the platform's own and
+	// before squid's defaults
+	// +optional
+	RefreshPatterns []RefreshPattern `json:"refreshPatterns,omitempty"`
+	// AllowedDomains are destinations the pods of the policy's namespace may
+	// fetch from through the proxy, in squid's dstdomain syntax:
+	// .example.com for the domain and its subdomains, example.com for the
+	// host alone
+	// +optional
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+}
+
+// RejectedRule is a rule of the policy that was not applied
+type RejectedRule struct {
+	// Rule is the path of the rule in the spec, e.g. refreshPatterns[1]
+	Rule string `json:"rule"`
+	// Reason the rule was rejected
+	Reason string `json:"reason"`
+}
+
+// CachePolicyStatus reports which rules of the policy are applied
+type CachePolicyStatus struct {
+	// ObservedGeneration is the generation the status refers to
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions of the policy: Accepted is false if any rule was rejected
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// RejectedRules lists the rules that were not applied; the others are
+	// +optional
+	RejectedRules []RejectedRule `json:"rejectedRules,omitempty"`
+}
+
+// CachePolicy declares refresh patterns and allowed destinations for the
+// proxy on behalf of the tenant owning its namespace
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.conditions[?(@.type=="Accepted")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Accepted")].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type CachePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CachePolicySpec   `json:"spec,omitempty"`
+	Status CachePolicyStatus `json:"status,omitempty"`
+}
+
+// CachePolicyList is a list of CachePolicy resources
+// +kubebuilder:object:root=true
+type CachePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CachePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CachePolicy{}, &CachePolicyList{})
+}
@@ -0,0 +1,23 @@
+// Package v1alpha1 contains the caching.konflux-ci.dev/v1alpha1 API: the
+// CachePolicy resources through which tenants declare caching rules.
+// +kubebuilder:object:generate=true
+// +groupName=caching.konflux-ci.dev
+package v1alpha1
+
+//go:generate go run sigs.k8s.io/controller-tools/cmd/controller-gen@v0.18.0 object crd paths=./... output:crd:dir=../../squid/crds
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group and version of the API
+	GroupVersion = schema.GroupVersion{Group: "caching.konflux-ci.dev", Version: "v1alpha1"}
+
+	// SchemeBuilder registers the API's types with a scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the API's types to a scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,89 @@
+// cachepolicy-controller renders the CachePolicy resources of tenants into the
+// squid configuration, see internal/cachepolicy.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	"github.com/konflux-ci/caching/internal/cachepolicy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+func main() {
+	namespace := flag.String("namespace", "proxy", "Namespace of the ConfigMap squid includes")
+	configMap := flag.String("configmap", "squid-cache-policies", "Name of the ConfigMap squid includes")
+	metricsAddr := flag.String("metrics-bind-address", ":8080", "Address to serve metrics on (disabled if \"0\")")
+	probeAddr := flag.String("health-probe-bind-address", ":8081", "Address to serve the health probes on")
+	leaderElect := flag.Bool("leader-elect", true, "Elect a leader among the controller's replicas")
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New())
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := cachingv1alpha1.AddToScheme(scheme); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                  scheme,
+		Metrics:                 metricsserver.Options{BindAddress: *metricsAddr},
+		HealthProbeBindAddress:  *probeAddr,
+		LeaderElection:          *leaderElect,
+		LeaderElectionID:        "cachepolicy-controller.caching.konflux-ci.dev",
+		LeaderElectionNamespace: *namespace,
+		Cache: cache.Options{
+			// The controller may only read its own ConfigMap
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.ConfigMap{}: {
+					Namespaces: map[string]cache.Config{*namespace: {}},
+					Field:      fields.OneTermEqualSelector("metadata.name", *configMap),
+				},
+			},
+		},
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to create the controller manager: %v\n", err)
+		os.Exit(1)
+	}
+
+	reconciler := &cachepolicy.Reconciler{
+		Client:    mgr.GetClient(),
+		ConfigMap: types.NamespacedName{Namespace: *namespace, Name: *configMap},
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		fmt.Printf("❌ Failed to set up the controller: %v\n", err)
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("🚀 Rendering CachePolicies into ConfigMap %s\n", reconciler.ConfigMap)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,183 @@
+// cachesim projects the hit ratios of cache sizes and replacement policies by
+// replaying recorded squid access logs, see internal/cachesim.
+//
+//	kubectl logs -n proxy -l app.kubernetes.io/component=squid-proxy -c squid --since=24h > access.log
+//	cachesim -sizes 256MB,1GB,4GB access.log
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+	"github.com/konflux-ci/caching/internal/cachesim"
+)
+
+func main() {
+	sizes := flag.String("sizes", "256MB,512MB,1GB,2GB,4GB,8GB,16GB,32GB", "Comma-separated cache sizes to simulate")
+	policies := flag.String("policies", strings.Join(cachesim.Policies, ","), "Comma-separated replacement policies to simulate")
+	maxObjectSize := flag.String("max-object-size", "4MB",
+		"Largest object cached: maximum_object_size for cache_dir, maximum_object_size_in_memory (512KB by default) for cache_mem")
+	warmup := flag.Float64("warmup", 0, "Share of the log (0-1) replayed before counting hits")
+	output := flag.String("output", "text", "Output format: text or json")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: cachesim [flags] [access log files, stdin if none]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var capacities []int64
+	for _, size := range strings.Split(*sizes, ",") {
+		capacity, err := cachesim.ParseSize(size)
+		if err != nil {
+			fail("%v", err)
+		}
+		capacities = append(capacities, capacity)
+	}
+	options := cachesim.Options{Warmup: *warmup}
+	var err error
+	if options.MaxObjectSize, err = cachesim.ParseSize(*maxObjectSize); err != nil {
+		fail("%v", err)
+	}
+	if *warmup < 0 || *warmup >= 1 {
+		fail("-warmup must be at least 0 and less than 1")
+	}
+
+	trace := cachesim.NewTrace()
+	skipped := 0
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, path := range files {
+		n, err := readLog(path, trace)
+		if err != nil {
+			fail("%v", err)
+		}
+		skipped += n
+	}
+	summary := trace.Summary()
+	if summary.Requests == 0 {
+		fail("no access log entries found in %s", strings.Join(files, ", "))
+	}
+
+	var policyNames []string
+	for _, policy := range strings.Split(*policies, ",") {
+		policyNames = append(policyNames, strings.TrimSpace(policy))
+	}
+	results, err := cachesim.Run(trace, policyNames, capacities, options)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	switch *output {
+	case "json":
+		type point struct {
+			cachesim.Result
+			HitRatio     float64 `json:"hitRatio"`
+			ByteHitRatio float64 `json:"byteHitRatio"`
+		}
+		report := struct {
+			Summary cachesim.Summary `json:"summary"`
+			Skipped int              `json:"skippedLines"`
+			Results []point          `json:"results"`
+		}{Summary: summary, Skipped: skipped}
+		for _, result := range results {
+			report.Results = append(report.Results, point{result, result.HitRatio(), result.ByteHitRatio()})
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			fail("%v", err)
+		}
+	default:
+		fmt.Printf("📼 Replayed %d requests (%d cacheable, %d objects, %s unique), skipped %d other lines and %d denied requests\n",
+			summary.Requests, summary.Cacheable, summary.Objects, cachesim.FormatSize(summary.UniqueBytes), skipped, summary.Denied)
+		fmt.Printf("   squid served %s of the requests and %s of the bytes from its cache\n\n",
+			percent(summary.ObservedHits, summary.Requests), percent(summary.ObservedHitBytes, summary.Bytes))
+		writeTable(results)
+	}
+}
+
+// readLog adds the access log entries of a file to trace and returns the
+// number of other lines, such as cache.log messages
+func readLog(path string, trace *cachesim.Trace) (int, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	skipped := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := accesslog.ParseLine(scanner.Text())
+		if err != nil {
+			skipped++
+			continue
+		}
+		trace.Add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return skipped, nil
+}
+
+// writeTable prints a row per cache size, with the hit and byte hit ratios of
+// every policy
+func writeTable(results []cachesim.Result) {
+	var policies []string
+	var capacities []int64
+	ratios := map[string]map[int64]cachesim.Result{}
+	for _, result := range results {
+		if ratios[result.Policy] == nil {
+			policies = append(policies, result.Policy)
+			ratios[result.Policy] = map[int64]cachesim.Result{}
+		}
+		if _, ok := ratios[policies[0]][result.Capacity]; !ok {
+			capacities = append(capacities, result.Capacity)
+		}
+		ratios[result.Policy][result.Capacity] = result
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"SIZE"}
+	for _, policy := range policies {
+		header = append(header, strings.ToUpper(policy)+" HIT%", strings.ToUpper(policy)+" BYTE HIT%")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, capacity := range capacities {
+		row := []string{"unlimited"}
+		if capacity > 0 {
+			row[0] = cachesim.FormatSize(capacity)
+		}
+		for _, policy := range policies {
+			result := ratios[policy][capacity]
+			row = append(row, percent(result.Hits, result.Requests), percent(result.HitBytes, result.Bytes))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func percent(part, whole int64) string {
+	if whole == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)/float64(whole)*100)
+}
+
+func fail(format string, args ...any) {
+	fmt.Printf("❌ "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,38 @@
+// pod-namespace-helper is a squid external_acl_type helper that matches
+// clients by the namespace of their pod, see internal/podnamespace.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/konflux-ci/caching/internal/podnamespace"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func main() {
+	// stdout belongs to the helper protocol, squid logs our stderr
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pod-namespace-helper: %v\n", err)
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pod-namespace-helper: %v\n", err)
+		os.Exit(1)
+	}
+
+	resolver, err := podnamespace.NewInformerResolver(context.Background(), clientset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pod-namespace-helper: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := podnamespace.Serve(resolver, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "pod-namespace-helper: %v\n", err)
+		os.Exit(1)
+	}
+}
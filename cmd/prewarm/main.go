@@ -0,0 +1,115 @@
+// prewarm fetches the URLs listed in a manifest through the proxy so that
+// they are cached ahead of the first builds, see internal/prewarm. It exits
+// non-zero when more than -max-failure-ratio of the URLs can't be warmed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/prewarm"
+)
+
+func main() {
+	urlsPath := flag.String("urls", "/etc/prewarm/urls.txt", "Path to the URL manifest, one URL per line")
+	proxy := flag.String("proxy", "http://squid.proxy.svc.cluster.local:3128", "URL of the proxy to warm")
+	concurrency := flag.Int("concurrency", 4, "Maximum number of URLs fetched at once")
+	requestTimeout := flag.Duration("timeout", 5*time.Minute, "Timeout for fetching a single URL")
+	waitTimeout := flag.Duration("wait", 2*time.Minute, "How long to wait for the proxy to accept connections")
+	maxFailureRatio := flag.Float64("max-failure-ratio", 0, "Share of URLs (0-1) allowed to fail before the run fails")
+	output := flag.String("output", "text", "Output format: text or json")
+	flag.Parse()
+
+	file, err := os.Open(*urlsPath)
+	if err != nil {
+		fail("failed to open URL manifest: %v", err)
+	}
+	urls, err := prewarm.ReadURLs(file)
+	file.Close()
+	if err != nil {
+		fail("%v", err)
+	}
+
+	proxyURL, err := url.Parse(*proxy)
+	if err != nil {
+		fail("invalid proxy URL: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := waitForProxy(ctx, proxyURL.Host, *waitTimeout); err != nil {
+		fail("%v", err)
+	}
+
+	warmer := &prewarm.Warmer{
+		Client: &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+			Timeout:   *requestTimeout,
+		},
+		Concurrency: *concurrency,
+	}
+	results := warmer.Warm(ctx, urls)
+	summary := prewarm.Summarize(results)
+
+	switch *output {
+	case "json":
+		report := struct {
+			Results []prewarm.Result `json:"results"`
+			Summary prewarm.Summary  `json:"summary"`
+		}{results, summary}
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			fail("%v", err)
+		}
+	default:
+		for _, result := range results {
+			line := fmt.Sprintf("%-5s %3d %10d %6.0fms %s", result.CacheStatus, result.StatusCode, result.Bytes,
+				result.DurationMs, result.URL)
+			if result.Error != "" {
+				line += ": " + result.Error
+			}
+			fmt.Println(line)
+		}
+		fmt.Printf("total=%d hits=%d misses=%d failed=%d\n", summary.Total, summary.Hits, summary.Misses, summary.Failed)
+	}
+
+	if summary.FailureRatio() > *maxFailureRatio {
+		fail("%d of %d URLs could not be warmed (%.0f%% > %.0f%% allowed)",
+			summary.Failed, summary.Total, summary.FailureRatio()*100, *maxFailureRatio*100)
+	}
+}
+
+// waitForProxy retries connecting to the proxy, which may still be starting
+// when the install hook runs
+func waitForProxy(ctx context.Context, addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("proxy %s not reachable after %s: %w", addr, timeout, err)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func fail(format string, args ...any) {
+	fmt.Printf("❌ "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,131 @@
+// proxy-injector serves the mutating admission webhook that injects the
+// proxy environment variables and CA bundle into pods, see internal/inject.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/inject"
+)
+
+func main() {
+	listen := flag.String("listen", ":9443", "Address to serve the webhook on")
+	tlsDir := flag.String("tls-dir", "/etc/proxy-injector/tls", "Directory holding the serving certificate as tls.crt and tls.key")
+	proxyURL := flag.String("proxy-url", "", "Proxy URL to set as HTTP_PROXY and HTTPS_PROXY")
+	noProxy := flag.String("no-proxy", "localhost,127.0.0.1,::1,.svc,.cluster.local", "Comma-separated destinations to reach directly")
+	caBundleConfigMap := flag.String("ca-bundle-configmap", "", "ConfigMap holding the CA bundle in every namespace (none mounted if empty)")
+	caBundleKey := flag.String("ca-bundle-key", "ca-bundle.crt", "Key of the CA bundle in the ConfigMap")
+	caBundleMountPath := flag.String("ca-bundle-mount-path", "/etc/proxy-ca", "Directory to mount the CA bundle in")
+	flag.Parse()
+
+	if *proxyURL == "" {
+		fmt.Println("❌ -proxy-url is required")
+		os.Exit(1)
+	}
+
+	config := inject.Config{
+		ProxyURL:          *proxyURL,
+		NoProxy:           splitList(*noProxy),
+		CABundleConfigMap: *caBundleConfigMap,
+		CABundleKey:       *caBundleKey,
+		CABundleMountPath: *caBundleMountPath,
+	}
+	// Clients in pods talk to the API server by the address Kubernetes puts
+	// in their environment, which must not go through the proxy
+	if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+		config.NoProxy = append(config.NoProxy, host)
+	}
+
+	certificate := &certificateLoader{
+		certFile: *tlsDir + "/tls.crt",
+		keyFile:  *tlsDir + "/tls.key",
+	}
+	if _, err := certificate.get(nil); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", inject.Handler(config))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	fmt.Printf("🚀 Serving proxy injection webhook on %s (proxy %s, no proxy %q)\n",
+		*listen, config.ProxyURL, strings.Join(config.NoProxy, ","))
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificate.get,
+		},
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Printf("❌ Failed to listen on %s: %v\n", *listen, err)
+		os.Exit(1)
+	}
+	if err := server.ServeTLS(listener, "", ""); err != nil {
+		fmt.Printf("❌ Proxy injection webhook failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// certificateLoader serves the certificate in certFile and keyFile, loading
+// it again when cert-manager renewed it
+type certificateLoader struct {
+	certFile, keyFile string
+
+	mu          sync.Mutex
+	modTime     time.Time
+	certificate *tls.Certificate
+}
+
+func (l *certificateLoader) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	info, err := os.Stat(l.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the serving certificate: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.certificate != nil && info.ModTime().Equal(l.modTime) {
+		return l.certificate, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		if l.certificate != nil {
+			// Keep serving the previous certificate while the Secret
+			// volume is being updated
+			return l.certificate, nil
+		}
+		return nil, fmt.Errorf("failed to load the serving certificate: %w", err)
+	}
+	if l.certificate != nil {
+		fmt.Println("✅ Loaded the renewed serving certificate")
+	}
+	l.certificate = &certificate
+	l.modTime = info.ModTime()
+	return l.certificate, nil
+}
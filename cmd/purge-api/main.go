@@ -0,0 +1,125 @@
+// purge-api serves the cache purge API next to squid, see internal/purge.
+//
+// Usage:
+//
+//	purge-api [flags]                        serve the API
+//	purge-api purge -url URL | -pattern RE   purge through the local API
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/purge"
+	"github.com/konflux-ci/caching/internal/squidclient"
+	"github.com/konflux-ci/caching/internal/storeid"
+)
+
+func main() {
+	// The bearer token comes from the environment so that it never shows up
+	// in the process arguments
+	token := os.Getenv("PURGE_API_TOKEN")
+	if token == "" {
+		fmt.Println("❌ PURGE_API_TOKEN environment variable is required but not set")
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		if err := runPurge(os.Args[2:], token); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	listen := flag.String("listen", ":9302", "Address to serve the purge API on")
+	squidAddr := flag.String("squid", "localhost:3128", "Address of the local squid")
+	peers := flag.String("peers", "", "DNS name resolving to all replicas to fan purge requests out to (local only if empty)")
+	storeIDRules := flag.String("store-id-rules", "", "Store ID rules file, to also purge the store ID of purged URLs")
+	flag.Parse()
+
+	squid := squidclient.New(*squidAddr)
+	// Cache manager credentials, if squid requires them (cachemgr_passwd)
+	squid.Login = os.Getenv("SQUID_LOGIN")
+	squid.Password = os.Getenv("SQUID_PASSWORD")
+	server := purge.NewServer(squid, token, *peers, portOf(*listen))
+	if *storeIDRules != "" {
+		rewriter, err := storeid.LoadRewriter(*storeIDRules)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		server.Rewriter = rewriter
+	}
+
+	fmt.Printf("🚀 Serving purge API on %s (squid at %s, peers %q)\n", *listen, *squidAddr, *peers)
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := httpServer.ListenAndServe(); err != nil {
+		fmt.Printf("❌ Purge API server failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// portOf returns the port of a listen address, defaulting to 9302
+func portOf(listen string) int {
+	_, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return 9302
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 9302
+	}
+	return port
+}
+
+// runPurge sends a purge request to the purge API served in this pod, which
+// fans it out to every replica
+func runPurge(args []string, token string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	api := flags.String("api", "http://localhost:9302", "Purge API base URL")
+	url := flags.String("url", "", "URL to purge")
+	pattern := flags.String("pattern", "", "Regular expression matching the URLs or store IDs to purge")
+	flags.Parse(args)
+
+	body, err := json.Marshal(purge.Request{URL: *url, Pattern: *pattern})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *api+"/purge", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read purge response: %w", err)
+	}
+	fmt.Println(string(respBody))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("purge failed with status %s", resp.Status)
+	}
+	return nil
+}
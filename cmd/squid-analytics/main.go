@@ -0,0 +1,82 @@
+// squid-analytics runs next to squid and exports cache metrics by domain,
+// namespace and content type from its access log stream, see
+// internal/analytics.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/konflux-ci/caching/internal/analytics"
+	"github.com/konflux-ci/caching/internal/podnamespace"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func main() {
+	listenUDP := flag.String("listen-udp", "127.0.0.1:9305", "Address to receive the access log stream on")
+	listen := flag.String("listen", ":9304", "Address to serve metrics on")
+	maxValues := flag.Int("max-values", 1000, "Distinct values tracked per dimension")
+	top := flag.Int("top", 50, "Values exported per dimension by request count; values stay exported once in the top N")
+	resolveNamespaces := flag.Bool("resolve-namespaces", true, "Attribute clients to namespaces by pod IP")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var resolver podnamespace.Resolver
+	if *resolveNamespaces {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			fmt.Printf("❌ Failed to load in-cluster config: %v\n", err)
+			os.Exit(1)
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			fmt.Printf("❌ Failed to create Kubernetes client: %v\n", err)
+			os.Exit(1)
+		}
+		informerResolver, err := podnamespace.NewInformerResolver(ctx, clientset)
+		if err != nil {
+			fmt.Printf("❌ Failed to watch pods: %v\n", err)
+			os.Exit(1)
+		}
+		resolver = informerResolver
+	}
+
+	collector := analytics.New(resolver, *maxValues, *top)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	conn, err := net.ListenPacket("udp", *listenUDP)
+	if err != nil {
+		fmt.Printf("❌ Failed to listen on %s: %v\n", *listenUDP, err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	go func() {
+		if err := http.ListenAndServe(*listen, mux); err != nil {
+			fmt.Printf("❌ Metrics server failed: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	fmt.Printf("🚀 Receiving access logs on %s (metrics on %s)\n", *listenUDP, *listen)
+	if err := collector.Serve(ctx, conn); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,89 @@
+// squid-drain drains squid's client connections when its pod terminates, see
+// internal/drain. It runs as the squid container's preStop hook: it starts
+// draining, which fails the squid-health readiness probe, and waits for the
+// client requests in progress to complete.
+//
+//	squid-drain prestop [flags]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/drain"
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("❌ usage: squid-drain prestop [flags]")
+		os.Exit(2)
+	}
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "prestop":
+		err = runPreStop(args)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runPreStop marks the pod as draining, keeps serving while Services stop
+// routing to it, then waits for the remaining client requests to complete.
+// Should squid's cache manager not answer, it waits for the client
+// connections to close instead.
+func runPreStop(args []string) error {
+	flags := flag.NewFlagSet("prestop", flag.ExitOnError)
+	port := flags.Int("port", 3128, "squid's client port")
+	marker := flags.String("marker", drain.Marker, "File created when draining starts")
+	delay := flags.Duration("delay", 10*time.Second, "How long to keep accepting connections after turning unready")
+	timeout := flags.Duration("timeout", 4*time.Minute, "How long to wait for the client requests to complete, after the delay")
+	flags.Parse(args)
+
+	squid := squidclient.New(fmt.Sprintf("localhost:%d", *port))
+	squid.Timeout = 3 * time.Second
+	if file := os.Getenv("SQUID_CACHEMGR_PASSWORD_FILE"); file != "" {
+		password, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read the cache manager password: %w", err)
+		}
+		squid.Login = "squid-drain"
+		squid.Password = strings.TrimSpace(string(password))
+	}
+
+	if err := os.WriteFile(*marker, nil, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("🚰 Draining: unready now, waiting %s for Services to stop routing clients here\n", *delay)
+	time.Sleep(*delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	countConnections := false
+	err := drain.Wait(ctx, time.Second, func() (int, error) {
+		if !countConnections {
+			n, err := drain.ActiveRequests(ctx, squid)
+			if err == nil {
+				return n, nil
+			}
+			fmt.Printf("⚠️ Waiting for the client connections to close instead: %v\n", err)
+			countConnections = true
+		}
+		return drain.ActiveConnections(*port, drain.ProcNetTCP...)
+	})
+	if err != nil {
+		// squid cuts the rest off after its shutdown_lifetime
+		return fmt.Errorf("stopping with client requests in progress: %w", err)
+	}
+	fmt.Println("✅ All client requests completed")
+	return nil
+}
@@ -0,0 +1,95 @@
+// squid-health probes the squid container, see internal/health.
+//
+//	squid-health live [flags]    liveness probe: fails if squid does not
+//	                             answer the cache manager or the synthetic
+//	                             fetch; degraded resources are only reported
+//	squid-health ready [flags]   readiness probe: also fails while the pod
+//	                             drains and, with -fail-degraded, while squid
+//	                             is degraded
+//
+// The cache manager password is read from the file named by
+// SQUID_CACHEMGR_PASSWORD_FILE, if set.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/drain"
+	"github.com/konflux-ci/caching/internal/health"
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("❌ usage: squid-health live|ready [flags]")
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	if command != "live" && command != "ready" {
+		fmt.Printf("❌ unknown command %q\n", command)
+		os.Exit(2)
+	}
+	if err := run(command, args); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	addr := flags.String("addr", "localhost:3128", "squid's client address")
+	timeout := flags.Duration("timeout", 4*time.Second, "Timeout of the whole check, shorter than the probe's")
+	syntheticURL := flags.String("synthetic-url", health.SyntheticURL, "URL fetched through squid, none if empty")
+	maxCacheDirUsage := flags.Float64("max-cache-dir-usage", 98, "Percentage of a cache_dir, or of its filesystem, in use above which squid is degraded")
+	maxFDUsage := flags.Float64("max-fd-usage", 90, "Percentage of file descriptors in use above which squid is degraded")
+	marker := flags.String("marker", drain.Marker, "File created when draining starts (ready only)")
+	failDegraded := flags.Bool("fail-degraded", true, "Fail while squid is degraded (ready only)")
+	flags.Parse(args)
+
+	if command == "ready" {
+		if _, err := os.Stat(*marker); err == nil {
+			return errors.New("draining")
+		}
+	}
+
+	// The requests to squid share the deadline, so that the check reports
+	// why it failed before the kubelet kills it
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client := squidclient.New(*addr)
+	client.Timeout = *timeout
+	if file := os.Getenv("SQUID_CACHEMGR_PASSWORD_FILE"); file != "" {
+		password, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read the cache manager password: %w", err)
+		}
+		client.Login = "squid-health"
+		client.Password = strings.TrimSpace(string(password))
+	}
+
+	report, err := health.Check(ctx, client, health.Options{
+		SyntheticURL:           *syntheticURL,
+		MaxCacheDirUsage:       *maxCacheDirUsage,
+		MaxFileDescriptorUsage: *maxFDUsage,
+	})
+	if err != nil {
+		return err
+	}
+	if len(report.Degraded) == 0 {
+		fmt.Println("✅ squid is healthy")
+		return nil
+	}
+	degraded := strings.Join(report.Degraded, ", ")
+	if command == "ready" && *failDegraded {
+		return fmt.Errorf("squid is degraded: %s", degraded)
+	}
+	fmt.Printf("⚠️ squid is degraded: %s\n", degraded)
+	return nil
+}
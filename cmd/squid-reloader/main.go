@@ -0,0 +1,52 @@
+// squid-reloader runs next to squid and applies configuration changes without
+// a restart, see internal/reloader.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/reloader"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	watch := flag.String("watch", "/etc/squid/config", "Comma-separated directories to watch for changes")
+	config := flag.String("config", "/etc/squid/config/squid.conf", "Path of the squid configuration file")
+	squid := flag.String("squid", "/usr/sbin/squid", "Path of the squid binary")
+	interval := flag.Duration("interval", 5*time.Second, "How often to check for changes")
+	listen := flag.String("listen", ":9303", "Address to serve metrics on")
+	flag.Parse()
+
+	registry := prometheus.NewRegistry()
+	r := reloader.New(strings.Split(*watch, ","), *config, *interval, reloader.Exec(*squid), registry)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	go func() {
+		if err := http.ListenAndServe(*listen, mux); err != nil {
+			fmt.Printf("❌ Metrics server failed: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("🚀 Watching %s for squid configuration changes (metrics on %s)\n", *watch, *listen)
+	if err := r.Watch(ctx); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/storeid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// cluster reaches the squid pods of a namespace through the kubeconfig
+type cluster struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+	namespace string
+}
+
+// connect loads the kubeconfig the way kubectl does: from kubeconfig if set,
+// otherwise from $KUBECONFIG or ~/.kube/config
+func connect(kubeconfig, kubeContext, namespace string) (*cluster, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return &cluster{config: config, clientset: clientset, namespace: namespace}, nil
+}
+
+// pods lists the running pods matching selector, sorted by name. A non-empty
+// name selects that pod only.
+func (c *cluster) pods(ctx context.Context, selector, name string) ([]string, error) {
+	list, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list squid pods: %w", err)
+	}
+	var pods []string
+	for _, pod := range list.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if name == "" || pod.Name == name {
+			pods = append(pods, pod.Name)
+		}
+	}
+	if len(pods) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("no running pod %s matching %q in namespace %s", name, selector, c.namespace)
+		}
+		return nil, fmt.Errorf("no running pods matching %q in namespace %s", selector, c.namespace)
+	}
+	sort.Strings(pods)
+	return pods, nil
+}
+
+// cachemgrCredentials reads the cache manager login and password from a
+// Secret with login and password keys. Without the Secret squid does not
+// require them.
+func (c *cluster) cachemgrCredentials(ctx context.Context, name string) (string, string, error) {
+	if name == "" {
+		return "", "", nil
+	}
+	secret, err := c.clientset.CoreV1().Secrets(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the cache manager credentials (use -cachemgr-secret= if squid does not require them): %w", err)
+	}
+	return string(secret.Data["login"]), string(secret.Data["password"]), nil
+}
+
+// storeIDRewriter reads the store ID rules squid's helper applies from the
+// key of a ConfigMap. Without the ConfigMap or the key, squid has no store ID
+// helper and the rewriter is nil.
+func (c *cluster) storeIDRewriter(ctx context.Context, name, key string) (*storeid.Rewriter, error) {
+	if name == "" {
+		return nil, nil
+	}
+	configMap, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the store ID rules (use -config-map= if squid has none): %w", err)
+	}
+	rules, ok := configMap.Data[key]
+	if !ok {
+		return nil, nil
+	}
+	rewriter, err := storeid.ParseRewriter([]byte(rules))
+	if err != nil {
+		return nil, fmt.Errorf("ConfigMap %s: %w", name, err)
+	}
+	return rewriter, nil
+}
+
+// portForward forwards a local port to port of pod until ctx is done and
+// returns the local address
+func (c *cluster) portForward(ctx context.Context, pod string, port int) (string, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(c.config)
+	if err != nil {
+		return "", err
+	}
+	url := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(c.namespace).Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	ready := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{"0:" + strconv.Itoa(port)},
+		ctx.Done(), ready, io.Discard, io.Discard)
+	if err != nil {
+		return "", err
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-failed:
+		return "", fmt.Errorf("failed to port-forward to %s: %w", pod, err)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(ports[0].Local))), nil
+}
+
+// exec runs command in a container of pod and returns its combined output
+func (c *cluster) exec(ctx context.Context, pod, container string, command ...string) (string, error) {
+	request := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(c.namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(c.config, http.MethodPost, request.URL())
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &output, Stderr: &output})
+	return output.String(), err
+}
+
+// logs returns the log lines a container of pod wrote in the last since, or
+// only the last tail lines if tail is positive
+func (c *cluster) logs(ctx context.Context, pod, container string, since time.Duration, tail int64) ([]string, error) {
+	options := &corev1.PodLogOptions{Container: container}
+	if since > 0 {
+		seconds := int64(since.Seconds())
+		options.SinceSeconds = &seconds
+	}
+	if tail > 0 {
+		options.TailLines = &tail
+	}
+	stream, err := c.clientset.CoreV1().Pods(c.namespace).GetLogs(pod, options).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the logs: %w", err)
+	}
+	defer stream.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
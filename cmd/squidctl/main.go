@@ -0,0 +1,650 @@
+// squidctl inspects and operates a deployed cache from a workstation. It finds
+// the squid pods through the kubeconfig, reaches every replica over a
+// port-forward and aggregates what they report, see internal/squidctl.
+//
+// Usage:
+//
+//	squidctl info                       version, uptime, hit ratios and cache size
+//	squidctl stats                      client and server counters since start
+//	squidctl objects [-match RE]        objects held in memory
+//	squidctl purge -match RE | URL...   evict objects from every replica
+//	squidctl reconfigure                re-read squid.conf in every replica
+//	squidctl logs [-parsed] [-since D]  logs of the squid containers
+//	squidctl hit-ratio [-since D]       hit ratio over the access logs
+//
+// Every command takes -namespace, -selector, -pod, -context, -kubeconfig,
+// -cachemgr-secret and -o table|json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+	"github.com/konflux-ci/caching/internal/purge"
+	"github.com/konflux-ci/caching/internal/squidclient"
+	"github.com/konflux-ci/caching/internal/squidctl"
+)
+
+const usage = `usage: squidctl <command> [flags]
+
+Commands:
+  info         version, uptime, hit ratios and cache size of every replica
+  stats        client and server counters since start
+  objects      objects held in memory (-match RE)
+  purge        evict objects from every replica (-match RE or URLs)
+  reconfigure  re-read squid.conf in every replica
+  logs         logs of the squid containers (-parsed, -since, -tail)
+  hit-ratio    hit ratio over the access logs (-since)
+
+Run "squidctl <command> -h" for the flags of a command.`
+
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"info":        runInfo,
+	"stats":       runStats,
+	"objects":     runObjects,
+	"purge":       runPurge,
+	"reconfigure": runReconfigure,
+	"logs":        runLogs,
+	"hit-ratio":   runHitRatio,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Printf("❌ unknown command %q\n\n%s\n", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := command(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// options are the flags every command takes
+type options struct {
+	kubeconfig     string
+	kubeContext    string
+	namespace      string
+	selector       string
+	pod            string
+	cachemgrSecret string
+	output         string
+}
+
+// newFlagSet returns the flag set of a command with the common flags
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	opts := &options{}
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "Path to the kubeconfig ($KUBECONFIG or ~/.kube/config if empty)")
+	flags.StringVar(&opts.kubeContext, "context", "", "kubeconfig context (the current one if empty)")
+	flags.StringVar(&opts.namespace, "namespace", "proxy", "Namespace of the squid deployment")
+	flags.StringVar(&opts.selector, "selector", "app.kubernetes.io/name=squid,app.kubernetes.io/component=squid-proxy",
+		"Label selector of the squid pods")
+	flags.StringVar(&opts.pod, "pod", "", "Only talk to this replica")
+	flags.StringVar(&opts.cachemgrSecret, "cachemgr-secret", "squid-cachemgr",
+		"Secret with the cache manager login and password, if squid requires them")
+	flags.StringVar(&opts.output, "o", "table", "Output format: table or json")
+	return flags, opts
+}
+
+// env is what a command needs to talk to the replicas
+type env struct {
+	*options
+	cluster *cluster
+	pods    []string
+
+	login, password string
+}
+
+// setup validates the common flags and finds the squid pods. The cache
+// manager credentials are only read by the commands that need them.
+func (o *options) setup(ctx context.Context, cachemgr bool) (*env, error) {
+	if o.output != "table" && o.output != "json" {
+		return nil, fmt.Errorf("invalid output format %q, expected table or json", o.output)
+	}
+	cluster, err := connect(o.kubeconfig, o.kubeContext, o.namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := cluster.pods(ctx, o.selector, o.pod)
+	if err != nil {
+		return nil, err
+	}
+	e := &env{options: o, cluster: cluster, pods: pods}
+	if cachemgr {
+		if e.login, e.password, err = cluster.cachemgrCredentials(ctx, o.cachemgrSecret); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// squid port-forwards to the squid of pod and returns a client for it. The
+// port-forward is closed when ctx is done.
+func (e *env) squid(ctx context.Context, pod string) (*squidclient.Client, error) {
+	addr, err := e.cluster.portForward(ctx, pod, 3128)
+	if err != nil {
+		return nil, err
+	}
+	// Squid only serves the cache manager and PURGE requests to localhost,
+	// which is where port-forwarded connections come from
+	client := squidclient.New(addr)
+	client.Login, client.Password = e.login, e.password
+	return client, nil
+}
+
+// result is what a command got from one replica
+type result[T any] struct {
+	pod   string
+	value T
+	err   error
+}
+
+// forEach runs fn for every pod concurrently and returns the results in the
+// order of the pods
+func forEach[T any](ctx context.Context, pods []string, fn func(ctx context.Context, pod string) (T, error)) []result[T] {
+	results := make([]result[T], len(pods))
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			value, err := fn(ctx, pod)
+			results[i] = result[T]{pod: pod, value: value, err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// failures reports the replicas that failed on stderr and returns an error if
+// there were any
+func failures[T any](results []result[T]) error {
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", r.pod, r.err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replicas failed", failed, len(results))
+	}
+	return nil
+}
+
+// replicaOutput is the JSON output of a command for one replica
+type replicaOutput[T any] struct {
+	Replica string `json:"replica"`
+	Result  *T     `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// replicaOutputs converts the results with convert for the JSON output
+func replicaOutputs[T, O any](results []result[T], convert func(T) O) []replicaOutput[O] {
+	outputs := make([]replicaOutput[O], len(results))
+	for i, r := range results {
+		outputs[i].Replica = r.pod
+		if r.err != nil {
+			outputs[i].Error = r.err.Error()
+			continue
+		}
+		value := convert(r.value)
+		outputs[i].Result = &value
+	}
+	return outputs
+}
+
+func identity[T any](value T) T {
+	return value
+}
+
+func writeJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func runInfo(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("info")
+	flags.Parse(args)
+	e, err := opts.setup(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) (squidctl.Info, error) {
+		client, err := e.squid(ctx, pod)
+		if err != nil {
+			return squidctl.Info{}, err
+		}
+		page, err := client.Mgr(ctx, "info")
+		if err != nil {
+			return squidctl.Info{}, err
+		}
+		return squidctl.ParseInfo(page), nil
+	})
+	var total squidctl.InfoTotal
+	for _, r := range results {
+		if r.err == nil {
+			total.Add(r.value)
+		}
+	}
+
+	if e.output == "json" {
+		err = writeJSON(struct {
+			Replicas []replicaOutput[squidctl.Info] `json:"replicas"`
+			Total    squidctl.InfoTotal             `json:"total"`
+		}{replicaOutputs(results, identity[squidctl.Info]), total})
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "VERSION", "UPTIME", "CLIENTS", "REQUESTS",
+			"HIT% (5m)", "BYTE HIT% (5m)", "OBJECTS", "MEMORY", "DISK"}}
+		for _, r := range results {
+			if r.err != nil {
+				continue
+			}
+			info := r.value
+			table.Append(r.pod, info.Version, info.Uptime.String(), strconv.FormatInt(info.Clients, 10),
+				strconv.FormatInt(info.Requests, 10), squidctl.Percent(info.RequestHitRatio), squidctl.Percent(info.ByteHitRatio),
+				strconv.FormatInt(info.StoreEntries, 10), squidctl.KB(float64(info.MemSizeKB)), squidctl.KB(float64(info.SwapSizeKB)))
+		}
+		table.Append("TOTAL", "", "", strconv.FormatInt(total.Clients, 10), strconv.FormatInt(total.Requests, 10), "", "",
+			strconv.FormatInt(total.StoreEntries, 10), squidctl.KB(float64(total.MemSizeKB)), squidctl.KB(float64(total.SwapSizeKB)))
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
+
+// count formats a counter without an exponent
+func count(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func runStats(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("stats")
+	flags.Parse(args)
+	e, err := opts.setup(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) (map[string]float64, error) {
+		client, err := e.squid(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		page, err := client.Mgr(ctx, "counters")
+		if err != nil {
+			return nil, err
+		}
+		return squidctl.ParseCounters(page), nil
+	})
+	var counters []map[string]float64
+	for _, r := range results {
+		if r.err == nil {
+			counters = append(counters, r.value)
+		}
+	}
+	total := squidctl.SumCounters(counters...)
+
+	if e.output == "json" {
+		err = writeJSON(struct {
+			Replicas []replicaOutput[map[string]float64] `json:"replicas"`
+			Total    map[string]float64                  `json:"total"`
+		}{replicaOutputs(results, identity[map[string]float64]), total})
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "REQUESTS", "HITS", "HIT%", "ERRORS", "SENT", "BYTE HIT%",
+			"SERVER REQUESTS", "RECEIVED"}}
+		row := func(name string, stats squidctl.Stats) {
+			table.Append(name, count(stats.Requests), count(stats.Hits), squidctl.Percent(stats.HitRatio()),
+				count(stats.Errors), squidctl.KB(stats.KBytesOut), squidctl.Percent(stats.ByteHitRatio()),
+				count(stats.ServerRequests), squidctl.KB(stats.ServerKBytesIn))
+		}
+		for _, r := range results {
+			if r.err == nil {
+				row(r.pod, squidctl.StatsFromCounters(r.value))
+			}
+		}
+		row("TOTAL", squidctl.StatsFromCounters(total))
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
+
+// matchingObjects lists the objects in the memory of a replica whose URL or
+// store ID matches re
+func matchingObjects(ctx context.Context, client *squidclient.Client, re *regexp.Regexp) ([]string, error) {
+	objects, err := client.Objects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matching := []string{}
+	for _, object := range objects {
+		if re.MatchString(object) {
+			matching = append(matching, object)
+		}
+	}
+	return matching, nil
+}
+
+func runObjects(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("objects")
+	match := flags.String("match", "", "Regular expression the URLs or store IDs must match")
+	flags.Parse(args)
+	re, err := regexp.Compile(*match)
+	if err != nil {
+		return fmt.Errorf("invalid -match: %w", err)
+	}
+	e, err := opts.setup(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) ([]string, error) {
+		client, err := e.squid(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		return matchingObjects(ctx, client, re)
+	})
+
+	if e.output == "json" {
+		err = writeJSON(struct {
+			Replicas []replicaOutput[[]string] `json:"replicas"`
+		}{replicaOutputs(results, identity[[]string])})
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "URL"}}
+		for _, r := range results {
+			for _, object := range r.value {
+				table.Append(r.pod, object)
+			}
+		}
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
+
+func runPurge(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("purge")
+	match := flags.String("match", "", "Purge the objects in memory whose URL or store ID matches this regular expression")
+	configMap := flags.String("config-map", "squid-config", "ConfigMap with the store ID rules of squid, none if empty")
+	flags.Parse(args)
+	urls := flags.Args()
+	if (*match == "") == (len(urls) == 0) {
+		return fmt.Errorf("either -match or URLs to purge are required")
+	}
+	re, err := regexp.Compile(*match)
+	if err != nil {
+		return fmt.Errorf("invalid -match: %w", err)
+	}
+	e, err := opts.setup(ctx, *match != "")
+	if err != nil {
+		return err
+	}
+	// Like the purge API, purge a URL under its store ID too, which is where
+	// squid caches the URLs the store ID helper rewrites
+	var targets []string
+	if len(urls) > 0 {
+		rewriter, err := e.cluster.storeIDRewriter(ctx, *configMap, "store-id-rules.yaml")
+		if err != nil {
+			return err
+		}
+		for _, url := range urls {
+			targets = append(targets, url)
+			if rewriter == nil {
+				continue
+			}
+			if storeID, ok := rewriter.Rewrite(url); ok {
+				targets = append(targets, storeID)
+			}
+		}
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) ([]string, error) {
+		client, err := e.squid(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		candidates := targets
+		if *match != "" {
+			if candidates, err = matchingObjects(ctx, client, re); err != nil {
+				return nil, err
+			}
+		}
+		purged := []string{}
+		for _, candidate := range candidates {
+			ok, err := client.Purge(ctx, candidate)
+			if err != nil {
+				return purged, fmt.Errorf("%w (is purgeApi.enabled set? squid only accepts PURGE with it)", err)
+			}
+			if ok {
+				purged = append(purged, candidate)
+			}
+		}
+		return purged, nil
+	})
+
+	if e.output == "json" {
+		// The purge API's response format
+		var response purge.Response
+		for _, r := range results {
+			replica := purge.ReplicaResult{Replica: r.pod, Purged: r.value}
+			if replica.Purged == nil {
+				replica.Purged = []string{}
+			}
+			if r.err != nil {
+				replica.Error = r.err.Error()
+			}
+			response.Replicas = append(response.Replicas, replica)
+		}
+		err = writeJSON(response)
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "PURGED"}}
+		for _, r := range results {
+			for _, url := range r.value {
+				table.Append(r.pod, url)
+			}
+		}
+		if len(table.Rows) == 0 {
+			fmt.Println("Nothing was cached, nothing to purge")
+		} else {
+			err = table.Write(os.Stdout)
+		}
+	}
+	return errors.Join(err, failures(results))
+}
+
+func runReconfigure(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("reconfigure")
+	config := flags.String("config", "/etc/squid/config/squid.conf", "Path of squid.conf in the squid container")
+	flags.Parse(args)
+	e, err := opts.setup(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) (string, error) {
+		// Like the config reloader, refuse a configuration squid can't parse
+		// rather than have squid exit on it
+		if output, err := e.cluster.exec(ctx, pod, "squid", "/usr/sbin/squid", "-k", "parse", "-f", *config); err != nil {
+			return "", fmt.Errorf("configuration is invalid: %w\n%s", err, strings.TrimSpace(output))
+		}
+		if output, err := e.cluster.exec(ctx, pod, "squid", "/usr/sbin/squid", "-k", "reconfigure", "-f", *config); err != nil {
+			return "", fmt.Errorf("reconfigure failed: %w\n%s", err, strings.TrimSpace(output))
+		}
+		return "reconfigured", nil
+	})
+
+	if e.output == "json" {
+		err = writeJSON(struct {
+			Replicas []replicaOutput[string] `json:"replicas"`
+		}{replicaOutputs(results, identity[string])})
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "RESULT"}}
+		for _, r := range results {
+			if r.err == nil {
+				table.Append(r.pod, r.value)
+			}
+		}
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
+
+// logEntry is an access log entry of a replica
+type logEntry struct {
+	Replica string `json:"replica"`
+	accesslog.Entry
+}
+
+func runLogs(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("logs")
+	since := flags.Duration("since", 10*time.Minute, "Only show the logs of this last period (all if 0)")
+	tail := flags.Int64("tail", 0, "Only show the last lines of every replica (all if 0)")
+	parsed := flags.Bool("parsed", false, "Only show access log entries, parsed and merged by time")
+	flags.Parse(args)
+	e, err := opts.setup(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) ([]string, error) {
+		return e.cluster.logs(ctx, pod, "squid", *since, *tail)
+	})
+
+	if !*parsed {
+		for _, r := range results {
+			for _, line := range r.value {
+				if e.output == "json" {
+					err = errors.Join(err, json.NewEncoder(os.Stdout).Encode(struct {
+						Replica string `json:"replica"`
+						Line    string `json:"line"`
+					}{r.pod, line}))
+				} else {
+					fmt.Printf("%s %s\n", r.pod, line)
+				}
+			}
+		}
+		return errors.Join(err, failures(results))
+	}
+
+	// Cache log messages and egress audit records are skipped
+	var entries []logEntry
+	for _, r := range results {
+		for _, line := range r.value {
+			if entry, err := accesslog.ParseLine(line); err == nil {
+				entries = append(entries, logEntry{Replica: r.pod, Entry: entry})
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	if e.output == "json" {
+		// One object per line, like squid's JSON access log
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			err = errors.Join(err, encoder.Encode(entry))
+		}
+	} else {
+		table := squidctl.Table{Header: []string{"TIME", "REPLICA", "CLIENT", "RESULT", "STATUS", "BYTES", "ELAPSED",
+			"METHOD", "URL"}}
+		for _, entry := range entries {
+			table.Append(entry.Time.Local().Format("15:04:05.000"), entry.Replica, entry.Client, entry.ResultCode,
+				strconv.Itoa(entry.Status), strconv.FormatInt(entry.Bytes, 10), entry.Elapsed.String(), entry.Method, entry.URL)
+		}
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
+
+// hitRatio is the JSON output of hit-ratio for a replica or the total
+type hitRatio struct {
+	squidctl.LogStats
+	HitRatio     *float64 `json:"hitRatio"`
+	ByteHitRatio *float64 `json:"byteHitRatio"`
+}
+
+func newHitRatio(stats squidctl.LogStats) hitRatio {
+	return hitRatio{stats, squidctl.JSONRatio(stats.HitRatio()), squidctl.JSONRatio(stats.ByteHitRatio())}
+}
+
+func runHitRatio(ctx context.Context, args []string) error {
+	flags, opts := newFlagSet("hit-ratio")
+	since := flags.Duration("since", time.Hour, "Period of the access logs to count")
+	flags.Parse(args)
+	if *since <= 0 {
+		return fmt.Errorf("-since must be positive")
+	}
+	e, err := opts.setup(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now().Add(-*since)
+	results := forEach(ctx, e.pods, func(ctx context.Context, pod string) (squidctl.LogStats, error) {
+		var stats squidctl.LogStats
+		lines, err := e.cluster.logs(ctx, pod, "squid", *since, 0)
+		if err != nil {
+			return stats, err
+		}
+		for _, line := range lines {
+			if entry, err := accesslog.ParseLine(line); err == nil && !entry.Time.Before(start) {
+				stats.Observe(entry)
+			}
+		}
+		return stats, nil
+	})
+	var total squidctl.LogStats
+	for _, r := range results {
+		if r.err == nil {
+			total.Add(r.value)
+		}
+	}
+
+	if e.output == "json" {
+		err = writeJSON(struct {
+			Since    string                    `json:"since"`
+			Replicas []replicaOutput[hitRatio] `json:"replicas"`
+			Total    hitRatio                  `json:"total"`
+		}{start.UTC().Format(time.RFC3339), replicaOutputs(results, newHitRatio), newHitRatio(total)})
+	} else {
+		table := squidctl.Table{Header: []string{"REPLICA", "REQUESTS", "HITS", "MISSES", "DENIED", "HIT%", "BYTE HIT%"}}
+		row := func(name string, stats squidctl.LogStats) {
+			table.Append(name, strconv.FormatInt(stats.Requests, 10), strconv.FormatInt(stats.Hits, 10),
+				strconv.FormatInt(stats.Misses, 10), strconv.FormatInt(stats.Denied, 10),
+				squidctl.Percent(stats.HitRatio()), squidctl.Percent(stats.ByteHitRatio()))
+		}
+		for _, r := range results {
+			if r.err == nil {
+				row(r.pod, r.value)
+			}
+		}
+		row("TOTAL", total)
+		err = table.Write(os.Stdout)
+	}
+	return errors.Join(err, failures(results))
+}
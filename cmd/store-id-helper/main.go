@@ -0,0 +1,28 @@
+// store-id-helper is a squid store_id_program that maps URLs to stable store
+// IDs according to a rules file, see internal/storeid.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/konflux-ci/caching/internal/storeid"
+)
+
+func main() {
+	rulesPath := flag.String("rules", "/etc/squid/config/store-id-rules.yaml", "Path to the store ID rules file (YAML or JSON)")
+	flag.Parse()
+
+	rewriter, err := storeid.LoadRewriter(*rulesPath)
+	if err != nil {
+		// stdout belongs to the helper protocol, squid logs our stderr
+		fmt.Fprintf(os.Stderr, "store-id-helper: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := rewriter.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "store-id-helper: %v\n", err)
+		os.Exit(1)
+	}
+}
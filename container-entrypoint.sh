@@ -1,7 +1,111 @@
 #!/bin/bash
 
+SQUID_CONF=${SQUID_CONF:-/etc/squid/squid.conf}
+SQUID_PID=/run/squid/squid.pid
+PEERS_CONF=/run/squid/peers.conf
+PEER_ADDRESSES=/run/squid/peer-addresses
+CACHEMGR_CONF=/run/squid/cachemgr.conf
+PARENTS_CONF=/run/squid/parents.conf
+DRAINING=/run/squid/draining
+
+# a pid file left behind by a previous container run would make squid
+# believe it is already running
+rm -f "${SQUID_PID}"
+# nor should a drain started before the container restarted keep it unready
+rm -f "${DRAINING}"
+
+# peer_addresses prints the address of every replica behind
+# SQUID_PEERS_SERVICE except the one of this pod
+peer_addresses() {
+    getent ahostsv4 "${SQUID_PEERS_SERVICE}" | while read -r ip _; do
+        echo "${ip}"
+    done | sort -u | while read -r ip; do
+        if [ "${ip}" = "${POD_IP}" ]; then
+            continue
+        fi
+        echo "${ip}"
+    done
+}
+
+# write_peers writes a cache_peer sibling entry for every given address, and
+# the addresses themselves for the squid_peers acl
+write_peers() {
+    local ip
+    : > "${PEERS_CONF}"
+    : > "${PEER_ADDRESSES}"
+    for ip in $1; do
+        echo "cache_peer ${ip} sibling ${SQUID_HTTP_PORT:-3128} ${SQUID_PEERS_PORT:-3130} ${SQUID_PEERS_OPTIONS} name=peer-${ip//./-}" >> "${PEERS_CONF}"
+        echo "${ip}" >> "${PEER_ADDRESSES}"
+    done
+}
+
+# refresh_peers regenerates the peer list periodically and reconfigures
+# squid whenever replicas were added or removed
+refresh_peers() {
+    local addresses
+    while sleep "${SQUID_PEERS_REFRESH_INTERVAL:-30}"; do
+        addresses="$(peer_addresses)"
+        if [ "${addresses}" = "$(< "${PEER_ADDRESSES}")" ]; then
+            continue
+        fi
+        write_peers "${addresses}"
+        echo "cache peers changed, reconfiguring squid"
+        /usr/sbin/squid -f "${SQUID_CONF}" -k reconfigure
+    done
+}
+
+# the squid configuration includes the peer list and addresses, so they have
+# to exist even when no peers are configured
+write_peers ""
+if [ -n "${SQUID_PEERS_SERVICE}" ]; then
+    write_peers "$(peer_addresses)"
+    refresh_peers &
+fi
+
+# the cache manager password comes from a mounted Secret rather than the
+# squid configuration, which lives in a ConfigMap
+if [ -n "${SQUID_CACHEMGR_PASSWORD_FILE}" ]; then
+    password="$(< "${SQUID_CACHEMGR_PASSWORD_FILE}")"
+    if [ -z "${password}" ] || [[ "${password}" =~ [[:space:]] ]]; then
+        echo "the cache manager password must be set and must not contain whitespace" >&2
+        exit 1
+    fi
+    (umask 077 && echo "cachemgr_passwd ${password} all" > "${CACHEMGR_CONF}")
+    unset password
+fi
+
+# write_parents copies the parent proxies from SQUID_PARENTS_CONF, putting
+# the credentials of every parent with login=@NAME in place from the Secret
+# mounted at SQUID_PARENTS_CREDENTIALS/NAME, so that they stay out of the
+# ConfigMap
+write_parents() {
+    local line name username password
+    : > "${PARENTS_CONF}"
+    while IFS= read -r line; do
+        if [[ "${line}" =~ login=@([a-z0-9-]+) ]]; then
+            name="${BASH_REMATCH[1]}"
+            username="$(< "${SQUID_PARENTS_CREDENTIALS}/${name}/username")" || return 1
+            password="$(< "${SQUID_PARENTS_CREDENTIALS}/${name}/password")" || return 1
+            if [ -z "${username}" ] || [[ "${username}${password}" =~ [[:space:]] ]] || [[ "${username}" == *:* ]]; then
+                echo "the credentials of parent proxy ${name} must be set, must not contain whitespace and the username must not contain ':'" >&2
+                return 1
+            fi
+            # login= values may hold URL escapes, so % has to be written as %%
+            username="${username//%/%%}"
+            password="${password//%/%%}"
+            line="${line/"login=@${name}"/"login=${username}:${password}"}"
+        fi
+        echo "${line}" >> "${PARENTS_CONF}"
+    done < "${SQUID_PARENTS_CONF}"
+}
+
+if [ -n "${SQUID_PARENTS_CONF}" ]; then
+    (umask 077 && write_parents) || exit 1
+fi
+
 # in case of using cache dir, we need to initialize it
-/usr/sbin/squid -d 1 --foreground -f /etc/squid/squid.conf -z
+/usr/sbin/squid -d 1 --foreground -f "${SQUID_CONF}" -z
 
-# now start the squid primary process with supplied options
-/usr/sbin/squid -d 1 --foreground -f /etc/squid/squid.conf $@
+# now start the squid primary process with supplied options, replacing this
+# shell so that squid receives SIGTERM and shuts down gracefully
+exec /usr/sbin/squid -d 1 --foreground -f "${SQUID_CONF}" "$@"
@@ -0,0 +1,123 @@
+// Package accesslog parses squid's access log as the chart writes it to the
+// squid container's stdout, in squid's native format or as JSON (see
+// accessLog.format).
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a parsed access log line
+type Entry struct {
+	Time       time.Time     `json:"time"`
+	Client     string        `json:"client"`
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	Status     int           `json:"status"`
+	ResultCode string        `json:"resultCode"`
+	Bytes      int64         `json:"bytes"`
+	Elapsed    time.Duration `json:"elapsed"`
+	Hierarchy  string        `json:"hierarchy"`
+	MimeType   string        `json:"mimeType"`
+}
+
+// jsonEntry is a line of the JSON format with the chart's default
+// accessLog.json.fields. Extra fields are ignored.
+type jsonEntry struct {
+	Timestamp  float64 `json:"timestamp"`
+	Client     string  `json:"client"`
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	Status     int     `json:"status"`
+	ResultCode string  `json:"result_code"`
+	Bytes      int64   `json:"bytes"`
+	ElapsedMs  int64   `json:"elapsed_ms"`
+	Hierarchy  string  `json:"hierarchy"`
+	MimeType   string  `json:"mime_type"`
+}
+
+// ParseLine parses an access log line in either format. Other lines the
+// squid container writes, such as cache.log messages and egress audit
+// records, are rejected.
+func ParseLine(line string) (Entry, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		return parseJSON(line)
+	}
+	return parseNative(line)
+}
+
+func parseJSON(line string) (Entry, error) {
+	var raw jsonEntry
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return Entry{}, fmt.Errorf("invalid JSON access log line: %w", err)
+	}
+	if raw.Timestamp <= 0 || raw.Method == "" || raw.URL == "" || raw.ResultCode == "" {
+		return Entry{}, fmt.Errorf("JSON access log line lacks timestamp, method, url or result_code")
+	}
+	return Entry{
+		Time:       timestamp(raw.Timestamp),
+		Client:     raw.Client,
+		Method:     raw.Method,
+		URL:        raw.URL,
+		Status:     raw.Status,
+		ResultCode: raw.ResultCode,
+		Bytes:      raw.Bytes,
+		Elapsed:    time.Duration(raw.ElapsedMs) * time.Millisecond,
+		Hierarchy:  raw.Hierarchy,
+		MimeType:   raw.MimeType,
+	}, nil
+}
+
+// parseNative parses squid's native format:
+//
+//	%ts.%03tu %6tr %>a %Ss/%03>Hs %<st %rm %ru %[un %Sh/%<a %mt
+func parseNative(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 {
+		return Entry{}, fmt.Errorf("expected at least 9 fields, got %d", len(fields))
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || seconds <= 0 {
+		return Entry{}, fmt.Errorf("invalid timestamp %q", fields[0])
+	}
+	elapsed, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid response time %q", fields[1])
+	}
+	resultCode, statusText, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return Entry{}, fmt.Errorf("invalid result code %q", fields[3])
+	}
+	status, err := strconv.Atoi(statusText)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid status %q", statusText)
+	}
+	bytes, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil || bytes < 0 {
+		return Entry{}, fmt.Errorf("invalid reply size %q", fields[4])
+	}
+	hierarchy, _, _ := strings.Cut(fields[8], "/")
+	return Entry{
+		Time:       timestamp(seconds),
+		Client:     fields[2],
+		Method:     fields[5],
+		URL:        fields[6],
+		Status:     status,
+		ResultCode: resultCode,
+		Bytes:      bytes,
+		Elapsed:    time.Duration(elapsed) * time.Millisecond,
+		Hierarchy:  hierarchy,
+		MimeType:   strings.Join(fields[9:], " "),
+	}, nil
+}
+
+// timestamp converts squid's seconds with millisecond precision
+func timestamp(seconds float64) time.Time {
+	return time.UnixMilli(int64(math.Round(seconds * 1000))).UTC()
+}
@@ -0,0 +1,60 @@
+package accesslog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	want := Entry{
+		Time:       time.Date(2023, 11, 14, 22, 13, 20, 123000000, time.UTC),
+		Client:     "10.244.0.7",
+		Method:     "GET",
+		URL:        "http://quay.io/v2/org/image/blobs/sha256:ab",
+		Status:     200,
+		ResultCode: "TCP_MEM_HIT",
+		Bytes:      2048,
+		Elapsed:    45 * time.Millisecond,
+		Hierarchy:  "HIER_NONE",
+		MimeType:   "application/octet-stream",
+	}
+
+	for name, line := range map[string]string{
+		"native": "1700000000.123     45 10.244.0.7 TCP_MEM_HIT/200 2048 GET http://quay.io/v2/org/image/blobs/sha256:ab - HIER_NONE/- application/octet-stream",
+		"json": `{"timestamp":1700000000.123,"client":"10.244.0.7","method":"GET","url":"http://quay.io/v2/org/image/blobs/sha256:ab",` +
+			`"status":200,"result_code":"TCP_MEM_HIT","bytes":2048,"elapsed_ms":45,"hierarchy":"HIER_NONE",` +
+			`"mime_type":"application/octet-stream","request_id":"-","user_agent":"podman"}`,
+	} {
+		got, err := ParseLine(line)
+		if err != nil {
+			t.Errorf("%s: ParseLine() error = %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: ParseLine() = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestParseLineContentTypeWithSpaces(t *testing.T) {
+	entry, err := ParseLine("1700000000.000 3 10.0.0.1 TCP_MISS/200 10 GET http://example.com/ - HIER_DIRECT/93.184.216.34 text/html; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.MimeType != "text/html; charset=utf-8" || entry.Hierarchy != "HIER_DIRECT" {
+		t.Errorf("ParseLine() = %+v", entry)
+	}
+}
+
+func TestParseLineRejectsOtherLines(t *testing.T) {
+	for _, line := range []string{
+		"2025/01/01 00:00:00| Accepting HTTP Socket connections at conn3 local=[::]:3128 remote=[::] FD 12 flags=9",
+		"EGRESS_AUDIT 1700000000.123 reason=denylisted client=10.0.0.1 method=GET url=http://example.com/",
+		`{"level":"info","msg":"cache peers changed"}`,
+		"",
+	} {
+		if entry, err := ParseLine(line); err == nil {
+			t.Errorf("ParseLine(%q) = %+v, want an error", line, entry)
+		}
+	}
+}
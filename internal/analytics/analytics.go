@@ -0,0 +1,356 @@
+// Package analytics turns squid's access log stream into cache metrics by
+// destination domain, client namespace and content type, which the
+// cache-manager counters scraped by squid-exporter cannot break down.
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/konflux-ci/caching/internal/podnamespace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LogFormat is the squid logformat of the access log stream: client address,
+// squid result code, reply size, destination domain and content type. The
+// content type goes last because it may contain spaces.
+const LogFormat = "%>a %Ss %<st %>rd %mt"
+
+const (
+	ResultHit    = "hit"
+	ResultMiss   = "miss"
+	ResultDenied = "denied"
+)
+
+var results = []string{ResultHit, ResultMiss, ResultDenied}
+
+const (
+	// Other aggregates the values beyond the cardinality limit and top N
+	Other = "other"
+	// Unknown stands for a missing domain or content type, or a client that
+	// is not a pod
+	Unknown = "unknown"
+)
+
+// Entry is a parsed access log line
+type Entry struct {
+	Client      string
+	Result      string
+	Bytes       float64
+	Domain      string
+	ContentType string
+}
+
+// ParseLine parses an access log line written in LogFormat
+func ParseLine(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return Entry{}, fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+	}
+	bytes, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || bytes < 0 {
+		return Entry{}, fmt.Errorf("invalid reply size %q", fields[2])
+	}
+	return Entry{
+		Client:      fields[0],
+		Result:      Classify(fields[1]),
+		Bytes:       bytes,
+		Domain:      normalizeDomain(fields[3]),
+		ContentType: normalizeContentType(strings.Join(fields[4:], " ")),
+	}, nil
+}
+
+// Classify maps a squid result code such as TCP_MEM_HIT to a result
+func Classify(code string) string {
+	switch {
+	case strings.Contains(code, "DENIED"):
+		return ResultDenied
+	case strings.Contains(code, "HIT"),
+		// Revalidated or, with the origin unreachable, stale cached copies
+		strings.Contains(code, "REFRESH_UNMODIFIED"),
+		strings.Contains(code, "REFRESH_FAIL_OLD"):
+		return ResultHit
+	default:
+		return ResultMiss
+	}
+}
+
+func normalizeDomain(domain string) string {
+	if domain == "-" || domain == "" {
+		return Unknown
+	}
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// normalizeContentType drops parameters such as the charset, which would
+// otherwise split a content type across several values
+func normalizeContentType(contentType string) string {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType == "-" || contentType == "" {
+		return Unknown
+	}
+	return contentType
+}
+
+// counts holds request and byte counts by result
+type counts struct {
+	requests [3]float64
+	bytes    [3]float64
+}
+
+func (c *counts) add(other *counts) {
+	for i := range results {
+		c.requests[i] += other.requests[i]
+		c.bytes[i] += other.bytes[i]
+	}
+}
+
+// since returns the counts accrued after earlier
+func (c *counts) since(earlier *counts) counts {
+	var d counts
+	for i := range results {
+		d.requests[i] = c.requests[i] - earlier.requests[i]
+		d.bytes[i] = c.bytes[i] - earlier.bytes[i]
+	}
+	return d
+}
+
+func (c *counts) total() float64 {
+	return c.requests[0] + c.requests[1] + c.requests[2]
+}
+
+// dimension counts requests by the values of one label. At most maxValues
+// values are tracked; requests for values first seen after that are counted
+// under Other.
+type dimension struct {
+	label     string
+	maxValues int
+	values    map[string]*counts
+	overflow  counts
+	// exported are the values exported individually, with what Other had
+	// reported for them before, which their series leave out
+	exported map[string]counts
+	// reported are the counts Other last reported for the other values
+	reported map[string]counts
+	requests *prometheus.Desc
+	bytes    *prometheus.Desc
+}
+
+func newDimension(label string, maxValues int) *dimension {
+	return &dimension{
+		label:     label,
+		maxValues: maxValues,
+		values:    map[string]*counts{},
+		exported:  map[string]counts{},
+		reported:  map[string]counts{},
+		requests: prometheus.NewDesc("squid_analytics_"+label+"_requests_total",
+			"Requests by "+strings.ReplaceAll(label, "_", " ")+" and cache result", []string{label, "result"}, nil),
+		bytes: prometheus.NewDesc("squid_analytics_"+label+"_bytes_total",
+			"Bytes sent to clients by "+strings.ReplaceAll(label, "_", " ")+" and cache result", []string{label, "result"}, nil),
+	}
+}
+
+func (d *dimension) observe(value string, result int, bytes float64) {
+	c, ok := d.values[value]
+	if !ok {
+		if len(d.values) >= d.maxValues {
+			c = &d.overflow
+		} else {
+			c = &counts{}
+			d.values[value] = c
+		}
+	}
+	c.requests[result]++
+	c.bytes[result] += bytes
+}
+
+// pick exports the topN values with the most requests. Values stay exported
+// once picked, even after they leave the top N, and a value picked after
+// Other reported it keeps that share in Other: neither series ever drops, as
+// counters must not.
+func (d *dimension) pick(topN int) {
+	values := make([]string, 0, len(d.values))
+	for value := range d.values {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		ti, tj := d.values[values[i]].total(), d.values[values[j]].total()
+		if ti != tj {
+			return ti > tj
+		}
+		return values[i] < values[j]
+	})
+	for _, value := range values[:min(len(values), topN)] {
+		if _, ok := d.exported[value]; !ok {
+			d.exported[value] = d.reported[value]
+			delete(d.reported, value)
+		}
+	}
+}
+
+// other returns the counts of Other, and records what it reports for every
+// value: the values beyond the cardinality limit, those not exported and
+// what was reported for the exported ones before they were picked
+func (d *dimension) other() counts {
+	other := d.overflow
+	for value, c := range d.values {
+		if reported, ok := d.exported[value]; ok {
+			other.add(&reported)
+		} else {
+			other.add(c)
+			d.reported[value] = *c
+		}
+	}
+	return other
+}
+
+// Collector is a prometheus.Collector exporting the access log stream as
+// squid_analytics_* counters
+type Collector struct {
+	resolver podnamespace.Resolver
+	topN     int
+
+	mu         sync.Mutex
+	dimensions []*dimension
+	lines      map[string]float64
+
+	linesDesc  *prometheus.Desc
+	valuesDesc *prometheus.Desc
+}
+
+// New returns a Collector that tracks up to maxValues values per dimension
+// and exports the topN of them by request count, as well as those that were
+// in the top N at an earlier scrape. Clients are attributed to
+// namespaces by resolver; with a nil resolver the namespace dimension is not
+// exported.
+func New(resolver podnamespace.Resolver, maxValues, topN int) *Collector {
+	labels := []string{"domain", "content_type"}
+	if resolver != nil {
+		labels = append(labels, "namespace")
+	}
+	c := &Collector{
+		resolver: resolver,
+		topN:     topN,
+		lines:    map[string]float64{"parsed": 0, "invalid": 0},
+		linesDesc: prometheus.NewDesc("squid_analytics_log_lines_total",
+			"Access log lines received, by whether they could be parsed", []string{"status"}, nil),
+		valuesDesc: prometheus.NewDesc("squid_analytics_tracked_values",
+			"Distinct values tracked per dimension; once it reaches the limit new values are counted as other",
+			[]string{"dimension"}, nil),
+	}
+	for _, label := range labels {
+		c.dimensions = append(c.dimensions, newDimension(label, maxValues))
+	}
+	return c
+}
+
+// Observe accounts a single access log line
+func (c *Collector) Observe(line string) error {
+	entry, err := ParseLine(line)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.lines["invalid"]++
+		return err
+	}
+	c.lines["parsed"]++
+
+	result := 0
+	for i := range results {
+		if results[i] == entry.Result {
+			result = i
+		}
+	}
+	for _, d := range c.dimensions {
+		d.observe(c.value(d.label, entry), result, entry.Bytes)
+	}
+	return nil
+}
+
+func (c *Collector) value(label string, entry Entry) string {
+	switch label {
+	case "domain":
+		return entry.Domain
+	case "content_type":
+		return entry.ContentType
+	default:
+		if namespace, ok := c.resolver.Namespace(entry.Client); ok {
+			return namespace
+		}
+		return Unknown
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.linesDesc
+	ch <- c.valuesDesc
+	for _, d := range c.dimensions {
+		ch <- d.requests
+		ch <- d.bytes
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for status, value := range c.lines {
+		ch <- prometheus.MustNewConstMetric(c.linesDesc, prometheus.CounterValue, value, status)
+	}
+	for _, d := range c.dimensions {
+		ch <- prometheus.MustNewConstMetric(c.valuesDesc, prometheus.GaugeValue, float64(len(d.values)), d.label)
+
+		d.pick(c.topN)
+		emit := func(value string, counts *counts) {
+			for i, result := range results {
+				ch <- prometheus.MustNewConstMetric(d.requests, prometheus.CounterValue, counts.requests[i], value, result)
+				ch <- prometheus.MustNewConstMetric(d.bytes, prometheus.CounterValue, counts.bytes[i], value, result)
+			}
+		}
+		for value, reported := range d.exported {
+			since := d.values[value].since(&reported)
+			emit(value, &since)
+		}
+		if other := d.other(); other.total() > 0 {
+			emit(Other, &other)
+		}
+	}
+}
+
+// Serve reads access log datagrams from conn until ctx is done. Squid sends
+// one line per datagram unless buffered_logs is on, so datagrams are split
+// into lines.
+func (c *Collector) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			// Unparsable lines are counted in squid_analytics_log_lines_total
+			_ = c.Observe(line)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package analytics
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type staticResolver map[string]string
+
+func (r staticResolver) Namespace(ip string) (string, bool) {
+	namespace, ok := r[ip]
+	return namespace, ok
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		entry Entry
+	}{
+		{
+			"10.244.0.10 TCP_MEM_HIT 1520 Registry.Example.com application/vnd.oci.image.manifest.v1+json",
+			Entry{"10.244.0.10", ResultHit, 1520, "registry.example.com", "application/vnd.oci.image.manifest.v1+json"},
+		},
+		{
+			"10.244.0.10 TCP_MISS 321 example.com text/html; charset=UTF-8",
+			Entry{"10.244.0.10", ResultMiss, 321, "example.com", "text/html"},
+		},
+		{
+			"10.244.0.10 TCP_REFRESH_UNMODIFIED 100 example.com -",
+			Entry{"10.244.0.10", ResultHit, 100, "example.com", Unknown},
+		},
+		{
+			"10.244.0.10 TCP_DENIED 3900 - text/html",
+			Entry{"10.244.0.10", ResultDenied, 3900, Unknown, "text/html"},
+		},
+		{
+			"10.244.0.10 TCP_TUNNEL 4096 example.com",
+			Entry{"10.244.0.10", ResultMiss, 4096, "example.com", Unknown},
+		},
+	}
+	for _, tt := range tests {
+		entry, err := ParseLine(tt.line)
+		if err != nil {
+			t.Errorf("ParseLine(%q): %v", tt.line, err)
+			continue
+		}
+		if entry != tt.entry {
+			t.Errorf("ParseLine(%q) = %+v, want %+v", tt.line, entry, tt.entry)
+		}
+	}
+
+	for _, line := range []string{"", "10.244.0.10 TCP_MISS", "10.244.0.10 TCP_MISS lots example.com"} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("ParseLine(%q) should fail", line)
+		}
+	}
+}
+
+func TestLogFormatMatchesChart(t *testing.T) {
+	squidConf, err := os.ReadFile("../../squid/squid.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(squidConf), "logformat analytics "+LogFormat+"\n") {
+		t.Errorf("squid.conf should define the analytics logformat as %q", LogFormat)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := New(staticResolver{"10.244.0.10": "tenant-a"}, 100, 10)
+	for _, line := range []string{
+		"10.244.0.10 TCP_MISS 1000 example.com application/json",
+		"10.244.0.10 TCP_MEM_HIT 1000 example.com application/json",
+		"10.244.0.11 TCP_HIT 500 example.com application/json",
+		"10.244.0.11 TCP_DENIED 10 blocked.example.com text/html",
+	} {
+		if err := c.Observe(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Observe("garbage"); err == nil {
+		t.Error("Observe should reject an invalid line")
+	}
+
+	expected := `
+# HELP squid_analytics_namespace_requests_total Requests by namespace and cache result
+# TYPE squid_analytics_namespace_requests_total counter
+squid_analytics_namespace_requests_total{namespace="tenant-a",result="denied"} 0
+squid_analytics_namespace_requests_total{namespace="tenant-a",result="hit"} 1
+squid_analytics_namespace_requests_total{namespace="tenant-a",result="miss"} 1
+squid_analytics_namespace_requests_total{namespace="unknown",result="denied"} 1
+squid_analytics_namespace_requests_total{namespace="unknown",result="hit"} 1
+squid_analytics_namespace_requests_total{namespace="unknown",result="miss"} 0
+# HELP squid_analytics_domain_bytes_total Bytes sent to clients by domain and cache result
+# TYPE squid_analytics_domain_bytes_total counter
+squid_analytics_domain_bytes_total{domain="blocked.example.com",result="denied"} 10
+squid_analytics_domain_bytes_total{domain="blocked.example.com",result="hit"} 0
+squid_analytics_domain_bytes_total{domain="blocked.example.com",result="miss"} 0
+squid_analytics_domain_bytes_total{domain="example.com",result="denied"} 0
+squid_analytics_domain_bytes_total{domain="example.com",result="hit"} 1500
+squid_analytics_domain_bytes_total{domain="example.com",result="miss"} 1000
+# HELP squid_analytics_log_lines_total Access log lines received, by whether they could be parsed
+# TYPE squid_analytics_log_lines_total counter
+squid_analytics_log_lines_total{status="invalid"} 1
+squid_analytics_log_lines_total{status="parsed"} 4
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"squid_analytics_namespace_requests_total", "squid_analytics_domain_bytes_total", "squid_analytics_log_lines_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorLimits(t *testing.T) {
+	// Track three domains, export the top two
+	c := New(nil, 3, 2)
+	for _, line := range []string{
+		"10.244.0.10 TCP_HIT 1 a.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 a.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 a.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 b.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 b.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 c.example.com text/plain",
+		// Beyond the limit of tracked domains
+		"10.244.0.10 TCP_HIT 1 d.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 d.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 d.example.com text/plain",
+		"10.244.0.10 TCP_HIT 1 d.example.com text/plain",
+	} {
+		if err := c.Observe(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `
+# HELP squid_analytics_domain_requests_total Requests by domain and cache result
+# TYPE squid_analytics_domain_requests_total counter
+squid_analytics_domain_requests_total{domain="a.example.com",result="denied"} 0
+squid_analytics_domain_requests_total{domain="a.example.com",result="hit"} 3
+squid_analytics_domain_requests_total{domain="a.example.com",result="miss"} 0
+squid_analytics_domain_requests_total{domain="b.example.com",result="denied"} 0
+squid_analytics_domain_requests_total{domain="b.example.com",result="hit"} 2
+squid_analytics_domain_requests_total{domain="b.example.com",result="miss"} 0
+squid_analytics_domain_requests_total{domain="other",result="denied"} 0
+squid_analytics_domain_requests_total{domain="other",result="hit"} 5
+squid_analytics_domain_requests_total{domain="other",result="miss"} 0
+# HELP squid_analytics_tracked_values Distinct values tracked per dimension; once it reaches the limit new values are counted as other
+# TYPE squid_analytics_tracked_values gauge
+squid_analytics_tracked_values{dimension="content_type"} 1
+squid_analytics_tracked_values{dimension="domain"} 3
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"squid_analytics_domain_requests_total", "squid_analytics_tracked_values"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "squid_analytics_namespace_requests_total"); n != 0 {
+		t.Errorf("namespace dimension without a resolver: got %d series, want none", n)
+	}
+
+	// c.example.com becomes dominant after the first scrape: it is exported
+	// without what other already reported for it, and the domains it
+	// overtook stay exported, so that no counter drops
+	for range 10 {
+		if err := c.Observe("10.244.0.10 TCP_HIT 1 c.example.com text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected = `
+# HELP squid_analytics_domain_requests_total Requests by domain and cache result
+# TYPE squid_analytics_domain_requests_total counter
+squid_analytics_domain_requests_total{domain="a.example.com",result="denied"} 0
+squid_analytics_domain_requests_total{domain="a.example.com",result="hit"} 3
+squid_analytics_domain_requests_total{domain="a.example.com",result="miss"} 0
+squid_analytics_domain_requests_total{domain="b.example.com",result="denied"} 0
+squid_analytics_domain_requests_total{domain="b.example.com",result="hit"} 2
+squid_analytics_domain_requests_total{domain="b.example.com",result="miss"} 0
+squid_analytics_domain_requests_total{domain="c.example.com",result="denied"} 0
+squid_analytics_domain_requests_total{domain="c.example.com",result="hit"} 10
+squid_analytics_domain_requests_total{domain="c.example.com",result="miss"} 0
+squid_analytics_domain_requests_total{domain="other",result="denied"} 0
+squid_analytics_domain_requests_total{domain="other",result="hit"} 5
+squid_analytics_domain_requests_total{domain="other",result="miss"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "squid_analytics_domain_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(nil, 100, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Serve(ctx, conn) }()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	// With buffered_logs on, squid packs several lines into a datagram
+	if _, err := sender.Write([]byte("10.244.0.10 TCP_MISS 10 example.com text/plain\n10.244.0.10 TCP_HIT 10 example.com text/plain\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	parsed := func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.lines["parsed"]
+	}
+	for parsed() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the log lines")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package cachepolicy turns the CachePolicy resources of tenants into squid
+// configuration. The policies of all namespaces are merged, the rules that
+// are invalid or conflict with others are rejected, and the accepted ones
+// are rendered as the squid.conf fragments the chart includes. The config
+// reloader applies the fragments once they reach the squid pods.
+package cachepolicy
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AccessFile is the fragment holding the allowed destination rules,
+	// included among squid's http_access rules
+	AccessFile = "access.conf"
+	// RefreshFile is the fragment holding the refresh patterns, included
+	// after the platform's refresh patterns
+	RefreshFile = "refresh.conf"
+
+	// Header starts every fragment
+	Header = "# Generated from the CachePolicy resources by cachepolicy-controller, do not edit\n"
+)
+
+// Result is the outcome of merging a set of policies
+type Result struct {
+	// Files are the rendered fragments by file name
+	Files map[string]string
+	// Rejected lists the rejected rules of the policies that have any
+	Rejected map[types.NamespacedName][]cachingv1alpha1.RejectedRule
+}
+
+// rule is a rule of a policy
+type rule struct {
+	policy types.NamespacedName
+	path   string
+}
+
+func (r rule) String() string {
+	return fmt.Sprintf("%s of %s", r.path, r.policy)
+}
+
+// refreshPattern is an accepted refresh pattern
+type refreshPattern struct {
+	rule
+	cachingv1alpha1.RefreshPattern
+}
+
+// domain is an allowed domain of a namespace
+type domain struct {
+	rule
+	name string
+}
+
+// covers reports whether d allows every destination other does: .example.com
+// covers example.com and its subdomains
+func (d domain) covers(other domain) bool {
+	if !strings.HasPrefix(d.name, ".") {
+		return false
+	}
+	return other.name == d.name[1:] || (strings.HasSuffix(other.name, d.name) && other.name != d.name)
+}
+
+// Merge validates and merges policies. Policies are taken in order of
+// creation, so that when two of them conflict the older one wins.
+func Merge(policies []cachingv1alpha1.CachePolicy) Result {
+	policies = append([]cachingv1alpha1.CachePolicy(nil), policies...)
+	sort.SliceStable(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	result := Result{Rejected: map[types.NamespacedName][]cachingv1alpha1.RejectedRule{}}
+	reject := func(r rule, reason string) {
+		result.Rejected[r.policy] = append(result.Rejected[r.policy], cachingv1alpha1.RejectedRule{Rule: r.path, Reason: reason})
+	}
+
+	// Refresh patterns are limited to the destinations their namespace allows,
+	// across all of its policies
+	destinations := map[string][]string{}
+	for _, policy := range policies {
+		for _, name := range policy.Spec.AllowedDomains {
+			if name = strings.ToLower(name); domainName.MatchString(name) {
+				destinations[policy.Namespace] = append(destinations[policy.Namespace], name)
+			}
+		}
+	}
+
+	var patterns []refreshPattern
+	regexes := map[string]rule{}
+	domains := map[string][]domain{}
+	for _, policy := range policies {
+		key := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}
+		for i, pattern := range policy.Spec.RefreshPatterns {
+			r := rule{policy: key, path: fmt.Sprintf("refreshPatterns[%d]", i)}
+			if reason := validateRefreshPattern(pattern, destinations[policy.Namespace]); reason != "" {
+				reject(r, reason)
+				continue
+			}
+			// squid uses the first pattern matching a URL, a second one with
+			// the same regex would never apply
+			id := pattern.Regex
+			if pattern.CaseInsensitive {
+				id = "-i " + id
+			}
+			if first, ok := regexes[id]; ok {
+				reject(r, fmt.Sprintf("regex is already used by %s", first))
+				continue
+			}
+			regexes[id] = r
+			patterns = append(patterns, refreshPattern{rule: r, RefreshPattern: pattern})
+		}
+		for i, name := range policy.Spec.AllowedDomains {
+			r := rule{policy: key, path: fmt.Sprintf("allowedDomains[%d]", i)}
+			name = strings.ToLower(name)
+			if !domainName.MatchString(name) {
+				reject(r, "not a domain name, expected example.com for the host or .example.com for the domain and its subdomains")
+				continue
+			}
+			domains[policy.Namespace] = append(domains[policy.Namespace], domain{rule: r, name: name})
+		}
+	}
+
+	// squid warns about dstdomain entries another one of the ACL covers
+	allowed := map[string][]domain{}
+	for namespace, candidates := range domains {
+	candidate:
+		for i, d := range candidates {
+			for j, other := range candidates {
+				if other.covers(d) || (other.name == d.name && j < i) {
+					reject(d.rule, fmt.Sprintf("already allowed by %s (%s)", other.rule, other.name))
+					continue candidate
+				}
+			}
+			allowed[namespace] = append(allowed[namespace], d)
+		}
+	}
+
+	result.Files = map[string]string{
+		AccessFile:  renderAccess(allowed),
+		RefreshFile: renderRefresh(patterns),
+	}
+	return result
+}
+
+// domainName matches squid dstdomain entries for host names
+var domainName = regexp.MustCompile(`^\.?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// anchors are the prefixes a refresh pattern regex may start with. The
+// literal host has to follow, so that tenants can't change how other sites
+// are cached.
+var anchors = []string{"^http://", "^https://", "^https?://"}
+
+// validateRefreshPattern returns why pattern can't be applied, or "" if it
+// can. Its host must be one of the allowed domains of its namespace.
+func validateRefreshPattern(pattern cachingv1alpha1.RefreshPattern, allowedDomains []string) string {
+	if strings.ContainsAny(pattern.Regex, " \t\r\n") {
+		return "regex must not contain whitespace, use \\s or [[:space:]] instead"
+	}
+	if _, err := regexp.CompilePOSIX(pattern.Regex); err != nil {
+		return fmt.Sprintf("regex is not a POSIX extended regular expression: %v", err)
+	}
+	// An alternative of a top-level alternation, e.g. ^https://x|., would
+	// match any site. GNU regex also reads \| as an alternation.
+	parsed, err := syntax.Parse(pattern.Regex, syntax.POSIX)
+	if err != nil {
+		return fmt.Sprintf("regex is not a POSIX extended regular expression: %v", err)
+	}
+	if parsed.Op == syntax.OpAlternate || strings.Contains(pattern.Regex, `\|`) {
+		return "regex must not be an alternation of patterns, group the alternatives after the host or use a refresh pattern each"
+	}
+	host, ok := literalHost(pattern.Regex)
+	if !ok {
+		return `regex must start with the scheme and the literal host of the site up to the first /, e.g. ^https://registry\.example\.com/`
+	}
+	allowed := false
+	for _, name := range allowedDomains {
+		if name == host || (domain{name: name}).covers(domain{name: host}) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Sprintf("host %s is not among the allowedDomains of the namespace", host)
+	}
+	if pattern.Max < pattern.Min {
+		return "max must not be less than min"
+	}
+	return ""
+}
+
+// literalHost returns the host a regex starting with one of the anchors
+// matches: letters, digits, hyphens and escaped dots, and an optional port,
+// up to a /. Without the /, ^https://example\.com would also match
+// example.com.attacker.net.
+func literalHost(regex string) (string, bool) {
+	for _, anchor := range anchors {
+		rest, ok := strings.CutPrefix(regex, anchor)
+		if !ok {
+			continue
+		}
+		var address strings.Builder
+		for i := 0; i < len(rest); i++ {
+			switch c := rest[i]; {
+			case c == '/':
+				host, port, hasPort := strings.Cut(address.String(), ":")
+				host = strings.ToLower(host)
+				if !domainName.MatchString(host) || strings.HasPrefix(host, ".") || (hasPort && !isNumeric(port)) {
+					return "", false
+				}
+				return host, true
+			case c == '\\' && i+1 < len(rest) && rest[i+1] == '.':
+				address.WriteByte('.')
+				i++
+			case isAlphanumeric(c) || c == '-' || c == ':':
+				address.WriteByte(c)
+			default:
+				return "", false
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+func isAlphanumeric(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func isNumeric(s string) bool {
+	for i := range len(s) {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// renderAccess renders the allowed destinations of each namespace. They only
+// ever restrict access: requests from a namespace with allowed domains to
+// other destinations are denied, the others are left to the platform's rules.
+func renderAccess(allowed map[string][]domain) string {
+	namespaces := make([]string, 0, len(allowed))
+	for namespace := range allowed {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var conf strings.Builder
+	conf.WriteString(Header)
+	for _, namespace := range namespaces {
+		names := make([]string, 0, len(allowed[namespace]))
+		for _, d := range allowed[namespace] {
+			names = append(names, d.name)
+		}
+		acl := "cachepolicy_" + namespace
+		fmt.Fprintf(&conf, "\n# Namespace %s\n", namespace)
+		fmt.Fprintf(&conf, "acl %s_namespace external pod_namespace %s\n", acl, namespace)
+		fmt.Fprintf(&conf, "acl %s_domains dstdomain -n %s\n", acl, strings.Join(names, " "))
+		// The destination is checked first, which saves the namespace lookup
+		// for allowed requests
+		fmt.Fprintf(&conf, "http_access deny !%s_domains %s_namespace\n", acl, acl)
+	}
+	return conf.String()
+}
+
+// renderRefresh renders the refresh patterns in order
+func renderRefresh(patterns []refreshPattern) string {
+	var conf strings.Builder
+	conf.WriteString(Header)
+	var policy types.NamespacedName
+	for _, pattern := range patterns {
+		if pattern.policy != policy {
+			policy = pattern.policy
+			fmt.Fprintf(&conf, "\n# %s\n", policy)
+		}
+		conf.WriteString("refresh_pattern ")
+		if pattern.CaseInsensitive {
+			conf.WriteString("-i ")
+		}
+		fmt.Fprintf(&conf, "%s %d %d%% %d", pattern.Regex, pattern.Min, pattern.Percent, pattern.Max)
+		if pattern.MaxStale != nil {
+			fmt.Fprintf(&conf, " max-stale=%d", *pattern.MaxStale)
+		}
+		conf.WriteString("\n")
+	}
+	return conf.String()
+}
@@ -0,0 +1,194 @@
+package cachepolicy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// policy returns a CachePolicy created age ago
+func policy(namespace, name string, age time.Duration, spec cachingv1alpha1.CachePolicySpec) cachingv1alpha1.CachePolicy {
+	return cachingv1alpha1.CachePolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(-age)),
+		},
+		Spec: spec,
+	}
+}
+
+func int32Ptr(value int32) *int32 {
+	return &value
+}
+
+func TestMergeRefreshPatterns(t *testing.T) {
+	result := Merge([]cachingv1alpha1.CachePolicy{
+		policy("tenant-b", "images", time.Hour, cachingv1alpha1.CachePolicySpec{
+			RefreshPatterns: []cachingv1alpha1.RefreshPattern{
+				{Regex: `^https://quay\.io/v2/.+/blobs/`, Min: 1440, Percent: 100, Max: 10080, MaxStale: int32Ptr(20160)},
+				{Regex: `^https://registry\.example\.com/`, Min: 10, Max: 20},
+			},
+			AllowedDomains: []string{"quay.io"},
+		}),
+		// Older, so its patterns come first
+		policy("tenant-a", "packages", 2*time.Hour, cachingv1alpha1.CachePolicySpec{
+			RefreshPatterns: []cachingv1alpha1.RefreshPattern{
+				{Regex: `^https?://mirror\.example\.com/.*\.rpm$`, CaseInsensitive: true, Min: 60, Percent: 20, Max: 1440},
+				{Regex: `^https://quay\.io/v2/.+/blobs/`, Min: 10, Max: 20},
+			},
+		}),
+		// The namespace's other policies allow the destinations too
+		policy("tenant-a", "destinations", time.Hour, cachingv1alpha1.CachePolicySpec{
+			AllowedDomains: []string{".example.com", "quay.io"},
+		}),
+	})
+
+	want := Header + `
+# tenant-a/packages
+refresh_pattern -i ^https?://mirror\.example\.com/.*\.rpm$ 60 20% 1440
+refresh_pattern ^https://quay\.io/v2/.+/blobs/ 10 0% 20
+`
+	if got := result.Files[RefreshFile]; got != want {
+		t.Errorf("refresh.conf = %q, want %q", got, want)
+	}
+	wantRejected := map[types.NamespacedName][]cachingv1alpha1.RejectedRule{
+		{Namespace: "tenant-b", Name: "images"}: {
+			{Rule: "refreshPatterns[0]", Reason: "regex is already used by refreshPatterns[1] of tenant-a/packages"},
+			{Rule: "refreshPatterns[1]", Reason: "host registry.example.com is not among the allowedDomains of the namespace"},
+		},
+	}
+	if !reflect.DeepEqual(result.Rejected, wantRejected) {
+		t.Errorf("rejected = %v, want %v", result.Rejected, wantRejected)
+	}
+}
+
+func TestMergeRenderMaxStale(t *testing.T) {
+	result := Merge([]cachingv1alpha1.CachePolicy{
+		policy("tenant-a", "images", 0, cachingv1alpha1.CachePolicySpec{
+			RefreshPatterns: []cachingv1alpha1.RefreshPattern{
+				{Regex: `^https://quay\.io/v2/.+/blobs/`, Min: 1440, Percent: 100, Max: 10080, MaxStale: int32Ptr(20160)},
+				{Regex: `^https://quay\.io/v2/.+/manifests/`, MaxStale: int32Ptr(0)},
+			},
+			AllowedDomains: []string{"quay.io"},
+		}),
+	})
+	for _, line := range []string{
+		`refresh_pattern ^https://quay\.io/v2/.+/blobs/ 1440 100% 10080 max-stale=20160` + "\n",
+		`refresh_pattern ^https://quay\.io/v2/.+/manifests/ 0 0% 0 max-stale=0` + "\n",
+	} {
+		if !strings.Contains(result.Files[RefreshFile], line) {
+			t.Errorf("refresh.conf is missing %q:\n%s", line, result.Files[RefreshFile])
+		}
+	}
+	if len(result.Rejected) != 0 {
+		t.Errorf("rejected = %v, want none", result.Rejected)
+	}
+}
+
+func TestValidateRefreshPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern cachingv1alpha1.RefreshPattern
+		reason  string
+	}{
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://registry\.example\.com/`}},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^http://10\.0\.0\.1:8080/`}},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https?://[a-z]+\.example\.com/`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `.`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `\.rpm$`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://registry\.example\.com/(v2|v1)/`}},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://registry\.example\.com/|^https://quay\.example\.com/`}, reason: "must not be an alternation"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://x|.`}, reason: "must not be an alternation"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https?://x|^`}, reason: "must not be an alternation"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://x\|.`}, reason: "must not be an alternation"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com/ 1440 100% 10080 ignore-reload`}, reason: "must not contain whitespace"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com/(`}, reason: "not a POSIX extended regular expression"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com/\d+`}, reason: "not a POSIX extended regular expression"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com/`, Min: 60, Max: 10}, reason: "max must not be less than min"},
+		// The host must be literal up to the first /
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://q.*`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://a[^/]*/`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://registry.example\.com/`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com:x/`}, reason: "must start with the scheme and the literal host"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://Registry\.Example\.com/`, CaseInsensitive: true}},
+		// And allowed to the namespace
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://quay\.io/`}, reason: "host quay.io is not among the allowedDomains"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.com\.attacker\.net/`}, reason: "not among the allowedDomains"},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://mirror\.example\.org/`}},
+		{pattern: cachingv1alpha1.RefreshPattern{Regex: `^https://example\.org/`}},
+	} {
+		reason := validateRefreshPattern(test.pattern, []string{"registry.example.com", "example.com", "10.0.0.1", "x", ".example.org"})
+		if test.reason == "" && reason != "" {
+			t.Errorf("%s: rejected: %s", test.pattern.Regex, reason)
+		}
+		if !strings.Contains(reason, test.reason) || (test.reason != "" && reason == "") {
+			t.Errorf("%s: reason = %q, want it to contain %q", test.pattern.Regex, reason, test.reason)
+		}
+	}
+}
+
+func TestMergeAllowedDomains(t *testing.T) {
+	result := Merge([]cachingv1alpha1.CachePolicy{
+		policy("tenant-a", "registries", 2*time.Hour, cachingv1alpha1.CachePolicySpec{
+			AllowedDomains: []string{"Quay.io", ".github.com", "api.github.com", "not a domain"},
+		}),
+		policy("tenant-a", "sources", time.Hour, cachingv1alpha1.CachePolicySpec{
+			AllowedDomains: []string{"github.com", "quay.io", ".example.com"},
+		}),
+		policy("tenant-b", "registries", time.Hour, cachingv1alpha1.CachePolicySpec{
+			AllowedDomains: []string{"quay.io"},
+		}),
+		// Policies without allowed domains don't restrict their namespace,
+		// nor may they set refresh patterns
+		policy("tenant-c", "images", time.Hour, cachingv1alpha1.CachePolicySpec{
+			RefreshPatterns: []cachingv1alpha1.RefreshPattern{{Regex: `^https://quay\.io/`}},
+		}),
+	})
+
+	want := Header + `
+# Namespace tenant-a
+acl cachepolicy_tenant-a_namespace external pod_namespace tenant-a
+acl cachepolicy_tenant-a_domains dstdomain -n quay.io .github.com .example.com
+http_access deny !cachepolicy_tenant-a_domains cachepolicy_tenant-a_namespace
+
+# Namespace tenant-b
+acl cachepolicy_tenant-b_namespace external pod_namespace tenant-b
+acl cachepolicy_tenant-b_domains dstdomain -n quay.io
+http_access deny !cachepolicy_tenant-b_domains cachepolicy_tenant-b_namespace
+`
+	if got := result.Files[AccessFile]; got != want {
+		t.Errorf("access.conf = %q, want %q", got, want)
+	}
+
+	wantRejected := map[types.NamespacedName][]cachingv1alpha1.RejectedRule{
+		{Namespace: "tenant-a", Name: "registries"}: {
+			{Rule: "allowedDomains[3]", Reason: "not a domain name, expected example.com for the host or .example.com for the domain and its subdomains"},
+			{Rule: "allowedDomains[2]", Reason: "already allowed by allowedDomains[1] of tenant-a/registries (.github.com)"},
+		},
+		{Namespace: "tenant-a", Name: "sources"}: {
+			{Rule: "allowedDomains[0]", Reason: "already allowed by allowedDomains[1] of tenant-a/registries (.github.com)"},
+			{Rule: "allowedDomains[1]", Reason: "already allowed by allowedDomains[0] of tenant-a/registries (quay.io)"},
+		},
+		{Namespace: "tenant-c", Name: "images"}: {
+			{Rule: "refreshPatterns[0]", Reason: "host quay.io is not among the allowedDomains of the namespace"},
+		},
+	}
+	if !reflect.DeepEqual(result.Rejected, wantRejected) {
+		t.Errorf("rejected = %v, want %v", result.Rejected, wantRejected)
+	}
+}
+
+func TestMergeNoPolicies(t *testing.T) {
+	result := Merge(nil)
+	want := map[string]string{AccessFile: Header, RefreshFile: Header}
+	if !reflect.DeepEqual(result.Files, want) {
+		t.Errorf("files = %v, want %v", result.Files, want)
+	}
+}
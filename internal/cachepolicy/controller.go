@@ -0,0 +1,145 @@
+package cachepolicy
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ConditionAccepted is the condition reporting whether all the rules of a
+	// policy are applied
+	ConditionAccepted = "Accepted"
+	// ReasonAccepted is the reason of a true Accepted condition
+	ReasonAccepted = "Accepted"
+	// ReasonRulesRejected is the reason of a false Accepted condition
+	ReasonRulesRejected = "RulesRejected"
+)
+
+// Reconciler renders the CachePolicies of all namespaces into the ConfigMap
+// squid includes and reports the rules it rejected on each policy. Any change
+// to any policy affects the merged configuration, so every reconcile handles
+// all of them.
+type Reconciler struct {
+	client.Client
+	// ConfigMap is the ConfigMap the fragments are written to
+	ConfigMap types.NamespacedName
+}
+
+// Reconcile merges all policies, updates the ConfigMap and the status of
+// every policy
+func (r *Reconciler) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	var policies cachingv1alpha1.CachePolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list CachePolicies: %w", err)
+	}
+
+	result := Merge(policies.Items)
+	if err := r.updateConfigMap(ctx, result.Files); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if err := r.updateStatus(ctx, policy, result.Rejected[client.ObjectKeyFromObject(policy)]); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// updateConfigMap writes files to the ConfigMap, creating it if needed
+func (r *Reconciler) updateConfigMap(ctx context.Context, files map[string]string) error {
+	var configMap corev1.ConfigMap
+	err := r.Get(ctx, r.ConfigMap, &configMap)
+	if apierrors.IsNotFound(err) {
+		configMap = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: r.ConfigMap.Name, Namespace: r.ConfigMap.Namespace},
+			Data:       files,
+		}
+		if err := r.Create(ctx, &configMap); err != nil {
+			return fmt.Errorf("failed to create ConfigMap %s: %w", r.ConfigMap, err)
+		}
+		log.FromContext(ctx).Info("Created the squid configuration", "configMap", r.ConfigMap)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ConfigMap %s: %w", r.ConfigMap, err)
+	}
+
+	if maps.Equal(configMap.Data, files) {
+		return nil
+	}
+	configMap.Data = files
+	if err := r.Update(ctx, &configMap); err != nil {
+		return fmt.Errorf("failed to update ConfigMap %s: %w", r.ConfigMap, err)
+	}
+	log.FromContext(ctx).Info("Updated the squid configuration", "configMap", r.ConfigMap)
+	return nil
+}
+
+// updateStatus reports the rejected rules of policy
+func (r *Reconciler) updateStatus(ctx context.Context, policy *cachingv1alpha1.CachePolicy, rejected []cachingv1alpha1.RejectedRule) error {
+	status := policy.Status.DeepCopy()
+	status.ObservedGeneration = policy.Generation
+	status.RejectedRules = rejected
+
+	rules := len(policy.Spec.RefreshPatterns) + len(policy.Spec.AllowedDomains)
+	condition := metav1.Condition{
+		Type:               ConditionAccepted,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonAccepted,
+		Message:            fmt.Sprintf("All %d rules are applied", rules),
+		ObservedGeneration: policy.Generation,
+	}
+	if len(rejected) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonRulesRejected
+		condition.Message = fmt.Sprintf("%d of %d rules are rejected, see status.rejectedRules", len(rejected), rules)
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if reflect.DeepEqual(*status, policy.Status) {
+		return nil
+	}
+	policy.Status = *status
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return fmt.Errorf("failed to update the status of CachePolicy %s: %w", client.ObjectKeyFromObject(policy), err)
+	}
+	if len(rejected) > 0 {
+		log.FromContext(ctx).Info("Rejected rules", "cachePolicy", client.ObjectKeyFromObject(policy), "rejected", rejected)
+	}
+	return nil
+}
+
+// SetupWithManager registers the reconciler with mgr. Every event maps to the
+// same request, so that bursts of changes are merged once.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	merge := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: r.ConfigMap}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cachepolicy").
+		// Status updates don't change the configuration
+		Watches(&cachingv1alpha1.CachePolicy{}, merge, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Edits or the deletion of the ConfigMap are undone
+		Watches(&corev1.ConfigMap{}, merge, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			return client.ObjectKeyFromObject(object) == r.ConfigMap
+		}))).
+		Complete(r)
+}
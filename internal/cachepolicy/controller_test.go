@@ -0,0 +1,197 @@
+package cachepolicy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// eventually retries check until it succeeds or a timeout expires
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// TestReconciler runs the controller against a real API server. envtest needs
+// the kube-apiserver and etcd binaries, see `mage test:controller`.
+func TestReconciler(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run `mage test:controller`")
+	}
+
+	environment := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "squid", "crds", "caching.konflux-ci.dev_cachepolicies.yaml")},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := environment.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := environment.Stop(); err != nil {
+			t.Errorf("failed to stop envtest: %v", err)
+		}
+	})
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachingv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMapKey := types.NamespacedName{Namespace: "proxy", Name: "squid-cache-policies"}
+	if err := (&Reconciler{Client: mgr.GetClient(), ConfigMap: configMapKey}).SetupWithManager(mgr); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- mgr.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("manager failed: %v", err)
+		}
+	})
+
+	k8s, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, namespace := range []string{"proxy", "tenant-a"} {
+		if err := k8s.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// configMapContains checks that the ConfigMap's files contain the given
+	// lines
+	configMapContains := func(file string, lines ...string) func() error {
+		return func() error {
+			var configMap corev1.ConfigMap
+			if err := k8s.Get(ctx, configMapKey, &configMap); err != nil {
+				return err
+			}
+			for _, line := range lines {
+				if !strings.Contains(configMap.Data[file], line+"\n") {
+					return fmt.Errorf("%s does not contain %q:\n%s", file, line, configMap.Data[file])
+				}
+			}
+			return nil
+		}
+	}
+	// accepted checks the Accepted condition of the policy and its rejected
+	// rules
+	accepted := func(status metav1.ConditionStatus, rejected ...string) func() error {
+		return func() error {
+			var policy cachingv1alpha1.CachePolicy
+			if err := k8s.Get(ctx, types.NamespacedName{Namespace: "tenant-a", Name: "builds"}, &policy); err != nil {
+				return err
+			}
+			if policy.Status.ObservedGeneration != policy.Generation {
+				return fmt.Errorf("status refers to generation %d, want %d", policy.Status.ObservedGeneration, policy.Generation)
+			}
+			condition := meta.FindStatusCondition(policy.Status.Conditions, ConditionAccepted)
+			if condition == nil || condition.Status != status {
+				return fmt.Errorf("Accepted condition = %v, want status %s", condition, status)
+			}
+			var rules []string
+			for _, rule := range policy.Status.RejectedRules {
+				rules = append(rules, rule.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(rejected, ",") {
+				return fmt.Errorf("rejected rules = %v, want %v", rules, rejected)
+			}
+			return nil
+		}
+	}
+
+	policy := &cachingv1alpha1.CachePolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "builds"},
+		Spec: cachingv1alpha1.CachePolicySpec{
+			RefreshPatterns: []cachingv1alpha1.RefreshPattern{
+				{Regex: `^https://quay\.io/v2/.+/blobs/`, Min: 1440, Percent: 100, Max: 10080},
+				{Regex: `.`, Min: 1440, Max: 10080},
+			},
+			AllowedDomains: []string{".quay.io"},
+		},
+	}
+	if err := k8s.Create(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("The valid rules are rendered, the invalid one is reported")
+	eventually(t, configMapContains(RefreshFile, `refresh_pattern ^https://quay\.io/v2/.+/blobs/ 1440 100% 10080`))
+	eventually(t, configMapContains(AccessFile,
+		"acl cachepolicy_tenant-a_domains dstdomain -n .quay.io",
+		"http_access deny !cachepolicy_tenant-a_domains cachepolicy_tenant-a_namespace",
+	))
+	eventually(t, accepted(metav1.ConditionFalse, "refreshPatterns[1]"))
+
+	t.Log("Fixing the rule accepts the policy")
+	eventually(t, func() error {
+		if err := k8s.Get(ctx, client.ObjectKeyFromObject(policy), policy); err != nil {
+			return err
+		}
+		policy.Spec.RefreshPatterns[1].Regex = `^https://registry\.example\.com/`
+		return k8s.Update(ctx, policy)
+	})
+	eventually(t, configMapContains(RefreshFile, `refresh_pattern ^https://registry\.example\.com/ 1440 0% 10080`))
+	eventually(t, accepted(metav1.ConditionTrue))
+
+	t.Log("A deleted ConfigMap is recreated")
+	if err := k8s.Delete(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: configMapKey.Namespace, Name: configMapKey.Name}}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, configMapContains(AccessFile, "acl cachepolicy_tenant-a_domains dstdomain -n .quay.io"))
+
+	t.Log("Deleting the policy removes its rules")
+	if err := k8s.Delete(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() error {
+		var configMap corev1.ConfigMap
+		if err := k8s.Get(ctx, configMapKey, &configMap); err != nil {
+			return err
+		}
+		if configMap.Data[AccessFile] != Header || configMap.Data[RefreshFile] != Header {
+			return fmt.Errorf("rules are still rendered: %v", configMap.Data)
+		}
+		return nil
+	})
+}
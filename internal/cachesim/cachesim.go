@@ -0,0 +1,466 @@
+// Package cachesim replays recorded traffic through simulated caches of
+// several sizes and replacement policies, to project the hit ratios that
+// cache_mem, cache_dir and cache_replacement_policy settings would give.
+//
+// The simulation only models capacity: every cacheable response stays fresh
+// until it is evicted, so the projected ratios are upper bounds that squid
+// approaches as refresh patterns let it keep objects longer.
+package cachesim
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+	"github.com/konflux-ci/caching/internal/analytics"
+)
+
+// Replacement policies, named as in cache_replacement_policy
+const (
+	PolicyLRU   = "lru"
+	PolicyGDSF  = "heap GDSF"
+	PolicyLFUDA = "heap LFUDA"
+)
+
+// Policies are the replacement policies that can be simulated
+var Policies = []string{PolicyLRU, PolicyGDSF, PolicyLFUDA}
+
+// cacheableStatuses are the statuses squid caches without an explicit
+// expiry
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusGone:                 true,
+}
+
+// request is an access log entry reduced to what the simulation needs
+type request struct {
+	object    int32
+	bytes     int64
+	cacheable bool
+}
+
+// Trace is recorded traffic, in the order squid served it. Denied requests
+// never reach the cache and are left out.
+type Trace struct {
+	requests []request
+	objects  map[string]int32
+	sizes    map[int32]int64
+
+	// Denied counts the requests left out
+	Denied int64
+	// ObservedHits and ObservedHitBytes are what squid served from its cache
+	ObservedHits     int64
+	ObservedHitBytes int64
+}
+
+// NewTrace returns an empty trace
+func NewTrace() *Trace {
+	return &Trace{objects: map[string]int32{}, sizes: map[int32]int64{}}
+}
+
+// Add appends an access log entry. GET responses with a cacheable status are
+// stored under their URL; the rest of the traffic can't hit.
+func (t *Trace) Add(entry accesslog.Entry) {
+	result := analytics.Classify(entry.ResultCode)
+	if result == analytics.ResultDenied {
+		t.Denied++
+		return
+	}
+	if result == analytics.ResultHit {
+		t.ObservedHits++
+		t.ObservedHitBytes += entry.Bytes
+	}
+
+	object, ok := t.objects[entry.URL]
+	if !ok {
+		object = int32(len(t.objects))
+		t.objects[entry.URL] = object
+	}
+	cacheable := entry.Method == http.MethodGet && cacheableStatuses[entry.Status]
+	if cacheable {
+		t.sizes[object] = entry.Bytes
+	}
+	t.requests = append(t.requests, request{object: object, bytes: entry.Bytes, cacheable: cacheable})
+}
+
+// Summary describes a trace
+type Summary struct {
+	Requests  int64 `json:"requests"`
+	Bytes     int64 `json:"bytes"`
+	Cacheable int64 `json:"cacheable"`
+	Objects   int   `json:"objects"`
+	// UniqueBytes is the size of the last version of every cacheable
+	// object, the capacity beyond which evictions stop
+	UniqueBytes      int64 `json:"uniqueBytes"`
+	Denied           int64 `json:"denied"`
+	ObservedHits     int64 `json:"observedHits"`
+	ObservedHitBytes int64 `json:"observedHitBytes"`
+}
+
+// Summary counts the requests and objects of the trace
+func (t *Trace) Summary() Summary {
+	summary := Summary{
+		Requests:         int64(len(t.requests)),
+		Objects:          len(t.sizes),
+		Denied:           t.Denied,
+		ObservedHits:     t.ObservedHits,
+		ObservedHitBytes: t.ObservedHitBytes,
+	}
+	for _, r := range t.requests {
+		summary.Bytes += r.bytes
+		if r.cacheable {
+			summary.Cacheable++
+		}
+	}
+	for _, size := range t.sizes {
+		summary.UniqueBytes += size
+	}
+	return summary
+}
+
+// Options tune a simulation
+type Options struct {
+	// MaxObjectSize is the largest object stored, as maximum_object_size or
+	// maximum_object_size_in_memory
+	MaxObjectSize int64
+	// Warmup is the share of the trace (0-1) replayed before counting, so
+	// that the cold start does not weigh on the ratios
+	Warmup float64
+}
+
+// Result is the outcome of replaying a trace through one cache
+type Result struct {
+	Policy string `json:"policy"`
+	// Capacity is the cache size in bytes, 0 for an unlimited cache
+	Capacity int64 `json:"capacity"`
+	Requests int64 `json:"requests"`
+	Hits     int64 `json:"hits"`
+	Bytes    int64 `json:"bytes"`
+	HitBytes int64 `json:"hitBytes"`
+	// Evictions counts the objects evicted to make room
+	Evictions int64 `json:"evictions"`
+}
+
+// HitRatio is the share of requests served from the cache
+func (r Result) HitRatio() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Requests)
+}
+
+// ByteHitRatio is the share of the bytes sent to clients that came from the
+// cache
+func (r Result) ByteHitRatio() float64 {
+	if r.Bytes == 0 {
+		return 0
+	}
+	return float64(r.HitBytes) / float64(r.Bytes)
+}
+
+// object is a cached object
+type object struct {
+	id   int32
+	size int64
+	// refs and lastRef (the request sequence number) drive the heap
+	// policies, like squid's refcount and lastref
+	refs    int64
+	lastRef int
+	key     float64
+	index   int
+	element *list.Element
+}
+
+// replacement picks the objects to evict
+type replacement interface {
+	added(o *object, seq int)
+	referenced(o *object, seq int)
+	removed(o *object)
+	// victim removes and returns the object to evict
+	victim() *object
+}
+
+// lru evicts the least recently used object
+type lru struct {
+	list list.List
+}
+
+func (l *lru) added(o *object, seq int) {
+	o.element = l.list.PushFront(o)
+}
+
+func (l *lru) referenced(o *object, seq int) {
+	l.list.MoveToFront(o.element)
+}
+
+func (l *lru) removed(o *object) {
+	l.list.Remove(o.element)
+}
+
+func (l *lru) victim() *object {
+	return l.list.Remove(l.list.Back()).(*object)
+}
+
+// heapPolicy evicts the object with the lowest key, as squid's heap policies
+// do. The key of an object is the cache age, i.e. the key of the last
+// evicted object, plus a value that grows with its references, which ages
+// out objects that were popular long ago.
+type heapPolicy struct {
+	objects []*object
+	age     float64
+	value   func(o *object) float64
+}
+
+// newGDSF returns the Greedy-Dual Size Frequency policy, which keeps small
+// popular objects and optimizes the request hit ratio
+func newGDSF() *heapPolicy {
+	return &heapPolicy{value: func(o *object) float64 {
+		return float64(o.refs) / math.Max(float64(o.size), 1)
+	}}
+}
+
+// newLFUDA returns the Least Frequently Used with Dynamic Aging policy, which
+// keeps popular objects regardless of their size and optimizes the byte hit
+// ratio
+func newLFUDA() *heapPolicy {
+	return &heapPolicy{value: func(o *object) float64 {
+		return float64(o.refs)
+	}}
+}
+
+func (h *heapPolicy) Len() int { return len(h.objects) }
+
+func (h *heapPolicy) Less(i, j int) bool {
+	a, b := h.objects[i], h.objects[j]
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return a.lastRef < b.lastRef
+}
+
+func (h *heapPolicy) Swap(i, j int) {
+	h.objects[i], h.objects[j] = h.objects[j], h.objects[i]
+	h.objects[i].index = i
+	h.objects[j].index = j
+}
+
+func (h *heapPolicy) Push(x any) {
+	o := x.(*object)
+	o.index = len(h.objects)
+	h.objects = append(h.objects, o)
+}
+
+func (h *heapPolicy) Pop() any {
+	o := h.objects[len(h.objects)-1]
+	h.objects = h.objects[:len(h.objects)-1]
+	return o
+}
+
+func (h *heapPolicy) added(o *object, seq int) {
+	o.refs, o.lastRef = 1, seq
+	o.key = h.age + h.value(o)
+	heap.Push(h, o)
+}
+
+func (h *heapPolicy) referenced(o *object, seq int) {
+	o.refs++
+	o.lastRef = seq
+	o.key = h.age + h.value(o)
+	heap.Fix(h, o.index)
+}
+
+func (h *heapPolicy) removed(o *object) {
+	heap.Remove(h, o.index)
+}
+
+func (h *heapPolicy) victim() *object {
+	o := heap.Pop(h).(*object)
+	h.age = o.key
+	return o
+}
+
+func newReplacement(policy string) (replacement, error) {
+	switch policy {
+	case PolicyLRU:
+		return &lru{}, nil
+	case PolicyGDSF:
+		return newGDSF(), nil
+	case PolicyLFUDA:
+		return newLFUDA(), nil
+	default:
+		return nil, fmt.Errorf("unknown replacement policy %q, expected one of %s", policy, strings.Join(Policies, ", "))
+	}
+}
+
+// Simulate replays the trace through a cache of capacity bytes (unlimited if
+// 0) using policy
+func Simulate(trace *Trace, policy string, capacity int64, options Options) (Result, error) {
+	replacement, err := newReplacement(policy)
+	if err != nil {
+		return Result{}, err
+	}
+	if capacity <= 0 {
+		capacity = math.MaxInt64
+	}
+	maxObjectSize := options.MaxObjectSize
+	if maxObjectSize <= 0 {
+		maxObjectSize = math.MaxInt64
+	}
+	warmup := int(float64(len(trace.requests)) * options.Warmup)
+
+	result := Result{Policy: policy}
+	if capacity != math.MaxInt64 {
+		result.Capacity = capacity
+	}
+	cached := map[int32]*object{}
+	var used int64
+	for seq, r := range trace.requests {
+		counted := seq >= warmup
+		if counted {
+			result.Requests++
+			result.Bytes += r.bytes
+		}
+		if !r.cacheable {
+			continue
+		}
+
+		o := cached[r.object]
+		if o != nil && o.size == r.bytes {
+			replacement.referenced(o, seq)
+			if counted {
+				result.Hits++
+				result.HitBytes += r.bytes
+			}
+			continue
+		}
+		// A different size means the object changed at the origin
+		if o != nil {
+			replacement.removed(o)
+			delete(cached, o.id)
+			used -= o.size
+		}
+		if r.bytes > maxObjectSize || r.bytes > capacity {
+			continue
+		}
+		for used > capacity-r.bytes {
+			victim := replacement.victim()
+			delete(cached, victim.id)
+			used -= victim.size
+			if counted {
+				result.Evictions++
+			}
+		}
+		o = &object{id: r.object, size: r.bytes}
+		replacement.added(o, seq)
+		cached[r.object] = o
+		used += r.bytes
+	}
+	return result, nil
+}
+
+// Run simulates every policy at every capacity, concurrently, and returns the
+// results ordered by capacity then policy, followed by those of an unlimited
+// cache
+func Run(trace *Trace, policies []string, capacities []int64, options Options) ([]Result, error) {
+	for _, policy := range policies {
+		if _, err := newReplacement(policy); err != nil {
+			return nil, err
+		}
+	}
+	capacities = append([]int64(nil), capacities...)
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i] < capacities[j] })
+
+	type job struct {
+		policy   string
+		capacity int64
+	}
+	var jobs []job
+	for _, capacity := range capacities {
+		for _, policy := range policies {
+			jobs = append(jobs, job{policy, capacity})
+		}
+	}
+	// All policies behave alike when nothing is evicted, so the unlimited
+	// cache is only simulated once
+	jobs = append(jobs, job{PolicyLRU, 0})
+
+	results := make([]Result, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// The policies were validated above
+				results[i], _ = Simulate(trace, jobs[i].policy, jobs[i].capacity, options)
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	unlimited := results[len(results)-1]
+	results = results[:len(results)-1]
+	for _, policy := range policies {
+		unlimited.Policy = policy
+		results = append(results, unlimited)
+	}
+	return results, nil
+}
+
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"KB": 1 << 10, "KIB": 1 << 10, "K": 1 << 10,
+	"MB": 1 << 20, "MIB": 1 << 20, "M": 1 << 20,
+	"GB": 1 << 30, "GIB": 1 << 30, "G": 1 << 30,
+	"TB": 1 << 40, "TIB": 1 << 40, "T": 1 << 40,
+}
+
+// ParseSize parses a size such as "256MB" or "1.5 GiB". Like squid, KB, MB
+// and GB are powers of 1024.
+func ParseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	split := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(size)
+	}
+	number, err := strconv.ParseFloat(size[:split], 64)
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(size[split:]))]
+	if err != nil || !ok || number <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512MB or 4GiB", size)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// FormatSize formats a size in bytes, e.g. "256 MiB"
+func FormatSize(bytes int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value == math.Trunc(value) {
+		return fmt.Sprintf("%.0f %s", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
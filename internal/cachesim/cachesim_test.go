@@ -0,0 +1,187 @@
+package cachesim
+
+import (
+	"testing"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+)
+
+// trace builds a trace of cacheable GETs of objects of the given sizes, e.g.
+// "a" for http://example.com/a
+func trace(sizes map[string]int64, urls ...string) *Trace {
+	t := NewTrace()
+	for _, url := range urls {
+		t.Add(accesslog.Entry{
+			Method:     "GET",
+			URL:        "http://example.com/" + url,
+			Status:     200,
+			ResultCode: "TCP_MISS",
+			Bytes:      sizes[url],
+		})
+	}
+	return t
+}
+
+func simulate(t *testing.T, trace *Trace, policy string, capacity int64) Result {
+	t.Helper()
+	result, err := Simulate(trace, policy, capacity, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSimulateLRU(t *testing.T) {
+	sizes := map[string]int64{"a": 100, "b": 100, "c": 100, "d": 100}
+	// d evicts a, the least recently used, and a evicts b in turn, so only
+	// b and c hit
+	result := simulate(t, trace(sizes, "a", "b", "c", "b", "d", "c", "a"), PolicyLRU, 300)
+	want := Result{Policy: PolicyLRU, Capacity: 300, Requests: 7, Hits: 2, Bytes: 700, HitBytes: 200, Evictions: 2}
+	if result != want {
+		t.Errorf("Simulate() = %+v, want %+v", result, want)
+	}
+}
+
+func TestSimulateGDSFKeepsSmallObjects(t *testing.T) {
+	sizes := map[string]int64{"small": 10, "large": 500, "other": 10}
+	// There is room for the large object or the two small ones: GDSF evicts
+	// the large one, LRU the least recently used small one
+	urls := []string{"small", "large", "other", "small", "other", "small"}
+	gdsf := simulate(t, trace(sizes, urls...), PolicyGDSF, 510)
+	lru := simulate(t, trace(sizes, urls...), PolicyLRU, 510)
+	if gdsf.Hits != 3 || lru.Hits != 2 {
+		t.Errorf("hits: GDSF = %d, LRU = %d, want 3 and 2", gdsf.Hits, lru.Hits)
+	}
+	if gdsf.HitRatio() <= lru.HitRatio() {
+		t.Errorf("GDSF hit ratio %.2f should beat LRU's %.2f", gdsf.HitRatio(), lru.HitRatio())
+	}
+}
+
+func TestSimulateLFUDAKeepsPopularObjects(t *testing.T) {
+	sizes := map[string]int64{"popular": 100, "x": 100, "y": 100, "z": 100}
+	// One-hit wonders push the popular object out of an LRU cache, while
+	// LFUDA evicts them instead
+	urls := []string{"popular", "popular", "popular", "x", "y", "popular", "z", "popular"}
+	lfuda := simulate(t, trace(sizes, urls...), PolicyLFUDA, 200)
+	lru := simulate(t, trace(sizes, urls...), PolicyLRU, 200)
+	if lfuda.Hits != 4 {
+		t.Errorf("LFUDA hits = %d, want 4", lfuda.Hits)
+	}
+	if lru.Hits != 3 {
+		t.Errorf("LRU hits = %d, want 3", lru.Hits)
+	}
+}
+
+func TestSimulateUncacheable(t *testing.T) {
+	trace := NewTrace()
+	for _, entry := range []accesslog.Entry{
+		{Method: "GET", URL: "http://example.com/a", Status: 200, ResultCode: "TCP_MISS", Bytes: 100},
+		{Method: "GET", URL: "http://example.com/a", Status: 200, ResultCode: "TCP_MEM_HIT", Bytes: 100},
+		// Changed at the origin
+		{Method: "GET", URL: "http://example.com/a", Status: 200, ResultCode: "TCP_REFRESH_MODIFIED", Bytes: 120},
+		{Method: "POST", URL: "http://example.com/a", Status: 200, ResultCode: "TCP_MISS", Bytes: 120},
+		{Method: "GET", URL: "http://example.com/missing", Status: 404, ResultCode: "TCP_MISS", Bytes: 10},
+		{Method: "GET", URL: "http://example.com/missing", Status: 404, ResultCode: "TCP_MISS", Bytes: 10},
+		{Method: "GET", URL: "http://example.com/large", Status: 200, ResultCode: "TCP_MISS", Bytes: 1000},
+		{Method: "GET", URL: "http://example.com/large", Status: 200, ResultCode: "TCP_MISS", Bytes: 1000},
+		{Method: "GET", URL: "http://blocked.example.com/", Status: 403, ResultCode: "TCP_DENIED", Bytes: 3000},
+		{Method: "GET", URL: "http://example.com/a", Status: 200, ResultCode: "TCP_MEM_HIT", Bytes: 120},
+	} {
+		trace.Add(entry)
+	}
+
+	result, err := Simulate(trace, PolicyLRU, 0, Options{MaxObjectSize: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{Policy: PolicyLRU, Requests: 9, Hits: 2, Bytes: 2580, HitBytes: 220}
+	if result != want {
+		t.Errorf("Simulate() = %+v, want %+v", result, want)
+	}
+
+	summary := trace.Summary()
+	wantSummary := Summary{Requests: 9, Bytes: 2580, Cacheable: 6, Objects: 2, UniqueBytes: 1120, Denied: 1,
+		ObservedHits: 2, ObservedHitBytes: 220}
+	if summary != wantSummary {
+		t.Errorf("Summary() = %+v, want %+v", summary, wantSummary)
+	}
+}
+
+func TestSimulateWarmup(t *testing.T) {
+	sizes := map[string]int64{"a": 100, "b": 100}
+	result, err := Simulate(trace(sizes, "a", "b", "a", "b"), PolicyLRU, 0, Options{Warmup: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 2 || result.Hits != 2 || result.HitRatio() != 1 {
+		t.Errorf("Simulate() = %+v, want the 2 requests after the warmup to hit", result)
+	}
+}
+
+func TestRun(t *testing.T) {
+	sizes := map[string]int64{"a": 100, "b": 100, "c": 100}
+	results, err := Run(trace(sizes, "a", "b", "c", "a", "b", "c"), []string{PolicyLRU, PolicyLFUDA}, []int64{300, 100}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type point struct {
+		policy   string
+		capacity int64
+		hits     int64
+	}
+	var got []point
+	for _, result := range results {
+		got = append(got, point{result.Policy, result.Capacity, result.Hits})
+	}
+	want := []point{
+		{PolicyLRU, 100, 0}, {PolicyLFUDA, 100, 0},
+		{PolicyLRU, 300, 3}, {PolicyLFUDA, 300, 3},
+		{PolicyLRU, 0, 3}, {PolicyLFUDA, 0, 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Run() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Run()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := Run(NewTrace(), []string{"heap LRU"}, []int64{100}, Options{}); err == nil {
+		t.Errorf("Run() with an unknown policy succeeded")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for size, want := range map[string]int64{
+		"512":     512,
+		"256MB":   256 << 20,
+		"256 MiB": 256 << 20,
+		"1.5GB":   3 << 29,
+		"4g":      4 << 30,
+		"100 KB":  100 << 10,
+	} {
+		got, err := ParseSize(size)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", size, got, err, want)
+		}
+	}
+	for _, size := range []string{"", "MB", "-1GB", "1 PB", "1.2.3MB"} {
+		if got, err := ParseSize(size); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", size, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for bytes, want := range map[int64]string{
+		512:       "512 B",
+		256 << 20: "256 MiB",
+		3 << 29:   "1.5 GiB",
+	} {
+		if got := FormatSize(bytes); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", bytes, got, want)
+		}
+	}
+}
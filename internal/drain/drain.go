@@ -0,0 +1,170 @@
+// Package drain lets squid finish in-flight transfers when its pod is
+// terminated: the pod turns unready first, so that Services stop sending it
+// new clients, and the preStop hook then waits for the client requests in
+// progress to complete before the kubelet sends squid SIGTERM.
+package drain
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+// ProcNetTCP are the kernel's TCP socket tables of the pod's network namespace
+var ProcNetTCP = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// Marker is the file the preStop hook creates when draining starts. The
+// readiness probe fails while it exists.
+const Marker = "/run/squid/draining"
+
+// tcpEstablished is the ESTABLISHED state in the st column of /proc/net/tcp
+const tcpEstablished = "01"
+
+// ActiveRequests counts the client requests squid is serving to other hosts,
+// as listed on its active_requests cache manager page. Unlike the connections,
+// it leaves out idle persistent connections, which clients keep open between
+// requests. Loopback clients, such as this very request, are not counted.
+func ActiveRequests(ctx context.Context, squid *squidclient.Client) (int, error) {
+	page, err := squid.Mgr(ctx, "active_requests")
+	if err != nil {
+		return 0, err
+	}
+	return countRequests(page), nil
+}
+
+// countRequests counts the requests of an active_requests page whose client
+// is not on the loopback. Every request starts with a "Connection:" line,
+// followed by its client's details when it has one:
+//
+//	Connection: 0x55d0c0a3e2f8
+//		FD 12, read 112, wrote 0
+//		FD desc: Reading next request
+//		in: buf 0x55d0c0a41000, used 0, free 4096
+//		remote: 10.244.0.12:53972
+//		local: 10.244.0.11:3128
+//		nrequests: 1
+//	uri http://registry.example.com/v2/
+//	...
+func countRequests(page string) int {
+	count := 0
+	for _, line := range strings.Split(page, "\n") {
+		remote, ok := strings.CutPrefix(strings.TrimSpace(line), "remote: ")
+		if !ok {
+			continue
+		}
+		if addr, err := netip.ParseAddrPort(remote); err == nil && !addr.Addr().Unmap().IsLoopback() {
+			count++
+		}
+	}
+	return count
+}
+
+// ActiveConnections counts the established connections to the local port
+// from other hosts, as listed in files in the /proc/net/tcp format. Loopback
+// connections, such as the exporter's cache manager requests, are not
+// counted. Missing files are skipped, e.g. tcp6 without IPv6 support.
+func ActiveConnections(port int, files ...string) (int, error) {
+	total := 0
+	for _, file := range files {
+		f, err := os.Open(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, err := countConnections(f, port)
+		f.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", file, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func countConnections(r io.Reader, port int) (int, error) {
+	scanner := bufio.NewScanner(r)
+	// Skip the header
+	scanner.Scan()
+	count := 0
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		local, remote, state := fields[1], fields[2], fields[3]
+		if state != tcpEstablished {
+			continue
+		}
+		_, localPort, err := parseAddress(local)
+		if err != nil {
+			return 0, err
+		}
+		remoteIP, _, err := parseAddress(remote)
+		if err != nil {
+			return 0, err
+		}
+		if localPort == port && !remoteIP.IsLoopback() {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// parseAddress parses an address of /proc/net/tcp such as "0100007F:0C38":
+// the IP address in hex, as 32-bit words in host (little endian) byte order,
+// and the port in hex
+func parseAddress(address string) (net.IP, int, error) {
+	ipHex, portHex, ok := strings.Cut(address, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid address %q", address)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in %q", address)
+	}
+	raw, err := hex.DecodeString(ipHex)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid IP address in %q", address)
+	}
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := range 4 {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip, int(port), nil
+}
+
+// Wait polls count every interval until it reports nothing active, and
+// returns ctx's error if that does not happen before ctx is done
+func Wait(ctx context.Context, interval time.Duration, count func() (int, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d still active: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Captured from a squid pod: a listening socket, a client, a loopback
+// cache manager request and a closing client connection
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0C38 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1001        0 41234 1 0000000000000000 100 0 0 10 0
+   1: 0B00F40A:0C38 0C00F40A:D2F0 01 00000000:00000000 00:00000000 00000000  1001        0 41301 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:0C38 0100007F:B3A2 01 00000000:00000000 00:00000000 00000000  1001        0 41302 1 0000000000000000 20 4 30 10 -1
+   3: 0B00F40A:0C38 0D00F40A:C350 06 00000000:00000000 03:00000DAC 00000000     0        0 0 3 0000000000000000
+   4: 0B00F40A:A1B2 0100000A:0050 01 00000000:00000000 00:00000000 00000000  1001        0 41310 1 0000000000000000 20 4 30 10 -1
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00000B00F40A:0C38 0000000000000000FFFF00000E00F40A:9C40 01 00000000:00000000 00:00000000 00000000  1001        0 41400 1 0000000000000000 20 4 30 10 -1
+   1: 00000000000000000000000001000000:0C38 00000000000000000000000001000000:9C41 01 00000000:00000000 00:00000000 00000000  1001        0 41401 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestActiveConnections(t *testing.T) {
+	dir := t.TempDir()
+	tcp := filepath.Join(dir, "tcp")
+	tcp6 := filepath.Join(dir, "tcp6")
+	if err := os.WriteFile(tcp, []byte(procNetTCP), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tcp6, []byte(procNetTCP6), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10.244.0.12 over IPv4 and 10.244.0.14 over an IPv4-mapped IPv6
+	// socket; loopback, closing and outgoing connections are not counted
+	n, err := ActiveConnections(3128, tcp, tcp6, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("ActiveConnections() = %d, want 2", n)
+	}
+
+	if n, err := ActiveConnections(9301, tcp, tcp6); err != nil || n != 0 {
+		t.Errorf("ActiveConnections() on another port = %d, %v, want 0", n, err)
+	}
+
+	if _, err := countConnections(strings.NewReader("header\n 0: zz:0C38 0100007F:0050 01\n"), 3128); err == nil {
+		t.Error("countConnections() should reject an invalid address")
+	}
+}
+
+// Captured from a squid pod: a download from a client, the cache manager
+// request listing the page and a request without a client connection
+const activeRequests = `Connection: 0x55d0c0a3e2f8
+	FD 12, read 112, wrote 40960
+	FD desc: Reading next request
+	in: buf 0x55d0c0a41000, used 0, free 4096
+	remote: 10.244.0.12:53972
+	local: 10.244.0.11:3128
+	nrequests: 3
+uri http://registry.example.com/v2/blobs/sha256:0a1b
+logType TCP_MISS
+out.offset 40960, out.size 40960
+req_sz 112
+entry 0x55d0c0b2a010/4F1D0C2B7A9E8D6C5B4A39281706F5E4
+start 1700000000.123456 (1.204000 seconds ago)
+username -
+delay_pool 0
+
+Connection: 0x55d0c0a3f4a0
+	FD 14, read 78, wrote 0
+	FD desc: Reading next request
+	in: buf 0x55d0c0a43000, used 0, free 4096
+	remote: 127.0.0.1:41388
+	local: 127.0.0.1:3128
+	nrequests: 1
+uri cache_object://localhost/active_requests
+logType TCP_MISS
+out.offset 0, out.size 0
+req_sz 78
+entry 0x55d0c0b2b020/N/A
+start 1700000001.327000 (0.000100 seconds ago)
+username -
+delay_pool 0
+
+Connection: 0x0
+uri http://registry.example.com/v2/
+logType TCP_MISS
+out.offset 0, out.size 0
+req_sz 0
+entry 0x0/N/A
+start 1700000001.100000 (0.227000 seconds ago)
+username -
+delay_pool 0
+
+`
+
+func TestCountRequests(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		page string
+		want int
+	}{
+		{name: "client, cache manager and internal requests", page: activeRequests, want: 1},
+		{name: "IPv6 and IPv4-mapped clients", page: "Connection: 0x1\n\tremote: [fd00::12]:53972\nConnection: 0x2\n\tremote: [::ffff:127.0.0.1]:41388\nConnection: 0x3\n\tremote: [::1]:41390\n", want: 1},
+		// Idle persistent connections are not listed
+		{name: "no requests", page: "", want: 0},
+	} {
+		if got := countRequests(test.page); got != test.want {
+			t.Errorf("%s: countRequests() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	remaining := 3
+	err := Wait(context.Background(), time.Millisecond, func() (int, error) {
+		remaining--
+		return remaining, nil
+	})
+	if err != nil || remaining != 0 {
+		t.Errorf("Wait() = %v with %d connections left", err, remaining)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = Wait(ctx, time.Millisecond, func() (int, error) { return 1, nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() with connections left = %v, want a deadline error", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package health checks that squid serves requests rather than merely
+// accepting connections: it queries the cache manager's info and storedir
+// pages, optionally fetches a synthetic object through the proxy, and reports
+// the resources squid is running out of.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+// SyntheticURL is one of squid's built-in icons. squid serves
+// /squid-internal-static/ paths itself whatever the host (global_internal_static),
+// so fetching it exercises request handling without reaching an origin.
+const SyntheticURL = "http://localhost/squid-internal-static/icons/SN.png"
+
+// Options configures a Check
+type Options struct {
+	// SyntheticURL is fetched through the proxy unless empty
+	SyntheticURL string
+	// MaxCacheDirUsage is the percentage of a cache_dir, or of the filesystem
+	// holding it, above which squid is degraded
+	MaxCacheDirUsage float64
+	// MaxFileDescriptorUsage is the percentage of squid's file descriptors in
+	// use above which squid is degraded
+	MaxFileDescriptorUsage float64
+}
+
+// Report is the outcome of a Check that reached squid
+type Report struct {
+	// Degraded lists why squid is degraded, empty when it is healthy
+	Degraded []string
+}
+
+// Info holds the figures of the cache manager's info page used by Check
+type Info struct {
+	MaxFileDescriptors   int
+	FileDescriptorsInUse int
+}
+
+// StoreDir holds the usage of a cache_dir, as reported by the storedir page
+type StoreDir struct {
+	Path string
+	// Usage is the percentage of the cache_dir's configured size in use
+	Usage float64
+	// FilesystemUsage is the percentage of the underlying filesystem in use
+	FilesystemUsage float64
+}
+
+// Check queries squid through client and fetches opts.SyntheticURL through
+// the proxy at client.Addr. It returns an error if squid does not answer
+// properly, and a report of its degraded resources otherwise.
+func Check(ctx context.Context, client *squidclient.Client, opts Options) (*Report, error) {
+	page, err := client.Mgr(ctx, "info")
+	if err != nil {
+		return nil, err
+	}
+	info, err := ParseInfo(page)
+	if err != nil {
+		return nil, err
+	}
+	page, err = client.Mgr(ctx, "storedir")
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := ParseStoreDirs(page)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SyntheticURL != "" {
+		if err := fetch(ctx, client, opts.SyntheticURL); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &Report{}
+	if info.MaxFileDescriptors > 0 {
+		usage := 100 * float64(info.FileDescriptorsInUse) / float64(info.MaxFileDescriptors)
+		if usage > opts.MaxFileDescriptorUsage {
+			report.Degraded = append(report.Degraded, fmt.Sprintf("%.1f%% of %d file descriptors in use",
+				usage, info.MaxFileDescriptors))
+		}
+	}
+	for _, dir := range dirs {
+		if dir.Usage > opts.MaxCacheDirUsage {
+			report.Degraded = append(report.Degraded, fmt.Sprintf("cache_dir %s is %.1f%% full", dir.Path, dir.Usage))
+		}
+		if dir.FilesystemUsage > opts.MaxCacheDirUsage {
+			report.Degraded = append(report.Degraded, fmt.Sprintf("filesystem of cache_dir %s is %.0f%% full",
+				dir.Path, dir.FilesystemUsage))
+		}
+	}
+	return report, nil
+}
+
+// fetch gets target through the proxy and fails unless squid answers it with
+// a non-empty 200 response
+func fetch(ctx context.Context, client *squidclient.Client, target string) error {
+	proxyURL := &url.URL{Scheme: "http", Host: client.Addr}
+	httpClient := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true},
+		Timeout:   client.Timeout,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("invalid synthetic URL: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s through squid: %w", target, err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	if resp.StatusCode != http.StatusOK || n == 0 {
+		return fmt.Errorf("fetching %s through squid returned status %d with %d bytes", target, resp.StatusCode, n)
+	}
+	return nil
+}
+
+// ParseInfo extracts the file descriptor figures of an info page, e.g.
+//
+//	File descriptor usage for squid:
+//		Maximum number of file descriptors:   1048576
+//		Number of file desc currently in use:   11
+func ParseInfo(page string) (*Info, error) {
+	info := &Info{}
+	var foundMax, foundInUse bool
+	for _, line := range strings.Split(page, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		var err error
+		switch name {
+		case "Maximum number of file descriptors":
+			info.MaxFileDescriptors, err = strconv.Atoi(strings.TrimSpace(value))
+			foundMax = true
+		case "Number of file desc currently in use":
+			info.FileDescriptorsInUse, err = strconv.Atoi(strings.TrimSpace(value))
+			foundInUse = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q in info page: %w", name, err)
+		}
+	}
+	if !foundMax || !foundInUse {
+		return nil, fmt.Errorf("info page lacks file descriptor usage")
+	}
+	return info, nil
+}
+
+// ParseStoreDirs extracts the cache_dirs of a storedir page. Every cache_dir
+// starts with a "Store Directory #<n> (<type>): <path>" line, e.g.
+//
+//	Store Directory #0 (aufs): /var/spool/squid
+//	Percent Used: 42.50%
+//	Filesystem Space in use: 1234/5678 KB (22%)
+func ParseStoreDirs(page string) ([]StoreDir, error) {
+	var dirs []StoreDir
+	for _, line := range strings.Split(page, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Store Directory #") {
+			_, path, _ := strings.Cut(line, "): ")
+			dirs = append(dirs, StoreDir{Path: path})
+			continue
+		}
+		if len(dirs) == 0 {
+			continue
+		}
+		dir := &dirs[len(dirs)-1]
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		var err error
+		switch name {
+		case "Percent Used":
+			dir.Usage, err = parsePercent(value)
+		case "Filesystem Space in use":
+			// "1234/5678 KB (22%)"
+			_, percent, _ := strings.Cut(value, "(")
+			dir.FilesystemUsage, err = parsePercent(strings.TrimSuffix(strings.TrimSpace(percent), ")"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q of cache_dir %s: %w", name, dir.Path, err)
+		}
+	}
+	return dirs, nil
+}
+
+func parsePercent(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+}
@@ -0,0 +1,200 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+// Captured from squid 6.10, trimmed
+const infoPage = `Squid Object Cache: Version 6.10
+Service Name: squid
+Connection information for squid:
+	Number of clients accessing cache:	0
+	Number of HTTP requests received:	42
+Cache information for squid:
+	Storage Swap size:	512 KB
+	Storage Swap capacity:	 0.1% used, 99.9% free
+File descriptor usage for squid:
+	Maximum number of file descriptors:   1024
+	Largest file desc currently in use:     15
+	Number of file desc currently in use:   IN_USE
+	Files queued for open:                   0
+	Available number of file descriptors: 1013
+	Reserved number of file descriptors:   100
+`
+
+const storeDirPage = `Store Directory Statistics:
+Store Entries          : 12
+Maximum Swap Size      : 204800 KB
+Current Store Swap Size: 512.00 KB
+Current Capacity       : 0.25% used, 99.75% free
+
+Store Directory #0 (aufs): /var/spool/squid
+FS Block Size 4096 Bytes
+First level subdirectories: 16
+Second level subdirectories: 256
+Maximum Size: 102400 KB
+Current Size: 99328.00 KB
+Percent Used: 97.00%
+Filemap bits in use: 10 of 8192 (0%)
+Filesystem Space in use: 1234/5678 KB (22%)
+Filesystem Inodes in use: 12/345 (3%)
+Flags: SELECTED
+Removal policy: lru
+
+Store Directory #1 (ufs): /var/cache/squid
+Percent Used: 1.50%
+Filesystem Space in use: 5600/5678 KB (99%)
+`
+
+// info returns infoPage with inUse file descriptors in use
+func info(inUse int) string {
+	return strings.Replace(infoPage, "IN_USE", strconv.Itoa(inUse), 1)
+}
+
+// fakeSquid answers every connection with the response listed for its
+// request target, or 404
+func fakeSquid(t *testing.T, responses map[string]string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Go's request parser rejects the cache_object scheme
+				reader := textproto.NewReader(bufio.NewReader(conn))
+				requestLine, err := reader.ReadLine()
+				if err != nil {
+					return
+				}
+				if _, err := reader.ReadMIMEHeader(); err != nil {
+					return
+				}
+				fields := strings.Fields(requestLine)
+				body, ok := responses[fields[1]]
+				if !ok {
+					conn.Write([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+					return
+				}
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestParseInfo(t *testing.T) {
+	got, err := ParseInfo(info(11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Info{MaxFileDescriptors: 1024, FileDescriptorsInUse: 11}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInfo() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseInfo("Squid Object Cache: Version 6.10\n"); err == nil {
+		t.Error("ParseInfo() should reject a page without file descriptor usage")
+	}
+}
+
+func TestParseStoreDirs(t *testing.T) {
+	dirs, err := ParseStoreDirs(storeDirPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StoreDir{
+		{Path: "/var/spool/squid", Usage: 97, FilesystemUsage: 22},
+		{Path: "/var/cache/squid", Usage: 1.5, FilesystemUsage: 99},
+	}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("ParseStoreDirs() = %+v, want %+v", dirs, want)
+	}
+
+	if dirs, err := ParseStoreDirs("Store Directory Statistics:\nStore Entries          : 12\n"); err != nil || len(dirs) != 0 {
+		t.Errorf("ParseStoreDirs() without cache_dirs = %+v, %v", dirs, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	opts := Options{SyntheticURL: SyntheticURL, MaxCacheDirUsage: 95, MaxFileDescriptorUsage: 90}
+
+	tests := []struct {
+		name         string
+		responses    map[string]string
+		wantErr      bool
+		wantDegraded []string
+	}{
+		{
+			name: "healthy",
+			responses: map[string]string{
+				"cache_object://localhost/info":     info(11),
+				"cache_object://localhost/storedir": "Store Directory Statistics:\n",
+				SyntheticURL:                        "PNG",
+			},
+		},
+		{
+			name: "degraded",
+			responses: map[string]string{
+				"cache_object://localhost/info":     info(1000),
+				"cache_object://localhost/storedir": storeDirPage,
+				SyntheticURL:                        "PNG",
+			},
+			wantDegraded: []string{
+				"97.7% of 1024 file descriptors in use",
+				"cache_dir /var/spool/squid is 97.0% full",
+				"filesystem of cache_dir /var/cache/squid is 99% full",
+			},
+		},
+		{
+			name: "synthetic fetch fails",
+			responses: map[string]string{
+				"cache_object://localhost/info":     info(11),
+				"cache_object://localhost/storedir": "Store Directory Statistics:\n",
+			},
+			wantErr: true,
+		},
+		{
+			name:      "cache manager fails",
+			responses: map[string]string{SyntheticURL: "PNG"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := squidclient.New(fakeSquid(t, tt.responses))
+			report, err := Check(context.Background(), client, opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Check() should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report.Degraded, tt.wantDegraded) {
+				t.Errorf("Check() degraded = %q, want %q", report.Degraded, tt.wantDegraded)
+			}
+		})
+	}
+}
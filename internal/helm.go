@@ -39,3 +39,19 @@ func WaitForNamespaceDeleted(namespace string) error {
 
 	return fmt.Errorf("timeout waiting for namespace '%s' to be deleted", namespace)
 }
+
+// WaitForAutoscaledReplicas waits until a Deployment scaled by a
+// HorizontalPodAutoscaler of the same name has its minimum number of ready
+// replicas. A Deployment without replicas in its manifest starts with one,
+// and the autoscaler only raises it afterwards.
+func WaitForAutoscaledReplicas(namespace, name string) error {
+	minReplicas, err := sh.Output("kubectl", "get", "hpa", name, "-n", namespace, "-o", "jsonpath={.spec.minReplicas}")
+	if err != nil {
+		// Not autoscaled
+		return nil
+	}
+
+	fmt.Printf("⏳ Waiting for %s ready replicas of deployment '%s'...\n", minReplicas, name)
+	return sh.Run("kubectl", "wait", "deployment/"+name, "-n", namespace,
+		"--for=jsonpath={.status.readyReplicas}="+minReplicas, "--timeout=120s")
+}
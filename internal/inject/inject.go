@@ -0,0 +1,207 @@
+// Package inject implements the mutating admission webhook that points pods
+// at the proxy. It sets the proxy environment variables of their containers
+// and mounts the CA bundle the chart distributes with trust-manager, so that
+// build pipelines no longer have to do either by hand.
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Annotation set to "false" on a pod opts it out of injection
+	Annotation = "caching.konflux-ci.dev/inject-proxy"
+	// InjectedAnnotation marks the pods the webhook mutated
+	InjectedAnnotation = "caching.konflux-ci.dev/proxy-injected"
+	// CAVolumeName is the name of the CA bundle volume added to pods
+	CAVolumeName = "proxy-ca-bundle"
+)
+
+// Config describes what is injected
+type Config struct {
+	// ProxyURL is the value of HTTP_PROXY and HTTPS_PROXY
+	ProxyURL string
+	// NoProxy lists the destinations reached directly, as NO_PROXY
+	NoProxy []string
+	// CABundleConfigMap names the ConfigMap holding the CA bundle in every
+	// namespace. No bundle is mounted if empty.
+	CABundleConfigMap string
+	// CABundleKey is the key of the bundle in the ConfigMap
+	CABundleKey string
+	// CABundleMountPath is the directory the bundle is mounted in
+	CABundleMountPath string
+}
+
+// CABundleFile returns the path of the CA bundle in injected containers
+func (c Config) CABundleFile() string {
+	return path.Join(c.CABundleMountPath, c.CABundleKey)
+}
+
+// Env returns the environment variables set in every container. The proxy
+// variables come in both cases since tools disagree on which one they read,
+// and the CA bundle variables cover OpenSSL and Go, Python requests and
+// Node.js.
+func (c Config) Env() []corev1.EnvVar {
+	noProxy := strings.Join(c.NoProxy, ",")
+	env := []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: c.ProxyURL},
+		{Name: "HTTPS_PROXY", Value: c.ProxyURL},
+		{Name: "NO_PROXY", Value: noProxy},
+		{Name: "http_proxy", Value: c.ProxyURL},
+		{Name: "https_proxy", Value: c.ProxyURL},
+		{Name: "no_proxy", Value: noProxy},
+	}
+	if c.CABundleConfigMap != "" {
+		bundle := c.CABundleFile()
+		env = append(env,
+			corev1.EnvVar{Name: "SSL_CERT_FILE", Value: bundle},
+			corev1.EnvVar{Name: "REQUESTS_CA_BUNDLE", Value: bundle},
+			corev1.EnvVar{Name: "NODE_EXTRA_CA_CERTS", Value: bundle},
+		)
+	}
+	return env
+}
+
+// Mutate injects the proxy configuration into pod and reports whether it
+// did. Variables and mounts the pod already defines are left alone, so that
+// pods can override what the webhook would set.
+func Mutate(pod *corev1.Pod, config Config) bool {
+	if pod.Annotations[Annotation] == "false" || pod.Annotations[InjectedAnnotation] == "true" {
+		return false
+	}
+
+	mountBundle := config.CABundleConfigMap != ""
+	if mountBundle {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.Name == CAVolumeName {
+				mountBundle = false
+			}
+		}
+	}
+	if mountBundle {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: CAVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: config.CABundleConfigMap},
+					Items:                []corev1.KeyToPath{{Key: config.CABundleKey, Path: config.CABundleKey}},
+				},
+			},
+		})
+	}
+
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			injectContainer(&containers[i], config, mountBundle)
+		}
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[InjectedAnnotation] = "true"
+	return true
+}
+
+func injectContainer(container *corev1.Container, config Config, mountBundle bool) {
+	defined := map[string]bool{}
+	for _, env := range container.Env {
+		defined[env.Name] = true
+	}
+	for _, env := range config.Env() {
+		if !defined[env.Name] {
+			container.Env = append(container.Env, env)
+		}
+	}
+
+	if !mountBundle {
+		return
+	}
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath == config.CABundleMountPath {
+			return
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      CAVolumeName,
+		MountPath: config.CABundleMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// patchOperation is a JSON patch (RFC 6902) operation
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Patch returns the JSON patch injecting the proxy configuration into pod,
+// or nil if the pod is left alone
+func Patch(pod *corev1.Pod, config Config) ([]byte, error) {
+	mutated := pod.DeepCopy()
+	if !Mutate(mutated, config) {
+		return nil, nil
+	}
+	// add replaces the members that already exist
+	patch := []patchOperation{
+		{Op: "add", Path: "/metadata/annotations", Value: mutated.Annotations},
+		{Op: "add", Path: "/spec/containers", Value: mutated.Spec.Containers},
+	}
+	if len(mutated.Spec.InitContainers) > 0 {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/initContainers", Value: mutated.Spec.InitContainers})
+	}
+	if len(mutated.Spec.Volumes) > 0 {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/volumes", Value: mutated.Spec.Volumes})
+	}
+	return json.Marshal(patch)
+}
+
+// Handler serves the admission webhook. It admits every pod, patching those
+// it injects into, so that a pod the webhook cannot make sense of is created
+// as is rather than rejected.
+func Handler(config Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+			http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+		var pod corev1.Pod
+		if err := json.Unmarshal(review.Request.Object.Raw, &pod); err != nil {
+			response.Warnings = []string{fmt.Sprintf("proxy injection skipped: %v", err)}
+		} else if patch, err := Patch(&pod, config); err != nil {
+			response.Warnings = []string{fmt.Sprintf("proxy injection skipped: %v", err)}
+		} else if patch != nil {
+			patchType := admissionv1.PatchTypeJSONPatch
+			response.Patch = patch
+			response.PatchType = &patchType
+			fmt.Printf("✅ Injected proxy configuration into pod %s/%s\n", review.Request.Namespace, podName(&pod))
+		}
+
+		review.Request = nil
+		review.Response = response
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			fmt.Printf("❌ Failed to write admission response: %v\n", err)
+		}
+	})
+}
+
+// podName returns the name of pod, which is only generated after admission
+// for pods created with generateName
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName + "*"
+}
@@ -0,0 +1,181 @@
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var config = Config{
+	ProxyURL:          "http://squid.proxy.svc.cluster.local:3128",
+	NoProxy:           []string{"localhost", ".svc"},
+	CABundleConfigMap: "proxy-ca-bundle",
+	CABundleKey:       "ca-bundle.crt",
+	CABundleMountPath: "/etc/proxy-ca",
+}
+
+func env(container corev1.Container) map[string]string {
+	values := map[string]string{}
+	for _, env := range container.Env {
+		values[env.Name] = env.Value
+	}
+	return values
+}
+
+func TestMutate(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "prepare"}},
+			Containers: []corev1.Container{
+				{Name: "build"},
+				// Explicit settings win over the injected ones
+				{
+					Name:         "offline",
+					Env:          []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: ""}},
+					VolumeMounts: []corev1.VolumeMount{{Name: "own-ca", MountPath: "/etc/proxy-ca"}},
+				},
+			},
+		},
+	}
+	if !Mutate(pod, config) {
+		t.Fatal("pod was not mutated")
+	}
+
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers[0]) {
+		values := env(container)
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			if values[name] != config.ProxyURL {
+				t.Errorf("%s: %s = %q, want %q", container.Name, name, values[name], config.ProxyURL)
+			}
+		}
+		if values["NO_PROXY"] != "localhost,.svc" || values["no_proxy"] != "localhost,.svc" {
+			t.Errorf("%s: NO_PROXY = %q, no_proxy = %q", container.Name, values["NO_PROXY"], values["no_proxy"])
+		}
+		if values["SSL_CERT_FILE"] != "/etc/proxy-ca/ca-bundle.crt" {
+			t.Errorf("%s: SSL_CERT_FILE = %q", container.Name, values["SSL_CERT_FILE"])
+		}
+		if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].Name != CAVolumeName {
+			t.Errorf("%s: volume mounts = %+v, want the CA bundle", container.Name, container.VolumeMounts)
+		}
+	}
+
+	offline := pod.Spec.Containers[1]
+	if values := env(offline); values["HTTPS_PROXY"] != "" || values["HTTP_PROXY"] != config.ProxyURL {
+		t.Errorf("offline: HTTPS_PROXY = %q, HTTP_PROXY = %q, want the explicit empty HTTPS_PROXY kept",
+			values["HTTPS_PROXY"], values["HTTP_PROXY"])
+	}
+	if len(offline.VolumeMounts) != 1 || offline.VolumeMounts[0].Name != "own-ca" {
+		t.Errorf("offline: volume mounts = %+v, want its own mount only", offline.VolumeMounts)
+	}
+
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].ConfigMap.Name != "proxy-ca-bundle" {
+		t.Errorf("volumes = %+v, want the CA bundle ConfigMap", pod.Spec.Volumes)
+	}
+	if pod.Annotations[InjectedAnnotation] != "true" {
+		t.Errorf("annotations = %v, want %s", pod.Annotations, InjectedAnnotation)
+	}
+
+	// Injection happens once
+	if Mutate(pod, config) {
+		t.Error("an injected pod was mutated again")
+	}
+}
+
+func TestMutateOptOut(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{Annotation: "false"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "build"}}},
+	}
+	if Mutate(pod, config) {
+		t.Error("a pod that opted out was mutated")
+	}
+	if len(pod.Spec.Containers[0].Env) != 0 {
+		t.Errorf("env = %+v, want none", pod.Spec.Containers[0].Env)
+	}
+}
+
+func TestMutateWithoutCABundle(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "build"}}}}
+	Mutate(pod, Config{ProxyURL: config.ProxyURL})
+	if len(pod.Spec.Volumes) != 0 || len(pod.Spec.Containers[0].VolumeMounts) != 0 {
+		t.Errorf("volumes = %+v, mounts = %+v, want none", pod.Spec.Volumes, pod.Spec.Containers[0].VolumeMounts)
+	}
+	if _, ok := env(pod.Spec.Containers[0])["SSL_CERT_FILE"]; ok {
+		t.Error("SSL_CERT_FILE set without a CA bundle")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "build-"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "build"}}},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("review-1"),
+			Namespace: "tenant-a",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler(config).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var got admissionv1.AdmissionReview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	response := got.Response
+	if response == nil || response.UID != "review-1" || !response.Allowed {
+		t.Fatalf("response = %+v, want review-1 allowed", response)
+	}
+	if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+		t.Fatalf("patch type = %v, want JSONPatch", response.PatchType)
+	}
+	// The patch applies to the pod as the API server received it
+	decoded, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := decoded.Apply(raw)
+	if err != nil {
+		t.Fatalf("patch %s does not apply: %v", response.Patch, err)
+	}
+	var injected corev1.Pod
+	if err := json.Unmarshal(patched, &injected); err != nil {
+		t.Fatal(err)
+	}
+	if env(injected.Spec.Containers[0])["HTTP_PROXY"] != config.ProxyURL {
+		t.Errorf("patched env = %+v, want HTTP_PROXY", injected.Spec.Containers[0].Env)
+	}
+	if len(injected.Spec.Volumes) != 1 || injected.Annotations[InjectedAnnotation] != "true" {
+		t.Errorf("patched pod = %+v, want the CA bundle volume and the injected annotation", injected)
+	}
+
+	recorder = httptest.NewRecorder()
+	Handler(config).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader([]byte("{}"))))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status for a review without request = %d, want 400", recorder.Code)
+	}
+}
@@ -0,0 +1,121 @@
+// Package podnamespace attributes proxy clients to Kubernetes namespaces by
+// the IP address of their pod. It backs the squid external_acl_type helper
+// used for per-tenant namespace ACLs.
+package podnamespace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/konflux-ci/caching/internal/squidhelper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const podIPIndex = "podIP"
+
+// Resolver maps a pod IP address to the namespace of the pod
+type Resolver interface {
+	Namespace(ip string) (string, bool)
+}
+
+// InformerResolver resolves pod IPs from a cluster-wide pod informer
+type InformerResolver struct {
+	indexer cache.Indexer
+}
+
+// indexPodIPs indexes running pods by their IPs. Host network pods share the
+// node's address and finished pods may have handed theirs over to a new pod,
+// so neither can be attributed.
+func indexPodIPs(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return nil, nil
+	}
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	return ips, nil
+}
+
+// stripPod drops everything but what the index needs, to keep the memory use
+// of a cluster-wide cache low
+func stripPod(obj any) (any, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Spec:   corev1.PodSpec{HostNetwork: pod.Spec.HostNetwork},
+		Status: corev1.PodStatus{Phase: pod.Status.Phase, PodIPs: pod.Status.PodIPs},
+	}, nil
+}
+
+// NewInformerResolver starts watching pods in all namespaces and returns once
+// the cache is synced or ctx is done
+func NewInformerResolver(ctx context.Context, clientset kubernetes.Interface) (*InformerResolver, error) {
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	informer := factory.Core().V1().Pods().Informer()
+	if err := informer.SetTransform(stripPod); err != nil {
+		return nil, fmt.Errorf("failed to set pod transform: %w", err)
+	}
+	if err := informer.AddIndexers(cache.Indexers{podIPIndex: indexPodIPs}); err != nil {
+		return nil, fmt.Errorf("failed to add pod IP index: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil, fmt.Errorf("failed to sync pod cache")
+	}
+	return &InformerResolver{indexer: informer.GetIndexer()}, nil
+}
+
+// Namespace returns the namespace of the running pod with the given IP
+func (r *InformerResolver) Namespace(ip string) (string, bool) {
+	objs, err := r.indexer.ByIndex(podIPIndex, ip)
+	if err != nil || len(objs) != 1 {
+		// Two live pods claiming an address is transient; refuse to guess
+		return "", false
+	}
+	return objs[0].(*corev1.Pod).Namespace, true
+}
+
+// Reply answers a single external ACL request line,
+// "[channel-ID] client-IP namespace...", with OK if the client pod runs in
+// one of the namespaces
+func Reply(resolver Resolver, line string) string {
+	channel, fields := squidhelper.Split(line)
+	if len(fields) == 0 {
+		return channel + "BH message=\"empty request\""
+	}
+
+	namespace, ok := resolver.Namespace(fields[0])
+	if !ok {
+		return channel + "ERR message=\"unknown client\""
+	}
+	if !slices.Contains(fields[1:], namespace) {
+		return channel + "ERR"
+	}
+	return channel + "OK tag=" + namespace
+}
+
+// Serve answers external ACL requests read from in until it is closed
+func Serve(resolver Resolver, in io.Reader, out io.Writer) error {
+	return squidhelper.Serve(in, out, func(line string) string {
+		return Reply(resolver, line)
+	})
+}
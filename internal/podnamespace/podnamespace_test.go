@@ -0,0 +1,82 @@
+package podnamespace
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func pod(namespace, name, ip string, phase corev1.PodPhase, hostNetwork bool) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.PodSpec{HostNetwork: hostNetwork},
+		Status: corev1.PodStatus{
+			Phase:  phase,
+			PodIP:  ip,
+			PodIPs: []corev1.PodIP{{IP: ip}},
+		},
+	}
+}
+
+func TestInformerResolver(t *testing.T) {
+	clientset := fake.NewClientset(
+		pod("tenant-a", "build", "10.244.0.10", corev1.PodRunning, false),
+		// A finished pod whose address was reused by a running one
+		pod("tenant-b", "done", "10.244.0.11", corev1.PodSucceeded, false),
+		pod("tenant-c", "build", "10.244.0.11", corev1.PodRunning, false),
+		pod("kube-system", "node-agent", "172.18.0.2", corev1.PodRunning, true),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resolver, err := NewInformerResolver(ctx, clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip        string
+		namespace string
+		ok        bool
+	}{
+		{"10.244.0.10", "tenant-a", true},
+		{"10.244.0.11", "tenant-c", true},
+		{"172.18.0.2", "", false},
+		{"10.244.0.99", "", false},
+	}
+	for _, tt := range tests {
+		namespace, ok := resolver.Namespace(tt.ip)
+		if namespace != tt.namespace || ok != tt.ok {
+			t.Errorf("Namespace(%s) = %q, %v, want %q, %v", tt.ip, namespace, ok, tt.namespace, tt.ok)
+		}
+	}
+}
+
+type staticResolver map[string]string
+
+func (r staticResolver) Namespace(ip string) (string, bool) {
+	namespace, ok := r[ip]
+	return namespace, ok
+}
+
+func TestReply(t *testing.T) {
+	resolver := staticResolver{"10.244.0.10": "tenant-a"}
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"10.244.0.10 tenant-a tenant-b", "OK tag=tenant-a"},
+		{"3 10.244.0.10 tenant-b", "3 ERR"},
+		{"4 10.244.0.99 tenant-a", "4 ERR message=\"unknown client\""},
+		{"5", "5 BH message=\"empty request\""},
+	}
+	for _, tt := range tests {
+		if got := Reply(resolver, tt.line); got != tt.want {
+			t.Errorf("Reply(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package prewarm fetches a list of URLs through the proxy so that they are
+// cached before the first builds need them.
+package prewarm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache statuses reported for a fetched URL
+const (
+	// StatusHit means the object was already cached
+	StatusHit = "HIT"
+	// StatusMiss means the object was fetched from the origin by this run
+	StatusMiss = "MISS"
+	// StatusError means the object could not be fetched
+	StatusError = "ERROR"
+)
+
+// Result reports how a single URL was warmed
+type Result struct {
+	URL         string `json:"url"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	CacheStatus string `json:"cacheStatus"`
+	Bytes       int64  `json:"bytes"`
+	// DurationMs is how long the fetch took, in milliseconds
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Summary aggregates the results of a run
+type Summary struct {
+	Total  int `json:"total"`
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+	Failed int `json:"failed"`
+}
+
+// FailureRatio is the share of URLs that could not be warmed
+func (s Summary) FailureRatio() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Total)
+}
+
+// Warmer fetches URLs through a proxy with bounded concurrency
+type Warmer struct {
+	// Client must be configured to use the proxy
+	Client *http.Client
+	// Concurrency bounds the number of URLs fetched at once
+	Concurrency int
+}
+
+// ReadURLs parses a URL manifest: one URL per line, blank lines and lines
+// starting with # are ignored
+func ReadURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read URL manifest: %w", err)
+	}
+	return urls, nil
+}
+
+// CacheStatus derives the squid cache status of a response from the X-Cache
+// headers added by every squid on the path, e.g. "MISS from squid-abc" or,
+// for an object served by a sibling, "HIT from squid-def"
+func CacheStatus(resp *http.Response) string {
+	values := resp.Header.Values("X-Cache")
+	if len(values) == 0 {
+		return StatusMiss
+	}
+	for _, value := range values {
+		if strings.HasPrefix(value, "HIT") {
+			return StatusHit
+		}
+	}
+	return StatusMiss
+}
+
+// Warm fetches every URL and returns the results in the order of urls
+func (w *Warmer) Warm(ctx context.Context, urls []string) []Result {
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]Result, len(urls))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, url := range urls {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = w.fetch(ctx, url)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fetch downloads a single URL in full, so that squid stores the whole object
+func (w *Warmer) fetch(ctx context.Context, url string) (result Result) {
+	result = Result{URL: url, CacheStatus: StatusError}
+	start := time.Now()
+	// result is named for the duration to reach the caller
+	defer func() { result.DurationMs = float64(time.Since(start)) / float64(time.Millisecond) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Bytes, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read body: %v", err)
+		return result
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+		return result
+	}
+
+	result.CacheStatus = CacheStatus(resp)
+	return result
+}
+
+// Summarize counts the outcomes of a run
+func Summarize(results []Result) Summary {
+	summary := Summary{Total: len(results)}
+	for _, result := range results {
+		switch result.CacheStatus {
+		case StatusHit:
+			summary.Hits++
+		case StatusMiss:
+			summary.Misses++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,93 @@
+package prewarm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadURLs(t *testing.T) {
+	manifest := `# base images
+http://example.com/a
+
+  http://example.com/b  
+# http://example.com/disabled
+`
+	urls, err := ReadURLs(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://example.com/a", "http://example.com/b"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("ReadURLs() = %v, want %v", urls, want)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, StatusMiss},
+		{[]string{"MISS from squid-a"}, StatusMiss},
+		{[]string{"HIT from squid-a"}, StatusHit},
+		// Served by a sibling: the sibling hit, the local squid missed
+		{[]string{"HIT from squid-b", "MISS from squid-a"}, StatusHit},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"X-Cache": tt.values}}
+		if got := CacheStatus(resp); got != tt.want {
+			t.Errorf("CacheStatus(%v) = %s, want %s", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	// A fake proxy: everything under /cached is a hit, /missing is a 404
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cached":
+			w.Header().Set("X-Cache", "HIT from squid-a")
+		case "/missing":
+			w.Header().Set("X-Cache", "MISS from squid-a")
+			http.NotFound(w, r)
+			return
+		default:
+			w.Header().Set("X-Cache", "MISS from squid-a")
+		}
+		w.Write([]byte("content"))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	warmer := &Warmer{
+		Client:      &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}},
+		Concurrency: 2,
+	}
+	urls := []string{"http://origin.test/cached", "http://origin.test/fresh", "http://origin.test/missing"}
+	results := warmer.Warm(context.Background(), urls)
+
+	for i, want := range []string{StatusHit, StatusMiss, StatusError} {
+		if results[i].URL != urls[i] || results[i].CacheStatus != want {
+			t.Errorf("result %d = %+v, want %s for %s", i, results[i], want, urls[i])
+		}
+	}
+	if results[1].Bytes != int64(len("content")) {
+		t.Errorf("fresh object should be fetched in full, got %d bytes", results[1].Bytes)
+	}
+	if results[1].DurationMs <= 0 {
+		t.Errorf("fresh object took %vms, want a positive duration", results[1].DurationMs)
+	}
+
+	summary := Summarize(results)
+	if summary != (Summary{Total: 3, Hits: 1, Misses: 1, Failed: 1}) {
+		t.Errorf("Summarize() = %+v", summary)
+	}
+	if ratio := summary.FailureRatio(); ratio < 0.33 || ratio > 0.34 {
+		t.Errorf("FailureRatio() = %v", ratio)
+	}
+}
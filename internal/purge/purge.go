@@ -0,0 +1,251 @@
+// Package purge implements the cache purge API served next to every squid
+// replica. A purge request received by any replica is fanned out to all of
+// them, and each one evicts the matching objects from its local squid.
+package purge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/squidclient"
+	"github.com/konflux-ci/caching/internal/storeid"
+)
+
+// Request selects the objects to purge: a single URL, or every cached object
+// whose URL or store ID matches Pattern
+type Request struct {
+	URL     string `json:"url,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// ReplicaResult reports what a single replica purged
+type ReplicaResult struct {
+	Replica string   `json:"replica"`
+	Purged  []string `json:"purged"`
+	Error   string   `json:"error,omitempty"`
+	// Skipped explains why a replica that could not be reached was left out,
+	// like one that is starting or shutting down during a rollout
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Response is returned by the purge endpoint
+type Response struct {
+	Replicas []ReplicaResult `json:"replicas"`
+}
+
+// Server serves the purge API
+type Server struct {
+	// Squid is the local squid instance
+	Squid *squidclient.Client
+	// Rewriter computes the store ID of purged URLs, so that objects cached
+	// under a rewritten store ID are evicted too. Optional.
+	Rewriter *storeid.Rewriter
+	// Token is the bearer token required on purge requests
+	Token string
+	// Peers is a DNS name resolving to the addresses of all replicas. When
+	// empty, purge requests are only applied locally.
+	Peers string
+	// Port is the port the purge API listens on in every replica
+	Port int
+
+	client *http.Client
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+// maxRequestBytes bounds the size of a purge request body
+const maxRequestBytes = 64 << 10
+
+// NewServer creates a purge API server for the local squid, which fans purge
+// requests out to the replicas behind peers on port
+func NewServer(squid *squidclient.Client, token, peers string, port int) *Server {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Server{
+		Squid: squid,
+		Token: token,
+		Peers: peers,
+		Port:  port,
+		client: &http.Client{
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			Timeout:   60 * time.Second,
+		},
+		lookup: net.DefaultResolver.LookupHost,
+	}
+}
+
+// Handler returns the HTTP handler of the purge API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/purge", s.handlePurge)
+	return mux
+}
+
+// authorized checks the bearer token of a request in constant time
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="squid-purge"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if (req.URL == "") == (req.Pattern == "") {
+		http.Error(w, "exactly one of url and pattern is required", http.StatusBadRequest)
+		return
+	}
+	if req.Pattern != "" {
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var resp Response
+	if r.URL.Query().Get("scope") == "local" || s.Peers == "" {
+		resp.Replicas = []ReplicaResult{s.purgeLocal(r.Context(), req)}
+	} else {
+		resp.Replicas = s.fanOut(r.Context(), req, r.Header.Get("Authorization"))
+	}
+
+	status := http.StatusOK
+	for _, replica := range resp.Replicas {
+		if replica.Error != "" {
+			status = http.StatusBadGateway
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// purgeLocal applies a purge request to the local squid
+func (s *Server) purgeLocal(ctx context.Context, req Request) ReplicaResult {
+	result := ReplicaResult{Replica: "localhost", Purged: []string{}}
+
+	var candidates []string
+	if req.URL != "" {
+		candidates = []string{req.URL}
+		if s.Rewriter != nil {
+			if storeID, ok := s.Rewriter.Rewrite(req.URL); ok {
+				candidates = append(candidates, storeID)
+			}
+		}
+	} else {
+		objects, err := s.Squid.Objects(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		re := regexp.MustCompile(req.Pattern)
+		for _, object := range objects {
+			if re.MatchString(object) {
+				candidates = append(candidates, object)
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		purged, err := s.Squid.Purge(ctx, candidate)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if purged {
+			result.Purged = append(result.Purged, candidate)
+		}
+	}
+	return result
+}
+
+// fanOut forwards a purge request to every replica behind Peers. The peers
+// Service publishes replicas before they are ready, so replicas that refuse
+// or time out the connection are skipped: their purge API is not up yet, or
+// no more, and neither is their squid.
+func (s *Server) fanOut(ctx context.Context, req Request, authorization string) []ReplicaResult {
+	addresses, err := s.lookup(ctx, s.Peers)
+	if err != nil {
+		return []ReplicaResult{{Replica: s.Peers, Purged: []string{}, Error: fmt.Sprintf("failed to resolve replicas: %v", err)}}
+	}
+	sort.Strings(addresses)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return []ReplicaResult{{Replica: s.Peers, Purged: []string{}, Error: err.Error()}}
+	}
+
+	results := make([]ReplicaResult, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.purgeReplica(ctx, address, body, authorization)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// purgeReplica forwards a purge request to the purge API of one replica
+func (s *Server) purgeReplica(ctx context.Context, address string, body []byte, authorization string) ReplicaResult {
+	result := ReplicaResult{Replica: address, Purged: []string{}}
+
+	url := fmt.Sprintf("http://%s/purge?scope=local", net.JoinHostPort(address, strconv.Itoa(s.Port)))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", authorization)
+
+	resp, err := s.client.Do(httpReq)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		result.Skipped = fmt.Sprintf("unreachable: %v", opErr.Err)
+		return result
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("request failed: %v", err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	var replicaResp Response
+	if err := json.NewDecoder(resp.Body).Decode(&replicaResp); err != nil || len(replicaResp.Replicas) != 1 {
+		result.Error = fmt.Sprintf("unexpected response (status %d)", resp.StatusCode)
+		return result
+	}
+
+	result.Purged = replicaResp.Replicas[0].Purged
+	result.Error = replicaResp.Replicas[0].Error
+	return result
+}
@@ -0,0 +1,208 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/konflux-ci/caching/internal/squidclient"
+)
+
+// fakeSquid answers PURGE requests for the cached URLs, which it then
+// forgets, and lists them on the objects cache manager page
+func fakeSquid(t *testing.T, cached ...string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	objects := map[string]bool{}
+	for _, url := range cached {
+		objects[url] = true
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := textproto.NewReader(bufio.NewReader(conn))
+				requestLine, err := reader.ReadLine()
+				if err != nil {
+					return
+				}
+				if _, err := reader.ReadMIMEHeader(); err != nil {
+					return
+				}
+				method, target, _ := strings.Cut(strings.TrimSuffix(requestLine, " HTTP/1.0"), " ")
+
+				mu.Lock()
+				defer mu.Unlock()
+				status, body := "404 Not Found", ""
+				switch {
+				case method == "PURGE" && objects[target]:
+					delete(objects, target)
+					status = "200 OK"
+				case target == "cache_object://localhost/objects":
+					status = "200 OK"
+					for url := range objects {
+						body += "KEY 0123456789ABCDEF\n\tGET " + url + "\n"
+					}
+				}
+				fmt.Fprintf(conn, "HTTP/1.0 %s\r\nContent-Length: %d\r\n\r\n%s", status, len(body), body)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func post(t *testing.T, handler http.Handler, token, body string) (int, Response) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/purge", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var resp Response
+	if rec.Header().Get("Content-Type") == "application/json" {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestPurgeRejectsRequests(t *testing.T) {
+	handler := NewServer(squidclient.New(fakeSquid(t)), "secret", "", 9302).Handler()
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "missing token", body: `{"url":"http://example.com/a"}`, status: http.StatusUnauthorized},
+		{name: "wrong token", token: "guess", body: `{"url":"http://example.com/a"}`, status: http.StatusUnauthorized},
+		{name: "url and pattern", token: "secret", body: `{"url":"http://example.com/a","pattern":"a"}`, status: http.StatusBadRequest},
+		{name: "neither url nor pattern", token: "secret", body: `{}`, status: http.StatusBadRequest},
+		{name: "invalid pattern", token: "secret", body: `{"pattern":"(unclosed"}`, status: http.StatusBadRequest},
+		{name: "invalid body", token: "secret", body: `url=http://example.com/a`, status: http.StatusBadRequest},
+		{name: "oversized body", token: "secret", body: `{"url":"http://example.com/` + strings.Repeat("a", maxRequestBytes) + `"}`, status: http.StatusBadRequest},
+	} {
+		if status, _ := post(t, handler, test.token, test.body); status != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/purge", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: status = %d, Allow = %q, want 405 and POST", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestPurgeLocal(t *testing.T) {
+	squid := fakeSquid(t, "http://example.com/builds/1/a", "http://example.com/builds/1/b", "http://example.com/builds/2/a")
+	handler := NewServer(squidclient.New(squid), "secret", "", 9302).Handler()
+
+	status, resp := post(t, handler, "secret", `{"url":"http://example.com/builds/2/a"}`)
+	want := []ReplicaResult{{Replica: "localhost", Purged: []string{"http://example.com/builds/2/a"}}}
+	if status != http.StatusOK || !reflect.DeepEqual(resp.Replicas, want) {
+		t.Errorf("purge url: %d %+v, want 200 %+v", status, resp.Replicas, want)
+	}
+
+	// Not cached (anymore)
+	status, resp = post(t, handler, "secret", `{"url":"http://example.com/builds/2/a"}`)
+	want = []ReplicaResult{{Replica: "localhost", Purged: []string{}}}
+	if status != http.StatusOK || !reflect.DeepEqual(resp.Replicas, want) {
+		t.Errorf("purge missing url: %d %+v, want 200 %+v", status, resp.Replicas, want)
+	}
+
+	status, resp = post(t, handler, "secret", `{"pattern":"^http://example\\.com/builds/1/"}`)
+	if status != http.StatusOK || len(resp.Replicas) != 1 {
+		t.Fatalf("purge pattern: %d %+v", status, resp.Replicas)
+	}
+	purged := resp.Replicas[0].Purged
+	if len(purged) != 2 || !strings.HasPrefix(purged[0], "http://example.com/builds/1/") || !strings.HasPrefix(purged[1], "http://example.com/builds/1/") {
+		t.Errorf("purge pattern purged %v, want the 2 objects of build 1", purged)
+	}
+}
+
+func TestPurgeFanOut(t *testing.T) {
+	// Replicas listen on the same port of different loopback addresses
+	healthy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := healthy.Addr().(*net.TCPAddr).Port
+	failing, err := net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", port))
+	if err != nil {
+		healthy.Close()
+		t.Skipf("no second loopback address: %v", err)
+	}
+
+	serve := func(listener net.Listener, squid string) {
+		server := &httptest.Server{Listener: listener, Config: &http.Server{
+			Handler: NewServer(squidclient.New(squid), "secret", "", port).Handler(),
+		}}
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+	serve(healthy, fakeSquid(t, "http://example.com/a"))
+	// Its squid is down
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+	serve(failing, unreachable.Addr().String())
+
+	server := NewServer(squidclient.New(fakeSquid(t)), "secret", "squid-peers", port)
+	// The third replica is starting: nothing listens on its purge port yet
+	server.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.3", "127.0.0.2", "127.0.0.1"}, nil
+	}
+
+	status, resp := post(t, server.Handler(), "secret", `{"url":"http://example.com/a"}`)
+	if status != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502 as a replica failed", status)
+	}
+	if len(resp.Replicas) != 3 {
+		t.Fatalf("replicas = %+v, want 3", resp.Replicas)
+	}
+	if got := resp.Replicas[0]; got.Replica != "127.0.0.1" || !reflect.DeepEqual(got.Purged, []string{"http://example.com/a"}) || got.Error != "" {
+		t.Errorf("healthy replica = %+v", got)
+	}
+	if got := resp.Replicas[1]; got.Replica != "127.0.0.2" || !strings.Contains(got.Error, "failed to connect to squid") {
+		t.Errorf("failing replica = %+v, want its squid's error", got)
+	}
+	if got := resp.Replicas[2]; got.Replica != "127.0.0.3" || got.Error != "" || got.Skipped == "" {
+		t.Errorf("starting replica = %+v, want it skipped", got)
+	}
+
+	// Without the failing replica, skipped ones don't fail the purge
+	server.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.3"}, nil
+	}
+	if status, resp := post(t, server.Handler(), "secret", `{"url":"http://example.com/a"}`); status != http.StatusOK {
+		t.Errorf("status = %d, want 200 with a replica skipped: %+v", status, resp.Replicas)
+	}
+}
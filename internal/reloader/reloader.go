@@ -0,0 +1,191 @@
+// Package reloader applies squid configuration changes without restarting
+// squid: it watches the mounted configuration, validates changes with
+// `squid -k parse` and applies valid ones with `squid -k reconfigure`, which
+// keeps the in-memory cache.
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RunFunc runs squid with the given arguments and returns its combined output
+type RunFunc func(ctx context.Context, args ...string) ([]byte, error)
+
+// Exec returns a RunFunc executing the squid binary at path
+func Exec(path string) RunFunc {
+	return func(ctx context.Context, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, path, args...).CombinedOutput()
+	}
+}
+
+// Reloader reconfigures squid whenever the files in the watched directories
+// change
+type Reloader struct {
+	// Dirs are the directories to watch, typically ConfigMap mounts
+	Dirs []string
+	// Config is the path of the squid configuration file
+	Config string
+	// Interval is how often the directories are checked for changes
+	Interval time.Duration
+	// Run runs squid
+	Run RunFunc
+
+	reloads       *prometheus.CounterVec
+	lastSucceeded prometheus.Gauge
+	lastReload    prometheus.Gauge
+	lastSuccess   prometheus.Gauge
+}
+
+// New creates a Reloader and registers its metrics with registerer
+func New(dirs []string, config string, interval time.Duration, run RunFunc, registerer prometheus.Registerer) *Reloader {
+	r := &Reloader{
+		Dirs:     dirs,
+		Config:   config,
+		Interval: interval,
+		Run:      run,
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "squid_reloader_reloads_total",
+			Help: "Configuration reloads attempted, by result (success or failure).",
+		}, []string{"result"}),
+		lastSucceeded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "squid_reloader_last_reload_successful",
+			Help: "Whether the last configuration reload succeeded (1) or failed (0).",
+		}),
+		lastReload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "squid_reloader_last_reload_timestamp_seconds",
+			Help: "Time of the last configuration reload attempt.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "squid_reloader_last_reload_success_timestamp_seconds",
+			Help: "Time of the last successful configuration reload.",
+		}),
+	}
+	// Report both results from the start so that rates work right away
+	r.reloads.WithLabelValues("success")
+	r.reloads.WithLabelValues("failure")
+	// The configuration squid started with is the last one applied
+	r.lastSucceeded.Set(1)
+	registerer.MustRegister(r.reloads, r.lastSucceeded, r.lastReload, r.lastSuccess)
+	return r
+}
+
+// Fingerprint hashes the names and contents of the files in the watched
+// directories. Kubernetes' own bookkeeping entries ("..data" and the
+// timestamped directories) are skipped, the files are read through them.
+func (r *Reloader) Fingerprint() (string, error) {
+	hash := sha256.New()
+	for _, dir := range r.Dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), "..") {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to stat %s: %w", path, err)
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00", path, len(content))
+			hash.Write(content)
+		}
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Reload validates the configuration and, if it is valid, tells squid to
+// apply it. An invalid configuration is left for squid to never see; it keeps
+// running with the previous one.
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.lastReload.SetToCurrentTime()
+	err := r.reload(ctx)
+	if err != nil {
+		r.reloads.WithLabelValues("failure").Inc()
+		r.lastSucceeded.Set(0)
+		return err
+	}
+	r.reloads.WithLabelValues("success").Inc()
+	r.lastSucceeded.Set(1)
+	r.lastSuccess.SetToCurrentTime()
+	return nil
+}
+
+func (r *Reloader) reload(ctx context.Context) error {
+	output, err := r.Run(ctx, "-k", "parse", "-f", r.Config)
+	// Some errors are only reported, without failing the parse
+	if err == nil && bytes.Contains(output, []byte("FATAL")) {
+		err = fmt.Errorf("fatal errors reported")
+	}
+	if err != nil {
+		return fmt.Errorf("configuration is invalid: %w\n%s", err, output)
+	}
+
+	output, err = r.Run(ctx, "-k", "reconfigure", "-f", r.Config)
+	if err != nil {
+		return fmt.Errorf("failed to reconfigure squid: %w\n%s", err, output)
+	}
+	return nil
+}
+
+// Watch checks the watched directories every Interval and reloads squid
+// whenever their contents change, until ctx is done
+func (r *Reloader) Watch(ctx context.Context) error {
+	last, err := r.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := r.Fingerprint()
+		if err != nil {
+			// A ConfigMap update swaps symlinks, a read can race with it
+			fmt.Printf("⚠️  %v\n", err)
+			continue
+		}
+		if current == last {
+			continue
+		}
+		// A broken configuration is not retried until it changes again
+		last = current
+
+		fmt.Printf("🔄 Configuration changed, reloading squid\n")
+		if err := r.Reload(ctx); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		fmt.Printf("✅ Squid reconfigured\n")
+	}
+}
@@ -0,0 +1,133 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSquid records the commands run and fails "-k parse" while invalid is set
+type fakeSquid struct {
+	commands [][]string
+	invalid  bool
+}
+
+func (f *fakeSquid) run(_ context.Context, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, args)
+	if f.invalid && args[1] == "parse" {
+		return []byte("FATAL: Bungled squid.conf line 1: bogus"), errors.New("exit status 1")
+	}
+	return nil, nil
+}
+
+// writeConfigMap lays out a directory the way the kubelet mounts a ConfigMap:
+// the files are symlinks through "..data" to a timestamped directory
+func writeConfigMap(t *testing.T, dir, squidConf string) {
+	t.Helper()
+	version := filepath.Join(dir, ".."+time.Now().Format("2006_01_02_15_04_05.000000000"))
+	if err := os.Mkdir(version, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(version, "squid.conf"), []byte(squidConf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "..data")
+	os.Remove(data)
+	if err := os.Symlink(filepath.Base(version), data); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "squid.conf")
+	if _, err := os.Lstat(link); err != nil {
+		if err := os.Symlink(filepath.Join("..data", "squid.conf"), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigMap(t, dir, "http_port 3128\n")
+	r := New([]string{dir}, filepath.Join(dir, "squid.conf"), time.Second, nil, prometheus.NewRegistry())
+
+	first, err := r.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := r.Fingerprint(); again != first {
+		t.Error("Fingerprint() changed without a change")
+	}
+
+	writeConfigMap(t, dir, "http_port 3129\n")
+	if changed, _ := r.Fingerprint(); changed == first {
+		t.Error("Fingerprint() did not change with the ConfigMap")
+	}
+}
+
+func TestReload(t *testing.T) {
+	squid := &fakeSquid{}
+	r := New(nil, "/etc/squid/config/squid.conf", time.Second, squid.run, prometheus.NewRegistry())
+
+	if err := r.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	want := [][]string{
+		{"-k", "parse", "-f", "/etc/squid/config/squid.conf"},
+		{"-k", "reconfigure", "-f", "/etc/squid/config/squid.conf"},
+	}
+	if !reflect.DeepEqual(squid.commands, want) {
+		t.Errorf("commands = %v, want %v", squid.commands, want)
+	}
+
+	squid.commands, squid.invalid = nil, true
+	err := r.Reload(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Bungled") {
+		t.Errorf("Reload() error = %v, want the parse output", err)
+	}
+	if len(squid.commands) != 1 {
+		t.Errorf("an invalid configuration must not be applied, ran %v", squid.commands)
+	}
+
+	if got := testutil.ToFloat64(r.reloads.WithLabelValues("success")); got != 1 {
+		t.Errorf("successful reloads = %v", got)
+	}
+	if got := testutil.ToFloat64(r.reloads.WithLabelValues("failure")); got != 1 {
+		t.Errorf("failed reloads = %v", got)
+	}
+	if got := testutil.ToFloat64(r.lastSucceeded); got != 0 {
+		t.Errorf("last reload successful = %v", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigMap(t, dir, "http_port 3128\n")
+	squid := &fakeSquid{}
+	r := New([]string{dir}, filepath.Join(dir, "squid.conf"), 10*time.Millisecond, squid.run, prometheus.NewRegistry())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Watch(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	writeConfigMap(t, dir, "http_port 3129\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(r.reloads.WithLabelValues("success")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(r.reloads.WithLabelValues("success")); got != 1 {
+		t.Errorf("successful reloads = %v, want exactly one for one change", got)
+	}
+}
@@ -0,0 +1,166 @@
+// Package squidclient talks to a squid instance directly, the way the
+// squidclient tool does: cache manager pages and PURGE requests are written
+// as raw HTTP/1.0 requests so that non-HTTP URL schemes (cache_object://) and
+// store IDs can be used verbatim.
+package squidclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client sends requests to the squid listening on Addr (host:port)
+type Client struct {
+	Addr string
+	// Login and Password authenticate cache manager requests when squid is
+	// configured with cachemgr_passwd
+	Login    string
+	Password string
+	// Timeout bounds every request, including reading the response
+	Timeout time.Duration
+}
+
+// New creates a client for the squid listening on addr
+func New(addr string) *Client {
+	return &Client{Addr: addr, Timeout: 30 * time.Second}
+}
+
+// do writes a raw request line (and headers) and returns the parsed response
+// with its body fully read
+func (c *Client) do(ctx context.Context, method, target string, headers ...string) (int, []byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to connect to squid at %s: %w", c.Addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, nil, fmt.Errorf("failed to set deadline: %w", err)
+		}
+	}
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "%s %s HTTP/1.0\r\n", method, target)
+	for _, header := range headers {
+		request.WriteString(header + "\r\n")
+	}
+	request.WriteString("\r\n")
+	if _, err := io.WriteString(conn, request.String()); err != nil {
+		return 0, nil, fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read %s response: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read %s response body: %w", method, err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// Mgr fetches a cache manager page, e.g. "info", "counters" or "objects"
+func (c *Client) Mgr(ctx context.Context, page string) (string, error) {
+	headers := []string{"Host: localhost", "Accept: */*"}
+	if c.Login != "" || c.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(c.Login + ":" + c.Password))
+		headers = append(headers, "Authorization: Basic "+credentials)
+	}
+
+	status, body, err := c.do(ctx, http.MethodGet, "cache_object://localhost/"+page, headers...)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("cache manager page %q returned status %d", page, status)
+	}
+	return string(body), nil
+}
+
+// Purge removes the object stored under url (or store ID) from the cache and
+// reports whether squid held it
+func (c *Client) Purge(ctx context.Context, url string) (bool, error) {
+	status, _, err := c.do(ctx, "PURGE", url, "Accept: */*")
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("PURGE %s returned status %d", url, status)
+	}
+}
+
+// Objects lists the URLs (store IDs for rewritten objects) of the objects
+// held in memory, as reported by the "objects" cache manager page
+func (c *Client) Objects(ctx context.Context) ([]string, error) {
+	page, err := c.Mgr(ctx, "objects")
+	if err != nil {
+		return nil, err
+	}
+	return ParseObjects(page), nil
+}
+
+// ParseObjects extracts the object URLs from an "objects" cache manager page.
+// Every entry starts with a "KEY <hash>" line followed by indented details,
+// one of which is "<METHOD> <url>".
+func ParseObjects(page string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	inEntry := false
+
+	for _, line := range strings.Split(page, "\n") {
+		if strings.HasPrefix(line, "KEY ") {
+			inEntry = true
+			continue
+		}
+		if !inEntry {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !isMethod(fields[0]) || !strings.Contains(fields[1], "://") {
+			continue
+		}
+		if !seen[fields[1]] {
+			seen[fields[1]] = true
+			urls = append(urls, fields[1])
+		}
+		inEntry = false
+	}
+	return urls
+}
+
+// isMethod reports whether token looks like an HTTP request method
+func isMethod(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package squidclient
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSquid accepts a single connection, records its request line and
+// answers with the given raw response
+func fakeSquid(t *testing.T, response string) (string, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Go's request parser rejects the cache_object scheme, so read the
+		// request line and headers as plain MIME
+		reader := textproto.NewReader(bufio.NewReader(conn))
+		requestLine, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		requests <- strings.TrimSuffix(requestLine, " HTTP/1.0") + " " + header.Get("Authorization")
+		conn.Write([]byte(response))
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+func TestMgr(t *testing.T) {
+	addr, requests := fakeSquid(t, "HTTP/1.1 200 OK\r\nContent-Length: 12\r\n\r\nSquid Object")
+
+	client := New(addr)
+	client.Login, client.Password = "admin", "secret"
+	page, err := client.Mgr(context.Background(), "info")
+	if err != nil {
+		t.Fatalf("Mgr() error = %v", err)
+	}
+	if page != "Squid Object" {
+		t.Errorf("Mgr() = %q", page)
+	}
+	if got := <-requests; got != "GET cache_object://localhost/info Basic YWRtaW46c2VjcmV0" {
+		t.Errorf("request = %q", got)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		response string
+		purged   bool
+		wantErr  bool
+	}{
+		{response: "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", purged: true},
+		{response: "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", purged: false},
+		{response: "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		addr, requests := fakeSquid(t, tt.response)
+		purged, err := New(addr).Purge(context.Background(), "http://blobs.store-id.squid.internal/sha256:ab")
+		if (err != nil) != tt.wantErr || purged != tt.purged {
+			t.Errorf("Purge() on %q = %v, %v", strings.SplitN(tt.response, "\r\n", 2)[0], purged, err)
+		}
+		if got := <-requests; got != "PURGE http://blobs.store-id.squid.internal/sha256:ab " {
+			t.Errorf("request = %q", got)
+		}
+	}
+}
+
+func TestParseObjects(t *testing.T) {
+	page := `KEY 5C5E2D0CA1DFB36AAA3C5A3DFA0D2B94
+	STORE_OK      IN_MEMORY     SWAPOUT_NONE PING_NONE
+	REVALIDATE_NEVER,DISPATCHED,VALIDATED
+	LV:1700000000 LU:1700000000 LM:-1        EX:1700000300
+	0 locks, 0 clients, 1 refs
+	Swap Dir -1, File 0XFFFFFFFF
+	GET http://10.244.0.5:9090/?test=a
+	inmem_lo: 0
+	inmem_hi: 215
+	swapout: 0 bytes queued
+KEY 0F3B1C44E1E2C9D94F4E4E7A0A81A5B9
+	STORE_OK      IN_MEMORY     SWAPOUT_NONE PING_DONE
+	GET http://blobs.store-id.squid.internal/sha256:ab
+KEY 0F3B1C44E1E2C9D94F4E4E7A0A81A5BA
+	STORE_OK      IN_MEMORY     SWAPOUT_NONE PING_DONE
+	HEAD http://10.244.0.5:9090/?test=a
+`
+
+	want := []string{
+		"http://10.244.0.5:9090/?test=a",
+		"http://blobs.store-id.squid.internal/sha256:ab",
+	}
+	if got := ParseObjects(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseObjects() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+// Package squidctl parses and aggregates what squidctl collects from the
+// squid replicas: cache manager pages and access logs.
+package squidctl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+	"github.com/konflux-ci/caching/internal/analytics"
+)
+
+// Info is the summary of a replica's "info" cache manager page
+type Info struct {
+	Version  string        `json:"version"`
+	Uptime   time.Duration `json:"uptime"`
+	Clients  int64         `json:"clients"`
+	Requests int64         `json:"requests"`
+	// RequestHitRatio and ByteHitRatio cover the last 5 minutes
+	RequestHitRatio float64 `json:"requestHitRatio"`
+	ByteHitRatio    float64 `json:"byteHitRatio"`
+	StoreEntries    int64   `json:"storeEntries"`
+	MemSizeKB       int64   `json:"memSizeKB"`
+	SwapSizeKB      int64   `json:"swapSizeKB"`
+}
+
+// ParseInfo extracts the summary from an "info" cache manager page. Its lines
+// are "Key: value", indented under section titles, except for the counts of
+// the internal data structures, which are "<count> <name>".
+func ParseInfo(page string) Info {
+	var info Info
+	for _, line := range strings.Split(page, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			if count, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && strings.TrimSpace(name) == "StoreEntries" {
+				info.StoreEntries = leadingInt(count)
+			}
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Squid Object Cache":
+			info.Version = strings.TrimPrefix(value, "Version ")
+		case "UP Time":
+			info.Uptime = time.Duration(leadingFloat(value) * float64(time.Second)).Round(time.Second)
+		case "Number of clients accessing cache":
+			info.Clients = leadingInt(value)
+		case "Number of HTTP requests received":
+			info.Requests = leadingInt(value)
+		case "Request Hit Ratios":
+			info.RequestHitRatio = fiveMinuteRatio(value)
+		case "Byte Hit Ratios":
+			info.ByteHitRatio = fiveMinuteRatio(value)
+		case "Storage Mem size":
+			info.MemSizeKB = leadingInt(value)
+		case "Storage Swap size":
+			info.SwapSizeKB = leadingInt(value)
+		}
+	}
+	return info
+}
+
+// InfoTotal sums the Info of several replicas
+type InfoTotal struct {
+	Replicas     int   `json:"replicas"`
+	Clients      int64 `json:"clients"`
+	Requests     int64 `json:"requests"`
+	StoreEntries int64 `json:"storeEntries"`
+	MemSizeKB    int64 `json:"memSizeKB"`
+	SwapSizeKB   int64 `json:"swapSizeKB"`
+}
+
+// Add counts the Info of another replica. Hit ratios are left out, as squid
+// does not report what they were computed from.
+func (t *InfoTotal) Add(info Info) {
+	t.Replicas++
+	t.Clients += info.Clients
+	t.Requests += info.Requests
+	t.StoreEntries += info.StoreEntries
+	t.MemSizeKB += info.MemSizeKB
+	t.SwapSizeKB += info.SwapSizeKB
+}
+
+// fiveMinuteRatio parses "5min: 12.5%, 60min: 10.0%" into 0.125
+func fiveMinuteRatio(value string) float64 {
+	value, _, _ = strings.Cut(value, ",")
+	value = strings.TrimSpace(strings.TrimPrefix(value, "5min:"))
+	return leadingFloat(strings.TrimSuffix(value, "%")) / 100
+}
+
+func leadingFloat(value string) float64 {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	number, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+func leadingInt(value string) int64 {
+	return int64(leadingFloat(value))
+}
+
+// ParseCounters parses a "counters" cache manager page, whose lines are
+// "name = value", e.g. "client_http.requests = 42". Values may be followed by
+// a comment, such as the date after sample_time.
+func ParseCounters(page string) map[string]float64 {
+	counters := map[string]float64{}
+	for _, line := range strings.Split(page, "\n") {
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		number, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		counters[strings.TrimSpace(name)] = number
+	}
+	return counters
+}
+
+// nonAdditiveCounters make no sense summed across replicas
+var nonAdditiveCounters = map[string]bool{"sample_time": true, "wall_time": true, "cpu_usage": true}
+
+// SumCounters adds up the counters of several replicas
+func SumCounters(replicas ...map[string]float64) map[string]float64 {
+	total := map[string]float64{}
+	for _, counters := range replicas {
+		for name, value := range counters {
+			if !nonAdditiveCounters[name] {
+				total[name] += value
+			}
+		}
+	}
+	return total
+}
+
+// Stats are the main client and server counters of a replica since it
+// started
+type Stats struct {
+	Requests       float64 `json:"requests"`
+	Hits           float64 `json:"hits"`
+	Errors         float64 `json:"errors"`
+	KBytesOut      float64 `json:"kbytesOut"`
+	HitKBytesOut   float64 `json:"hitKbytesOut"`
+	ServerRequests float64 `json:"serverRequests"`
+	ServerKBytesIn float64 `json:"serverKbytesIn"`
+}
+
+// StatsFromCounters picks the Stats out of a "counters" page
+func StatsFromCounters(counters map[string]float64) Stats {
+	return Stats{
+		Requests:       counters["client_http.requests"],
+		Hits:           counters["client_http.hits"],
+		Errors:         counters["client_http.errors"],
+		KBytesOut:      counters["client_http.kbytes_out"],
+		HitKBytesOut:   counters["client_http.hit_kbytes_out"],
+		ServerRequests: counters["server.all.requests"],
+		ServerKBytesIn: counters["server.all.kbytes_in"],
+	}
+}
+
+// HitRatio is the share of client requests served from the cache
+func (s Stats) HitRatio() float64 {
+	return ratio(s.Hits, s.Requests)
+}
+
+// ByteHitRatio is the share of the bytes sent to clients that came from the
+// cache
+func (s Stats) ByteHitRatio() float64 {
+	return ratio(s.HitKBytesOut, s.KBytesOut)
+}
+
+// LogStats counts the requests of an access log by cache result
+type LogStats struct {
+	Requests int64 `json:"requests"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Denied   int64 `json:"denied"`
+	Bytes    int64 `json:"bytes"`
+	HitBytes int64 `json:"hitBytes"`
+}
+
+// Observe counts an access log entry
+func (s *LogStats) Observe(entry accesslog.Entry) {
+	s.Requests++
+	switch analytics.Classify(entry.ResultCode) {
+	case analytics.ResultHit:
+		s.Hits++
+		s.Bytes += entry.Bytes
+		s.HitBytes += entry.Bytes
+	case analytics.ResultMiss:
+		s.Misses++
+		s.Bytes += entry.Bytes
+	default:
+		s.Denied++
+	}
+}
+
+// Add adds the counts of other
+func (s *LogStats) Add(other LogStats) {
+	s.Requests += other.Requests
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Denied += other.Denied
+	s.Bytes += other.Bytes
+	s.HitBytes += other.HitBytes
+}
+
+// HitRatio is the share of the requests squid served, denied ones aside,
+// that were cache hits
+func (s LogStats) HitRatio() float64 {
+	return ratio(float64(s.Hits), float64(s.Hits+s.Misses))
+}
+
+// ByteHitRatio is the share of the bytes sent to clients that came from the
+// cache
+func (s LogStats) ByteHitRatio() float64 {
+	return ratio(float64(s.HitBytes), float64(s.Bytes))
+}
+
+// ratio returns NaN when there is nothing to divide, which Percent prints as
+// "-"
+func ratio(part, whole float64) float64 {
+	if whole == 0 {
+		return math.NaN()
+	}
+	return part / whole
+}
+
+// Percent formats a ratio for a table
+func Percent(ratio float64) string {
+	if math.IsNaN(ratio) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+// KB formats a size in kilobytes for a table, e.g. "1.5 GiB"
+func KB(kilobytes float64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for kilobytes >= 1024 && unit < len(units)-1 {
+		kilobytes /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", kilobytes, units[unit])
+}
+
+// Table is the text output of a command
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Append adds a row
+func (t *Table) Append(row ...string) {
+	t.Rows = append(t.Rows, row)
+}
+
+// Write writes the table with aligned columns
+func (t *Table) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.Header, "\t"))
+	for _, row := range t.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// JSONRatio returns nil instead of NaN, which JSON cannot represent
+func JSONRatio(ratio float64) *float64 {
+	if math.IsNaN(ratio) {
+		return nil
+	}
+	return &ratio
+}
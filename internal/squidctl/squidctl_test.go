@@ -0,0 +1,152 @@
+package squidctl
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+)
+
+func TestParseInfo(t *testing.T) {
+	page := "Squid Object Cache: Version 6.10\n" +
+		"Build Info: \n" +
+		"Service Name: squid\n" +
+		"Start Time:\tMon, 06 Jan 2025 10:00:00 GMT\n" +
+		"Current Time:\tMon, 06 Jan 2025 11:00:00 GMT\n" +
+		"Connection information for squid:\n" +
+		"\tNumber of clients accessing cache:\t3\n" +
+		"\tNumber of HTTP requests received:\t1250\n" +
+		"\tRequest Hit Ratios:\t5min: 62.5%, 60min: 40.0%\n" +
+		"\tByte Hit Ratios:\t5min: 81.2%, 60min: 70.1%\n" +
+		"Cache information for squid:\n" +
+		"\tStorage Swap size:\t1048576 KB\n" +
+		"\tStorage Swap capacity:\t10.0% used, 90.0% free\n" +
+		"\tStorage Mem size:\t262144 KB\n" +
+		"Resource usage for squid:\n" +
+		"\tUP Time:\t3600.123 seconds\n" +
+		"\tCPU Time:\t12.500 seconds\n" +
+		"Internal Data Structures:\n" +
+		"\t   420 StoreEntries\n" +
+		"\t    35 StoreEntries with MemObjects\n"
+
+	want := Info{
+		Version:         "6.10",
+		Uptime:          time.Hour,
+		Clients:         3,
+		Requests:        1250,
+		RequestHitRatio: 0.625,
+		ByteHitRatio:    0.812,
+		StoreEntries:    420,
+		MemSizeKB:       262144,
+		SwapSizeKB:      1048576,
+	}
+	if got := ParseInfo(page); got != want {
+		t.Errorf("ParseInfo() = %+v, want %+v", got, want)
+	}
+
+	var total InfoTotal
+	total.Add(want)
+	total.Add(Info{Requests: 250, StoreEntries: 80})
+	wantTotal := InfoTotal{Replicas: 2, Clients: 3, Requests: 1500, StoreEntries: 500, MemSizeKB: 262144, SwapSizeKB: 1048576}
+	if total != wantTotal {
+		t.Errorf("total = %+v, want %+v", total, wantTotal)
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	page := `sample_time = 1736157600.123456 (Mon, 06 Jan 2025 10:00:00 GMT)
+client_http.requests = 1250
+client_http.hits = 800
+client_http.kbytes_out = 4096
+cpu_usage = 1.250000
+not a counter
+`
+	counters := ParseCounters(page)
+	want := map[string]float64{
+		"sample_time":            1736157600.123456,
+		"client_http.requests":   1250,
+		"client_http.hits":       800,
+		"client_http.kbytes_out": 4096,
+		"cpu_usage":              1.25,
+	}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("ParseCounters() = %v, want %v", counters, want)
+	}
+
+	total := SumCounters(counters, map[string]float64{"client_http.requests": 250, "sample_time": 1})
+	if total["client_http.requests"] != 1500 || total["client_http.hits"] != 800 {
+		t.Errorf("SumCounters() = %v", total)
+	}
+	if _, ok := total["sample_time"]; ok {
+		t.Errorf("SumCounters() summed sample_time")
+	}
+
+	stats := StatsFromCounters(total)
+	if stats.Requests != 1500 || stats.HitRatio() != 800.0/1500 || stats.ByteHitRatio() != 0 || !math.IsNaN(Stats{}.HitRatio()) {
+		t.Errorf("stats = %+v, hit ratio %v, byte hit ratio %v", stats, stats.HitRatio(), stats.ByteHitRatio())
+	}
+}
+
+func TestLogStats(t *testing.T) {
+	var stats LogStats
+	for _, entry := range []accesslog.Entry{
+		{ResultCode: "TCP_MEM_HIT", Bytes: 300},
+		{ResultCode: "TCP_REFRESH_UNMODIFIED", Bytes: 100},
+		{ResultCode: "TCP_MISS", Bytes: 600},
+		{ResultCode: "TCP_DENIED", Bytes: 4000},
+	} {
+		stats.Observe(entry)
+	}
+	var total LogStats
+	total.Add(stats)
+	total.Add(stats)
+
+	want := LogStats{Requests: 8, Hits: 4, Misses: 2, Denied: 2, Bytes: 2000, HitBytes: 800}
+	if total != want {
+		t.Errorf("total = %+v, want %+v", total, want)
+	}
+	if got := Percent(total.HitRatio()); got != "66.7%" {
+		t.Errorf("hit ratio = %s", got)
+	}
+	if got := Percent(total.ByteHitRatio()); got != "40.0%" {
+		t.Errorf("byte hit ratio = %s", got)
+	}
+	if got := Percent(LogStats{}.HitRatio()); got != "-" {
+		t.Errorf("hit ratio without requests = %s", got)
+	}
+	if JSONRatio(LogStats{}.HitRatio()) != nil {
+		t.Errorf("JSONRatio() of NaN is not nil")
+	}
+}
+
+func TestKB(t *testing.T) {
+	for kilobytes, want := range map[float64]string{
+		0:           "0.0 KiB",
+		512:         "512.0 KiB",
+		1536:        "1.5 MiB",
+		262144:      "256.0 MiB",
+		1048576 * 3: "3.0 GiB",
+	} {
+		if got := KB(kilobytes); got != want {
+			t.Errorf("KB(%v) = %q, want %q", kilobytes, got, want)
+		}
+	}
+}
+
+func TestTableWrite(t *testing.T) {
+	table := Table{Header: []string{"REPLICA", "REQUESTS"}}
+	table.Append("squid-abc", "1")
+	table.Append("TOTAL", "1000")
+
+	var out strings.Builder
+	if err := table.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "REPLICA    REQUESTS\nsquid-abc  1\nTOTAL      1000\n"
+	if out.String() != want {
+		t.Errorf("Write() = %q, want %q", out.String(), want)
+	}
+}
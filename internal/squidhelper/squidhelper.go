@@ -0,0 +1,58 @@
+// Package squidhelper implements the line protocol squid speaks with helper
+// programs such as store_id_program and external_acl_type helpers.
+package squidhelper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// isChannelID reports whether a token is the channel-ID squid prefixes
+// requests with when the helper is configured with concurrency > 0
+func isChannelID(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Split separates the optional channel-ID from the fields of a request line.
+// The channel is returned with a trailing space, ready to prefix the reply.
+func Split(line string) (channel string, fields []string) {
+	fields = strings.Fields(line)
+	if len(fields) > 0 && isChannelID(fields[0]) {
+		return fields[0] + " ", fields[1:]
+	}
+	return "", fields
+}
+
+// Serve answers every request line read from in with reply, until in is
+// closed
+func Serve(in io.Reader, out io.Writer, reply func(line string) string) error {
+	scanner := bufio.NewScanner(in)
+	// URLs of signed CDN redirects easily exceed the default token size
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	writer := bufio.NewWriter(out)
+
+	for scanner.Scan() {
+		if _, err := fmt.Fprintln(writer, reply(scanner.Text())); err != nil {
+			return fmt.Errorf("failed to write reply: %w", err)
+		}
+		// squid waits for each reply, so it must not sit in the buffer
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("failed to write reply: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return nil
+}
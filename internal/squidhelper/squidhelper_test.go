@@ -0,0 +1,69 @@
+package squidhelper
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		channel string
+		fields  []string
+	}{
+		{line: "0 http://example.com/a -", channel: "0 ", fields: []string{"http://example.com/a", "-"}},
+		{line: "17 http://example.com/a", channel: "17 ", fields: []string{"http://example.com/a"}},
+		// Without concurrency, squid sends no channel-ID
+		{line: "http://example.com/a -", channel: "", fields: []string{"http://example.com/a", "-"}},
+		{line: "12a http://example.com/a", channel: "", fields: []string{"12a", "http://example.com/a"}},
+		// A channel-ID alone, e.g. for an empty request
+		{line: "3", channel: "3 ", fields: []string{}},
+		{line: "", channel: "", fields: []string{}},
+	} {
+		channel, fields := Split(test.line)
+		if channel != test.channel || !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("Split(%q) = %q, %q, want %q, %q", test.line, channel, fields, test.channel, test.fields)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	// Replies with the request's channel-ID and its first field upper-cased
+	reply := func(line string) string {
+		channel, fields := Split(line)
+		if len(fields) == 0 {
+			return channel + "ERR"
+		}
+		return channel + "OK store-id=" + strings.ToUpper(fields[0])
+	}
+
+	long := "http://cdn.example.com/blob?sig=" + strings.Repeat("a", 100*1024)
+	for _, test := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "channel-IDs", in: "0 http://a -\n1 http://b -\n", out: "0 OK store-id=HTTP://A\n1 OK store-id=HTTP://B\n"},
+		{name: "no channel-ID", in: "http://a -\n", out: "OK store-id=HTTP://A\n"},
+		{name: "last line without newline", in: "0 http://a\n1 http://b", out: "0 OK store-id=HTTP://A\n1 OK store-id=HTTP://B\n"},
+		{name: "empty request", in: "4\n", out: "4 ERR\n"},
+		{name: "no requests", in: "", out: ""},
+		{name: "line beyond the default buffer", in: "0 " + long + "\n", out: "0 OK store-id=" + strings.ToUpper(long) + "\n"},
+	} {
+		var out bytes.Buffer
+		if err := Serve(strings.NewReader(test.in), &out, reply); err != nil {
+			t.Errorf("%s: Serve() = %v", test.name, err)
+			continue
+		}
+		if out.String() != test.out {
+			t.Errorf("%s: replied %q, want %q", test.name, out.String(), test.out)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(strings.Repeat("a", 2*1024*1024)), &out, reply); err == nil {
+		t.Error("Serve() should fail on a line beyond its buffer")
+	}
+}
@@ -0,0 +1,28 @@
+package storeid
+
+import (
+	"io"
+
+	"github.com/konflux-ci/caching/internal/squidhelper"
+)
+
+// Reply computes the helper response to a single request line as sent by
+// squid ("[channel-ID] URL [extras]"), without the trailing newline
+func (r *Rewriter) Reply(line string) string {
+	channel, fields := squidhelper.Split(line)
+	if len(fields) == 0 {
+		return channel + "BH message=\"empty request\""
+	}
+
+	storeID, ok := r.Rewrite(fields[0])
+	if !ok {
+		// ERR tells squid to keep using the request URL as the store ID
+		return channel + "ERR"
+	}
+	return channel + "OK store-id=" + storeID
+}
+
+// Serve answers store_id_program requests read from in until it is closed
+func (r *Rewriter) Serve(in io.Reader, out io.Writer) error {
+	return squidhelper.Serve(in, out, r.Reply)
+}
@@ -0,0 +1,95 @@
+// Package storeid normalizes request URLs into stable Squid store IDs, so
+// that the same content fetched through different URLs (signed CDN redirects,
+// registry blobs pulled from different repositories) is cached only once.
+package storeid
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Rule maps the URLs matching Pattern to the store ID built from StoreID,
+// which may reference the pattern's capture groups ($1, ${name})
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	StoreID string `json:"storeId"`
+
+	re *regexp.Regexp
+}
+
+// Config is the rules file read by the store-id helper
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rewriter applies an ordered list of rules to URLs
+type Rewriter struct {
+	rules []Rule
+}
+
+// NewRewriter compiles the given rules, in order of precedence
+func NewRewriter(rules []Rule) (*Rewriter, error) {
+	compiled := make([]Rule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %q: pattern is required", rule.Name)
+		}
+		if rule.StoreID == "" {
+			return nil, fmt.Errorf("rule %q: storeId is required", rule.Name)
+		}
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: invalid pattern: %w", rule.Name, err)
+		}
+		rule.re = re
+		compiled = append(compiled, rule)
+	}
+
+	return &Rewriter{rules: compiled}, nil
+}
+
+// LoadRewriter reads a YAML or JSON rules file and compiles its rules
+func LoadRewriter(path string) (*Rewriter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	rewriter, err := ParseRewriter(data)
+	if err != nil {
+		return nil, fmt.Errorf("rules file %s: %w", path, err)
+	}
+	return rewriter, nil
+}
+
+// ParseRewriter compiles the rules of a YAML or JSON rules file's content,
+// e.g. read from the chart's ConfigMap
+func ParseRewriter(data []byte) (*Rewriter, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	return NewRewriter(config.Rules)
+}
+
+// Rewrite returns the store ID for url from the first matching rule, and
+// whether any rule matched
+func (r *Rewriter) Rewrite(url string) (string, bool) {
+	for _, rule := range r.rules {
+		match := rule.re.FindStringSubmatchIndex(url)
+		if match == nil {
+			continue
+		}
+		return string(rule.re.ExpandString(nil, rule.StoreID, url, match)), true
+	}
+	return "", false
+}
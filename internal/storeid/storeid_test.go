@@ -0,0 +1,211 @@
+package storeid
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const digest = "sha256:0f2ec1f4a5e1e29b7fa1e5a0f1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2"
+
+// chartRules returns the default rules shipped in the Helm chart values
+func chartRules(t *testing.T) []Rule {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "squid", "values.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read chart values: %v", err)
+	}
+
+	var values struct {
+		StoreID struct {
+			Rules []Rule `json:"rules"`
+		} `json:"storeId"`
+	}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		t.Fatalf("failed to parse chart values: %v", err)
+	}
+	return values.StoreID.Rules
+}
+
+func TestChartDefaultRules(t *testing.T) {
+	rewriter, err := NewRewriter(chartRules(t))
+	if err != nil {
+		t.Fatalf("chart default rules do not compile: %v", err)
+	}
+
+	hex := strings.TrimPrefix(digest, "sha256:")
+	tests := []struct {
+		name    string
+		url     string
+		storeID string
+		matched bool
+	}{
+		{
+			name:    "blob pulled through one repository",
+			url:     "https://quay.io/v2/konflux-ci/buildah/blobs/" + digest,
+			storeID: "http://blobs.store-id.squid.internal/" + digest,
+			matched: true,
+		},
+		{
+			name:    "same blob through another registry and repository",
+			url:     "https://registry.example.com/v2/mirror/org/buildah/blobs/" + digest + "?ns=quay.io",
+			storeID: "http://blobs.store-id.squid.internal/" + digest,
+			matched: true,
+		},
+		{
+			name:    "CDN redirect target for the same blob",
+			url:     "https://cdn01.quay.io/quayio-production-s3/sha256/0f/" + hex + "?Expires=1700000000&Signature=abc&Key-Pair-Id=K1",
+			storeID: "http://blobs.store-id.squid.internal/" + digest,
+			matched: true,
+		},
+		{
+			name:    "query-signed S3 object",
+			url:     "https://bucket.s3.us-east-1.amazonaws.com/path/to/object.tar.gz?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Expires=600&X-Amz-Signature=deadbeef",
+			storeID: "https://bucket.s3.us-east-1.amazonaws.com/path/to/object.tar.gz",
+			matched: true,
+		},
+		{
+			name:    "signed CloudFront object",
+			url:     "https://d111111abcdef8.cloudfront.net/images/image.jpg?Expires=1700000000&Signature=abc&Key-Pair-Id=K1",
+			storeID: "https://d111111abcdef8.cloudfront.net/images/image.jpg",
+			matched: true,
+		},
+		{
+			name: "manifest is not content-addressed by URL",
+			url:  "https://quay.io/v2/konflux-ci/buildah/manifests/latest",
+		},
+		{
+			name: "unsigned S3 object keeps its URL",
+			url:  "https://bucket.s3.amazonaws.com/path/to/object.tar.gz?versionId=3",
+		},
+		{
+			name: "truncated digest",
+			url:  "https://quay.io/v2/konflux-ci/buildah/blobs/sha256:0f2ec1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeID, matched := rewriter.Rewrite(tt.url)
+			if matched != tt.matched {
+				t.Fatalf("Rewrite(%q) matched = %v, want %v", tt.url, matched, tt.matched)
+			}
+			if storeID != tt.storeID {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.url, storeID, tt.storeID)
+			}
+		})
+	}
+}
+
+func TestRewriteFirstMatchWins(t *testing.T) {
+	rewriter, err := NewRewriter([]Rule{
+		{Name: "specific", Pattern: `^http://example\.com/(?P<file>[^?]+)\?token=`, StoreID: "http://pinned/${file}"},
+		{Name: "generic", Pattern: `^http://example\.com/([^?]+)`, StoreID: "http://generic/$1"},
+	})
+	if err != nil {
+		t.Fatalf("NewRewriter() error = %v", err)
+	}
+
+	if got, _ := rewriter.Rewrite("http://example.com/a.tar?token=1"); got != "http://pinned/a.tar" {
+		t.Errorf("Rewrite() = %q, want the first matching rule with a named group", got)
+	}
+	if got, _ := rewriter.Rewrite("http://example.com/a.tar"); got != "http://generic/a.tar" {
+		t.Errorf("Rewrite() = %q, want the second rule", got)
+	}
+}
+
+func TestNewRewriterRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{name: "missing pattern", rule: Rule{Name: "a", StoreID: "$1"}, want: "pattern is required"},
+		{name: "missing store ID", rule: Rule{Name: "b", Pattern: "x"}, want: "storeId is required"},
+		{name: "invalid pattern", rule: Rule{Name: "c", Pattern: "(", StoreID: "$1"}, want: "invalid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRewriter([]Rule{tt.rule})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewRewriter() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRewriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := "rules:\n- name: strip-query\n  pattern: '^(http://[^?]+)\\?'\n  storeId: '$1'\n"
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rewriter, err := LoadRewriter(path)
+	if err != nil {
+		t.Fatalf("LoadRewriter() error = %v", err)
+	}
+	if got, _ := rewriter.Rewrite("http://example.com/a?b=c"); got != "http://example.com/a" {
+		t.Errorf("Rewrite() = %q, want %q", got, "http://example.com/a")
+	}
+
+	if err := os.WriteFile(path, []byte("rules:\n- name: typo\n  patern: x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRewriter(path); err == nil {
+		t.Error("LoadRewriter() should reject unknown fields")
+	}
+}
+
+func TestParseRewriter(t *testing.T) {
+	rewriter, err := ParseRewriter([]byte(`{"rules": [{"pattern": "^(http://[^?]+)\\?", "storeId": "$1"}]}`))
+	if err != nil {
+		t.Fatalf("ParseRewriter() error = %v", err)
+	}
+	if got, _ := rewriter.Rewrite("http://example.com/a?b=c"); got != "http://example.com/a" {
+		t.Errorf("Rewrite() = %q, want %q", got, "http://example.com/a")
+	}
+
+	if _, err := ParseRewriter([]byte("rules:\n- pattern: '('\n  storeId: x\n")); err == nil {
+		t.Error("ParseRewriter() should reject invalid patterns")
+	}
+}
+
+func TestServe(t *testing.T) {
+	rewriter, err := NewRewriter([]Rule{
+		{Pattern: `^(http://cdn\.example\.com/[^?]+)\?`, StoreID: "$1"},
+	})
+	if err != nil {
+		t.Fatalf("NewRewriter() error = %v", err)
+	}
+
+	in := strings.Join([]string{
+		// concurrent helpers are sent a channel-ID first
+		"0 http://cdn.example.com/blob?sig=1 10.0.0.1/- - GET",
+		"1 http://other.example.com/blob?sig=1 10.0.0.1/- - GET",
+		// non-concurrent helpers are sent the URL first
+		"http://cdn.example.com/blob?sig=2 10.0.0.1/- - GET",
+		"7",
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	if err := rewriter.Serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"0 OK store-id=http://cdn.example.com/blob",
+		"1 ERR",
+		"OK store-id=http://cdn.example.com/blob",
+		`7 BH message="empty request"`,
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Errorf("Serve() wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -3,12 +3,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/konflux-ci/caching/internal"
+	"github.com/konflux-ci/caching/tests/bench"
 	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/sh"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // Kind manages kind cluster operations
@@ -23,6 +31,9 @@ type SquidHelm mg.Namespace
 // Test manages test execution operations
 type Test mg.Namespace
 
+// Cache manages the contents of the deployed squid caches
+type Cache mg.Namespace
+
 const (
 	clusterName = "caching"
 	// SquidImageTag is the tag used for the squid container image
@@ -37,6 +48,10 @@ const (
 	squidExporterImageTag = "localhost/konflux-ci/squid-exporter:latest"
 	// SquidExporterContainerfile is the path to the Containerfile for squid-exporter
 	squidExporterContainerfile = "squid-exporter/Containerfile"
+	// E2EValuesFile is the Helm values overlay enabling the features covered by the e2e tests
+	e2eValuesFile = "tests/e2e/values.yaml"
+	// EnvtestKubernetesVersion is the version of the API server the controller tests run against
+	envtestKubernetesVersion = "1.33.x"
 )
 
 // Default target - shows available targets
@@ -347,19 +362,26 @@ func (SquidHelm) Up() error {
 	if exists {
 		// Upgrade existing release
 		fmt.Printf("⚓ Upgrading existing squid helm release and waiting for readiness...\n")
-		err = sh.Run("helm", "upgrade", "squid", "./squid", "--wait", "--timeout=120s")
+		err = sh.Run("helm", "upgrade", "squid", "./squid", "--values", e2eValuesFile, "--wait", "--timeout=120s")
 		if err != nil {
 			return fmt.Errorf("failed to upgrade helm chart: %w", err)
 		}
 	} else {
 		// Install new release
 		fmt.Printf("⚓ Installing squid helm chart and waiting for readiness...\n")
-		err = sh.Run("helm", "install", "squid", "./squid", "--wait", "--timeout=120s")
+		err = sh.Run("helm", "install", "squid", "./squid", "--values", e2eValuesFile, "--wait", "--timeout=120s")
 		if err != nil {
 			return fmt.Errorf("failed to install helm chart: %w", err)
 		}
 	}
 
+	// helm --wait returns once the single initial replica of an autoscaled
+	// deployment is ready
+	err = internal.WaitForAutoscaledReplicas("proxy", "squid")
+	if err != nil {
+		return fmt.Errorf("failed to wait for autoscaled replicas: %w", err)
+	}
+
 	// Show comprehensive deployment status
 	fmt.Printf("🔍 Verifying deployment status...\n")
 	err = (SquidHelm{}).Status()
@@ -456,6 +478,22 @@ func (SquidHelm) Status() error {
 	return nil
 }
 
+// Cache:Purge evicts a URL from every squid replica through the purge API
+func (Cache) Purge(url string) error {
+	fmt.Printf("🧹 Purging '%s' from all squid replicas...\n", url)
+
+	// The purge-api client in the pod authenticates with the token mounted
+	// from its Secret and the API fans the request out to every replica
+	err := sh.RunV("kubectl", "exec", "-n", "proxy", "deployment/squid", "-c", "purge-api", "--",
+		"/usr/local/bin/purge-api", "purge", "-url", url)
+	if err != nil {
+		return fmt.Errorf("failed to purge '%s' (is purgeApi.enabled set?): %w", url, err)
+	}
+
+	fmt.Printf("✅ '%s' purged, the next request will be a cache MISS\n", url)
+	return nil
+}
+
 // All runs the complete automation workflow
 func All() error {
 	fmt.Println("🎯 Running complete automation workflow...")
@@ -532,6 +570,189 @@ func (Test) Cluster() error {
 	fmt.Println("Tests run as if inside the cluster using mirrord")
 	fmt.Println("This provides the most realistic testing environment")
 
+	// The graceful shutdown spec asks for a helm upgrade while a download runs
+	dir, err := os.MkdirTemp("", "squid-e2e-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	request := filepath.Join(dir, "upgrade-request")
+	stop := make(chan struct{})
+	defer close(stop)
+	go answerUpgradeRequest(request, stop)
+
+	return runE2EWithMirrord(map[string]string{"E2E_UPGRADE_REQUEST": request})
+}
+
+// answerUpgradeRequest waits for a spec to create request, then runs a helm upgrade
+// changing the pod template, and writes its error, if any, to request.done. The spec
+// waits for the rollout itself, so the upgrade doesn't wait for it.
+func answerUpgradeRequest(request string, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if _, err := os.Stat(request); err != nil {
+			continue
+		}
+
+		fmt.Println("⚓ Upgrading the squid helm release for the graceful shutdown spec...")
+		var message string
+		err := sh.Run("helm", "upgrade", "squid", "./squid", "--values", e2eValuesFile,
+			"--set-string", "podAnnotations.e2e-upgraded-at="+time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			message = err.Error()
+		}
+		if err := os.WriteFile(request+".done", []byte(message), 0o644); err != nil {
+			fmt.Printf("❌ Failed to answer the upgrade request: %v\n", err)
+		}
+		return
+	}
+}
+
+// Test:Replay replays the access log in REPLAY_TRACE through the deployed proxy and
+// compares the cache outcome of every URL with REPLAY_BASELINE, if set. The report is
+// written to REPLAY_REPORT (replay-report.json by default) to serve as the next
+// baseline. REPLAY_SPEED speeds the replay up, e.g. 60 replays an hour in a minute.
+func (Test) Replay() error {
+	mg.Deps(SquidHelm{}.Up)
+
+	trace := os.Getenv("REPLAY_TRACE")
+	if trace == "" {
+		return fmt.Errorf("REPLAY_TRACE must name an access log to replay")
+	}
+	// The other REPLAY_ variables reach the spec from the environment
+	env := map[string]string{"REPLAY_REPORT": os.Getenv("REPLAY_REPORT")}
+	if env["REPLAY_REPORT"] == "" {
+		env["REPLAY_REPORT"] = "replay-report.json"
+	}
+
+	fmt.Printf("📼 Replaying %s through the deployed proxy...\n", trace)
+	if err := runE2EWithMirrord(env, "-ginkgo.focus", "Traffic Replay", "-ginkgo.timeout", "24h"); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Report written to %s\n", env["REPLAY_REPORT"])
+	return nil
+}
+
+// Test:Bench measures what one squid replica sustains: a load generator running in the
+// cluster drives request mixes through it and writes the requests per second, latency
+// percentiles, error rate and origin offload of every mix to BENCH_REPORT
+// (bench-report.json by default). With BENCH_BASELINE, a report measured on the same
+// cluster, it fails if a mix regressed from that report. BENCH_MIXES, BENCH_DURATION, BENCH_WARMUP and BENCH_CONCURRENCY override
+// the load generator's defaults.
+func (Test) Bench() error {
+	mg.Deps(SquidHelm{}.Up)
+
+	// Measure a single replica, whatever the service would balance the load to
+	pod, err := readySquidPodIP()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-proxy", pod + ":3128"}
+	for flag, name := range map[string]string{
+		"-mixes":       "BENCH_MIXES",
+		"-duration":    "BENCH_DURATION",
+		"-warmup":      "BENCH_WARMUP",
+		"-concurrency": "BENCH_CONCURRENCY",
+	} {
+		if value := os.Getenv(name); value != "" {
+			args = append(args, flag+"="+value)
+		}
+	}
+
+	fmt.Printf("🏋️ Benchmarking the squid replica at %s from the cluster...\n", pod)
+	err = sh.Run("kubectl", "delete", "pod", "squid-bench", "-n", "proxy", "--ignore-not-found")
+	if err != nil {
+		return fmt.Errorf("failed to delete the previous benchmark pod: %w", err)
+	}
+	var output bytes.Buffer
+	_, err = sh.Exec(nil, io.MultiWriter(os.Stdout, &output), os.Stderr,
+		"kubectl", append([]string{"run", "squid-bench", "-n", "proxy", "--image=" + testImageTag,
+			"--image-pull-policy=IfNotPresent", "--restart=Never", "--rm", "-i", "--quiet",
+			"--command", "--", "/app/loadgen"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("benchmark failed: %w", err)
+	}
+
+	// The load generator prints its report on its last line
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	var report bench.Report
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &report); err != nil {
+		return fmt.Errorf("failed to parse the benchmark report: %w", err)
+	}
+	reportPath := os.Getenv("BENCH_REPORT")
+	if reportPath == "" {
+		reportPath = "bench-report.json"
+	}
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := bench.WriteReport(file, &report); err != nil {
+		return fmt.Errorf("failed to write %s: %w", reportPath, err)
+	}
+	fmt.Printf("📊 Report written to %s\n", reportPath)
+
+	baselinePath := os.Getenv("BENCH_BASELINE")
+	if baselinePath == "" {
+		fmt.Println("ℹ️ No BENCH_BASELINE given, not comparing")
+		return nil
+	}
+	baselineFile, err := os.Open(baselinePath)
+	if err != nil {
+		return err
+	}
+	defer baselineFile.Close()
+	baseline, err := bench.ReadReport(baselineFile)
+	if err != nil {
+		return err
+	}
+	regressions := bench.Compare(baseline, &report, bench.DefaultThresholds)
+	if len(regressions) > 0 {
+		for _, regression := range regressions {
+			fmt.Printf("❌ %s\n", regression)
+		}
+		return fmt.Errorf("%d metrics regressed from %s", len(regressions), baselinePath)
+	}
+	fmt.Printf("✅ No regression from %s\n", baselinePath)
+	return nil
+}
+
+// readySquidPodIP returns the IP of a squid pod that is ready and not terminating, so
+// that a rollout does not end the benchmark midway
+func readySquidPodIP() (string, error) {
+	output, err := sh.Output("kubectl", "get", "pods", "-n", "proxy",
+		"-l", "app.kubernetes.io/name=squid,app.kubernetes.io/component=squid-proxy", "-o", "json")
+	if err != nil {
+		return "", fmt.Errorf("failed to list the squid pods: %w", err)
+	}
+	var pods corev1.PodList
+	if err := json.Unmarshal([]byte(output), &pods); err != nil {
+		return "", fmt.Errorf("failed to parse the squid pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return pod.Status.PodIP, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no ready squid pod found")
+}
+
+// runE2EWithMirrord builds the e2e suite and runs it with mirrord, with the extra
+// environment variables and Ginkgo flags
+func runE2EWithMirrord(env map[string]string, args ...string) error {
 	// Check if mirrord is available
 	err := sh.Run("which", "mirrord")
 	if err != nil {
@@ -556,8 +777,29 @@ func (Test) Cluster() error {
 
 	// Run tests with mirrord using ginkgo binary
 	fmt.Println("🚀 Running tests with mirrord connection stealing...")
+	runEnv := map[string]string{"CGO_ENABLED": "1"}
+	for name, value := range env {
+		runEnv[name] = value
+	}
+	command := append([]string{"exec", "--config-file", ".mirrord/mirrord.json", "--",
+		"./tests/e2e/e2e.test", "-ginkgo.v"}, args...)
+	return sh.RunWithV(runEnv, "mirrord", command...)
+}
+
+// Test:Controller runs the CachePolicy controller tests against a local API server (envtest)
+func (Test) Controller() error {
+	fmt.Println("🧪 Running controller tests with envtest...")
+
+	// setup-envtest downloads kube-apiserver and etcd once and prints the
+	// directory holding them
+	fmt.Printf("📦 Ensuring envtest binaries for Kubernetes %s...\n", envtestKubernetesVersion)
+	assets, err := sh.Output("go", "run", "sigs.k8s.io/controller-runtime/tools/setup-envtest@release-0.21",
+		"use", envtestKubernetesVersion, "-p", "path")
+	if err != nil {
+		return fmt.Errorf("failed to set up envtest binaries: %w", err)
+	}
+
 	return sh.RunWithV(map[string]string{
-		"CGO_ENABLED": "1",
-	}, "mirrord", "exec", "--config-file", ".mirrord/mirrord.json", "--",
-		"./tests/e2e/e2e.test", "-ginkgo.v")
+		"KUBEBUILDER_ASSETS": assets,
+	}, "go", "test", "-v", "./internal/cachepolicy/")
 }
@@ -38,6 +38,62 @@ app.kubernetes.io/instance: {{ .Release.Name }}
 app.kubernetes.io/component: squid-proxy
 {{- end }}
 
+{{/*
+Selector labels of the proxy injection webhook, which has an app name of its
+own to stay out of the squid Service's selector
+*/}}
+{{- define "squid.proxyInjectorSelectorLabels" -}}
+app.kubernetes.io/name: proxy-injector
+app.kubernetes.io/instance: {{ .Release.Name }}
+app.kubernetes.io/component: proxy-injector
+{{- end }}
+
+{{/*
+Labels of the proxy injection webhook
+*/}}
+{{- define "squid.proxyInjectorLabels" -}}
+helm.sh/chart: {{ include "squid.chart" . }}
+{{ include "squid.proxyInjectorSelectorLabels" . }}
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
+{{/*
+Selector labels of the CachePolicy controller, which has an app name of its
+own to stay out of the squid Service's selector
+*/}}
+{{- define "squid.cachePolicyControllerSelectorLabels" -}}
+app.kubernetes.io/name: cachepolicy-controller
+app.kubernetes.io/instance: {{ .Release.Name }}
+app.kubernetes.io/component: cachepolicy-controller
+{{- end }}
+
+{{/*
+Labels of the CachePolicy controller
+*/}}
+{{- define "squid.cachePolicyControllerLabels" -}}
+helm.sh/chart: {{ include "squid.chart" . }}
+{{ include "squid.cachePolicyControllerSelectorLabels" . }}
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
+{{/*
+Labels of the forward proxy standing in for a parent proxy in tests, with an
+app name of its own to stay out of the squid Service's selector
+*/}}
+{{- define "squid.testParentLabels" -}}
+helm.sh/chart: {{ include "squid.chart" . }}
+app.kubernetes.io/name: test-parent-proxy
+app.kubernetes.io/instance: {{ .Release.Name }}
+app.kubernetes.io/component: test-parent-proxy
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
@@ -48,3 +104,181 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Options appended to every generated cache_peer sibling entry
+*/}}
+{{- define "squid.peerOptions" -}}
+{{- if eq .Values.cachePeers.protocol "htcp" -}}
+{{- trim (printf "htcp %s" .Values.cachePeers.options) }}
+{{- else if eq .Values.cachePeers.protocol "icp" -}}
+{{- .Values.cachePeers.options }}
+{{- else -}}
+{{- fail (printf "cachePeers.protocol must be \"icp\" or \"htcp\", got %q" .Values.cachePeers.protocol) }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the Secret holding the purge API bearer token
+*/}}
+{{- define "squid.purgeApiSecretName" -}}
+{{- default (printf "%s-purge-api" (include "squid.fullname" .)) .Values.purgeApi.existingSecret }}
+{{- end }}
+
+{{/*
+How long the preStop hook waits for client requests to complete: the grace
+period left after the drain delay and squid's shutdown_lifetime, with a margin
+*/}}
+{{- define "squid.drainTimeout" -}}
+{{- $shutdown := .Values.gracefulShutdown }}
+{{- $timeout := sub (sub (sub $shutdown.terminationGracePeriodSeconds $shutdown.drainDelaySeconds) $shutdown.shutdownLifetimeSeconds) 5 }}
+{{- if lt $timeout 1 }}
+{{- fail "gracefulShutdown.terminationGracePeriodSeconds must exceed drainDelaySeconds plus shutdownLifetimeSeconds by more than 5 seconds" }}
+{{- end }}
+{{- $timeout }}
+{{- end }}
+
+{{/*
+squid-health arguments shared by the probes of the squid container
+*/}}
+{{- define "squid.healthCheckArgs" -}}
+{{- $check := .Values.healthCheck -}}
+- -max-fd-usage={{ $check.maxFileDescriptorUsage }}
+- -max-cache-dir-usage={{ $check.maxCacheDirUsage }}
+{{- if not $check.syntheticFetch }}
+- -synthetic-url=
+{{- end }}
+{{- end }}
+
+{{/*
+The squid-health -timeout of a probe: its timeoutSeconds (1 by default, as in
+Kubernetes) less half a second, so that the whole check ends before the
+kubelet gives up on it
+*/}}
+{{- define "squid.probeTimeout" -}}
+{{ sub (mul (.timeoutSeconds | default 1) 1000) 500 }}ms
+{{- end }}
+
+{{/*
+Whether squid requires cache manager credentials. Renders "true" or nothing.
+*/}}
+{{- define "squid.cachemgrAuth" -}}
+{{- if or .Values.squidExporter.existingSecret .Values.squidExporter.squidPassword }}true{{ end }}
+{{- end }}
+
+{{/*
+Name of the Secret holding the cache manager login and password
+*/}}
+{{- define "squid.cachemgrSecretName" -}}
+{{- default (printf "%s-cachemgr" (include "squid.fullname" .)) .Values.squidExporter.existingSecret }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap holding the prewarm URL manifest
+*/}}
+{{- define "squid.prewarmConfigMapName" -}}
+{{- default (printf "%s-prewarm" (include "squid.fullname" .)) .Values.prewarm.existingConfigMap }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap the CachePolicy controller renders the tenants' rules to
+*/}}
+{{- define "squid.cachePoliciesConfigMapName" -}}
+{{- printf "%s-cache-policies" (include "squid.fullname" .) }}
+{{- end }}
+
+{{/*
+Name of the Secret holding the proxy authentication htpasswd file
+*/}}
+{{- define "squid.proxyAuthSecretName" -}}
+{{- default (printf "%s-proxy-auth" (include "squid.fullname" .)) .Values.proxyAuth.existingSecret }}
+{{- end }}
+
+{{/*
+Whether any tenant is identified by namespace or CachePolicies are enabled,
+which needs the pod namespace helper. Renders "true" or nothing.
+*/}}
+{{- define "squid.namespaceLookup" -}}
+{{- if .Values.cachePolicies.enabled }}true
+{{- else }}
+{{- range .Values.tenancy.tenants }}
+{{- if .namespaces }}true{{ break }}{{ end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Validate the tenant list
+*/}}
+{{- define "squid.validateTenants" -}}
+{{- range .Values.tenancy.tenants }}
+{{- if not (regexMatch "^[a-z0-9]([a-z0-9-]*[a-z0-9])?$" (toString .name)) }}
+{{- fail (printf "tenancy.tenants: invalid tenant name %q" (toString .name)) }}
+{{- end }}
+{{- if not (or .namespaces .users) }}
+{{- fail (printf "tenancy.tenants: tenant %q needs namespaces or users" .name) }}
+{{- end }}
+{{- if and .users (not $.Values.proxyAuth.enabled) }}
+{{- fail (printf "tenancy.tenants: tenant %q has users but proxyAuth is disabled" .name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+cache_peer entries of the parent proxies, in order of preference. Parents with
+credentials get a login=@<name> placeholder, which container-entrypoint.sh
+replaces with the credentials of their mounted Secret.
+*/}}
+{{- define "squid.parentsConf" -}}
+{{- $names := dict }}
+{{- range .Values.parentProxies.proxies }}
+{{- $name := toString .name }}
+{{- if not (regexMatch "^[a-z0-9]([a-z0-9-]{0,54}[a-z0-9])?$" $name) }}
+{{- fail (printf "parentProxies.proxies: invalid name %q, must be a DNS label of at most 56 characters" $name) }}
+{{- end }}
+{{- if hasKey $names $name }}
+{{- fail (printf "parentProxies.proxies: duplicate name %q" $name) }}
+{{- end }}
+{{- $_ := set $names $name true }}
+{{- if not (and .host .port) }}
+{{- fail (printf "parentProxies.proxies: parent %q needs a host and a port" $name) }}
+{{- end }}
+cache_peer {{ .host }} parent {{ .port }} 0 no-query no-digest name={{ $name }}
+{{- if .existingSecret }} login=@{{ $name }}{{ end }}
+{{- with .options }} {{ . }}{{ end }}
+{{- if .domains }}
+acl parent_{{ $name }}_domains dstdomain {{ join " " .domains }}
+cache_peer_access {{ $name }} allow parent_{{ $name }}_domains
+cache_peer_access {{ $name }} deny all
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+JSON logformat from accessLog.json. String values get squid's quoted-string
+modifier, which escapes quotes, backslashes and line breaks the JSON way.
+*/}}
+{{- define "squid.jsonLogformat" -}}
+{{- $fields := list }}
+{{- range concat .Values.accessLog.json.fields .Values.accessLog.json.extraFields }}
+{{- if not (regexMatch "^[A-Za-z_][A-Za-z0-9_]*$" (toString .name)) }}
+{{- fail (printf "accessLog.json: invalid field name %q" (toString .name)) }}
+{{- end }}
+{{- if eq (.type | default "string") "number" }}
+{{- $fields = append $fields (printf "\"%s\":%s" .name .code) }}
+{{- else if hasPrefix "%" .code }}
+{{- $fields = append $fields (printf "\"%s\":\"%%\"%s\"" .name (trimPrefix "%" .code)) }}
+{{- else }}
+{{- fail (printf "accessLog.json: field %q: string codes must be a single %%-code" .name) }}
+{{- end }}
+{{- end }}
+{{- printf "{%s}" (join "," $fields) }}
+{{- end }}
+
+{{/*
+Machine-readable body of an egress policy error page. Squid substitutes the
+%-codes, HTML-escaping their values, which keeps the JSON well-formed.
+*/}}
+{{- define "squid.egressErrorPage" -}}
+{"error": "egress_denied", "reason": {{ . | quote }}, "url": "%U", "method": "%M", "host": "%H", "client": "%i", "proxy": "%h", "time": "%T"}
+{{- end }}
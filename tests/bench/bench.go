@@ -0,0 +1,343 @@
+// Package bench measures what a squid replica sustains: it drives request
+// mixes through the proxy against a scripted test origin, reports the
+// throughput, latency, error rate and origin offload of every mix and
+// compares them with a baseline.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+// Mix is a kind of traffic: requests for objects of one size picked at random
+type Mix struct {
+	Name string
+	// Objects is the number of distinct objects requested, 0 for a new
+	// object with every request
+	Objects int
+	Size    int64
+}
+
+// Mixes are the predefined mixes. squid only keeps objects of up to
+// maximum_object_size_in_memory (512 KB by default) in its memory cache, so
+// without a cache_dir the large objects are streamed from the origin.
+var Mixes = []Mix{
+	{Name: "hit-heavy", Objects: 32, Size: 16 << 10},
+	{Name: "miss-heavy", Objects: 0, Size: 16 << 10},
+	{Name: "large-objects", Objects: 8, Size: 4 << 20},
+	{Name: "small-objects", Objects: 10000, Size: 1 << 10},
+}
+
+// LookupMix returns the predefined mix of a name
+func LookupMix(name string) (Mix, bool) {
+	for _, mix := range Mixes {
+		if mix.Name == name {
+			return mix, true
+		}
+	}
+	return Mix{}, false
+}
+
+// Options configure a run
+type Options struct {
+	// Duration is how long the requests are measured, 30s by default
+	Duration time.Duration
+	// Warmup is how long requests are sent before being measured, so that
+	// the cache holds the objects of the mix. 0 disables it.
+	Warmup time.Duration
+	// Concurrency is the number of requests kept in flight, 16 by default
+	Concurrency int
+}
+
+// Result is the measure of a mix
+type Result struct {
+	Mix         string  `json:"mix"`
+	Concurrency int     `json:"concurrency"`
+	Seconds     float64 `json:"seconds"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	// RPS counts the requests completed per second, errors included
+	RPS            float64 `json:"rps"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	LatencyP50Ms   float64 `json:"latencyP50Ms"`
+	LatencyP95Ms   float64 `json:"latencyP95Ms"`
+	LatencyP99Ms   float64 `json:"latencyP99Ms"`
+	ErrorRate      float64 `json:"errorRate"`
+	// OriginOffload is the share of requests the proxy answered without
+	// contacting the origin
+	OriginOffload float64 `json:"originOffload"`
+}
+
+// Report holds the results of a run, in the order of the mixes
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Result returns the result of a mix
+func (r *Report) Result(mix string) (Result, bool) {
+	for _, result := range r.Results {
+		if result.Mix == mix {
+			return result, true
+		}
+	}
+	return Result{}, false
+}
+
+// Run drives mix through client, which must be configured to use the proxy,
+// against origin and measures it. Run only fails if ctx is done first.
+func Run(ctx context.Context, client *http.Client, origin *testhelpers.ProxyTestServer, mix Mix, options Options) (Result, error) {
+	if options.Duration <= 0 {
+		options.Duration = 30 * time.Second
+	}
+	if options.Concurrency < 1 {
+		options.Concurrency = 16
+	}
+
+	// A path of its own keeps the objects of earlier runs out of the cache
+	run := make([]byte, 8)
+	if _, err := rand.Read(run); err != nil {
+		return Result{}, err
+	}
+	path := "/bench/" + hex.EncodeToString(run) + "/" + mix.Name
+	var measuring atomic.Bool
+	var originRequests atomic.Int64
+	modified := time.Now().Add(-24 * time.Hour)
+	origin.Route(path, func(r *http.Request) testhelpers.RouteResponse {
+		if measuring.Load() {
+			originRequests.Add(1)
+		}
+		return testhelpers.RouteResponse{
+			Header: http.Header{
+				"Cache-Control": {"public, max-age=3600"},
+				"Content-Type":  {"application/octet-stream"},
+				"Etag":          {`"` + r.URL.Query().Get("object") + `"`},
+			},
+			Body:         testhelpers.SizedBody(mix.Size),
+			LastModified: modified,
+		}
+	})
+
+	var next atomic.Int64
+	object := func() int64 {
+		if mix.Objects == 0 {
+			return next.Add(1)
+		}
+		return int64(mathrand.IntN(mix.Objects))
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now().Add(options.Warmup)
+	end := start.Add(options.Duration)
+	samples := make([][]sample, options.Concurrency)
+	var wg sync.WaitGroup
+	for worker := range options.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				sent := time.Now()
+				if !sent.Before(end) {
+					return
+				}
+				bytes, err := fetch(ctx, client, origin.URL+path+"?object="+strconv.FormatInt(object(), 10), mix.Size)
+				if ctx.Err() != nil || sent.Before(start) {
+					continue
+				}
+				samples[worker] = append(samples[worker], sample{latency: time.Since(sent), bytes: bytes, failed: err != nil})
+			}
+		}()
+	}
+
+	if options.Warmup > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(start)):
+		}
+	}
+	measuring.Store(true)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Until(end)):
+	}
+	// Wait for the requests sent before the end, for a while
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		cancel()
+	case <-wait(&wg):
+	}
+	wg.Wait()
+	if err := parent.Err(); err != nil {
+		return Result{}, err
+	}
+
+	elapsed := max(time.Since(start), options.Duration)
+	return measure(mix.Name, options.Concurrency, elapsed, slices.Concat(samples...), originRequests.Load()), nil
+}
+
+// wait returns a channel closed when wg is done
+func wait(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// sample is the measure of a request
+type sample struct {
+	latency time.Duration
+	bytes   int64
+	failed  bool
+}
+
+// fetch gets url and fails unless it got a 200 OK with a body of size bytes
+func fetch(ctx context.Context, client *http.Client, url string, size int64) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	bytes, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return bytes, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return bytes, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if bytes != size {
+		return bytes, fmt.Errorf("got %d bytes, want %d", bytes, size)
+	}
+	return bytes, nil
+}
+
+func measure(mix string, concurrency int, elapsed time.Duration, samples []sample, originRequests int64) Result {
+	result := Result{Mix: mix, Concurrency: concurrency, Seconds: elapsed.Seconds(), Requests: int64(len(samples))}
+	if len(samples) == 0 {
+		return result
+	}
+
+	latencies := make([]time.Duration, 0, len(samples))
+	var bytes int64
+	for _, s := range samples {
+		latencies = append(latencies, s.latency)
+		bytes += s.bytes
+		if s.failed {
+			result.Errors++
+		}
+	}
+	slices.Sort(latencies)
+	result.RPS = float64(result.Requests) / result.Seconds
+	result.BytesPerSecond = float64(bytes) / result.Seconds
+	result.LatencyP50Ms = milliseconds(percentile(latencies, 50))
+	result.LatencyP95Ms = milliseconds(percentile(latencies, 95))
+	result.LatencyP99Ms = milliseconds(percentile(latencies, 99))
+	result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+	result.OriginOffload = max(0, 1-float64(originRequests)/float64(result.Requests))
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteReport writes a report as JSON, to be read back as a baseline
+func WriteReport(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// ReadReport reads a report written by WriteReport
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to read the report: %w", err)
+	}
+	return &report, nil
+}
+
+// Thresholds are how much worse than the baseline a result may get
+type Thresholds struct {
+	// Throughput is the largest relative drop of the requests per second
+	Throughput float64
+	// Latency is the largest relative increase of a latency percentile
+	Latency float64
+	// ErrorRate is the largest increase of the error rate
+	ErrorRate float64
+	// Offload is the largest drop of the origin offload
+	Offload float64
+}
+
+// DefaultThresholds leave room for the noise of a shared cluster
+var DefaultThresholds = Thresholds{Throughput: 0.15, Latency: 0.25, ErrorRate: 0.01, Offload: 0.05}
+
+// Regression is a metric of a mix that got worse than its threshold allows
+type Regression struct {
+	Mix      string
+	Metric   string
+	Baseline float64
+	Current  float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s %.4g -> %.4g", r.Mix, r.Metric, r.Baseline, r.Current)
+}
+
+// Compare lists the regressions of current from baseline. Mixes missing from
+// either report are not compared.
+func Compare(baseline, current *Report, thresholds Thresholds) []Regression {
+	var regressions []Regression
+	for _, result := range current.Results {
+		base, ok := baseline.Result(result.Mix)
+		if !ok {
+			continue
+		}
+		check := func(metric string, baseline, current float64, worse bool) {
+			if worse {
+				regressions = append(regressions, Regression{Mix: result.Mix, Metric: metric, Baseline: baseline, Current: current})
+			}
+		}
+		check("rps", base.RPS, result.RPS, result.RPS < base.RPS*(1-thresholds.Throughput))
+		for _, latency := range []struct {
+			metric            string
+			baseline, current float64
+		}{
+			{"latencyP50Ms", base.LatencyP50Ms, result.LatencyP50Ms},
+			{"latencyP95Ms", base.LatencyP95Ms, result.LatencyP95Ms},
+			{"latencyP99Ms", base.LatencyP99Ms, result.LatencyP99Ms},
+		} {
+			check(latency.metric, latency.baseline, latency.current, latency.current > latency.baseline*(1+thresholds.Latency))
+		}
+		check("errorRate", base.ErrorRate, result.ErrorRate, result.ErrorRate > base.ErrorRate+thresholds.ErrorRate)
+		check("originOffload", base.OriginOffload, result.OriginOffload, result.OriginOffload < base.OriginOffload-thresholds.Offload)
+	}
+	return regressions
+}
@@ -0,0 +1,109 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+func TestRun(t *testing.T) {
+	origin, err := testhelpers.NewProxyTestServer("bench", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+
+	// Without a proxy in between, every request reaches the origin
+	mix := Mix{Name: "test", Objects: 4, Size: 2048}
+	result, err := Run(context.Background(), &http.Client{Timeout: 5 * time.Second}, origin, mix,
+		Options{Duration: 300 * time.Millisecond, Warmup: 100 * time.Millisecond, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Mix != "test" || result.Concurrency != 4 || result.Requests == 0 || result.Errors != 0 {
+		t.Fatalf("Run() = %+v, want error-free requests", result)
+	}
+	if result.Seconds < 0.3 || result.RPS <= 0 || result.BytesPerSecond != result.RPS*2048 {
+		t.Errorf("Run() = %+v, want the requests per second over at least 300ms", result)
+	}
+	if result.LatencyP50Ms <= 0 || result.LatencyP50Ms > result.LatencyP95Ms || result.LatencyP95Ms > result.LatencyP99Ms {
+		t.Errorf("latencies p50 %g, p95 %g, p99 %g", result.LatencyP50Ms, result.LatencyP95Ms, result.LatencyP99Ms)
+	}
+	if result.OriginOffload > 0.1 {
+		t.Errorf("origin offload = %g, want about 0 without a proxy", result.OriginOffload)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, http.DefaultClient, origin, mix, Options{Duration: time.Second}); err == nil {
+		t.Errorf("Run() with a cancelled context succeeded")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	var samples []sample
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, sample{latency: time.Duration(i) * time.Millisecond, bytes: 100, failed: i > 98})
+	}
+	result := measure("mix", 8, 2*time.Second, samples, 25)
+	want := Result{
+		Mix: "mix", Concurrency: 8, Seconds: 2, Requests: 100, Errors: 2,
+		RPS: 50, BytesPerSecond: 5000,
+		LatencyP50Ms: 50, LatencyP95Ms: 95, LatencyP99Ms: 99,
+		ErrorRate: 0.02, OriginOffload: 0.75,
+	}
+	if result != want {
+		t.Errorf("measure() = %+v, want %+v", result, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{Results: []Result{
+		{Mix: "hit-heavy", RPS: 1000, LatencyP50Ms: 2, LatencyP95Ms: 5, LatencyP99Ms: 10, OriginOffload: 1},
+		{Mix: "miss-heavy", RPS: 200, LatencyP50Ms: 10, LatencyP95Ms: 20, LatencyP99Ms: 40},
+	}}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, baseline); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := &Report{Results: []Result{
+		// Within the thresholds
+		{Mix: "hit-heavy", RPS: 900, LatencyP50Ms: 2.4, LatencyP95Ms: 6, LatencyP99Ms: 12, ErrorRate: 0.005, OriginOffload: 0.96},
+		{Mix: "miss-heavy", RPS: 150, LatencyP50Ms: 10, LatencyP95Ms: 30, LatencyP99Ms: 40, ErrorRate: 0.02},
+		// Not in the baseline
+		{Mix: "large-objects", RPS: 1},
+	}}
+	var got []string
+	for _, regression := range Compare(baseline, current, DefaultThresholds) {
+		got = append(got, regression.String())
+	}
+	want := []string{
+		"miss-heavy: rps 200 -> 150",
+		"miss-heavy: latencyP95Ms 20 -> 30",
+		"miss-heavy: errorRate 0 -> 0.02",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Compare() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLookupMix(t *testing.T) {
+	for _, name := range []string{"hit-heavy", "miss-heavy", "large-objects", "small-objects"} {
+		if mix, ok := LookupMix(name); !ok || mix.Name != name {
+			t.Errorf("LookupMix(%q) = %+v, %t", name, mix, ok)
+		}
+	}
+	if _, ok := LookupMix("unknown"); ok {
+		t.Errorf("LookupMix(\"unknown\") found a mix")
+	}
+}
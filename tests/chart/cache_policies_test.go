@@ -0,0 +1,67 @@
+package chart
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/konflux-ci/caching/internal/cachepolicy"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestCachePoliciesGolden(t *testing.T) {
+	defaults := render(t)
+	for _, template := range []string{"cachepolicy-controller.yaml", "cache-policies-configmap.yaml"} {
+		if manifest := strings.TrimSpace(defaults["squid/templates/"+template]); manifest != "" {
+			t.Errorf("%s should not render by default:\n%s", template, manifest)
+		}
+	}
+
+	manifests := render(t, filepath.Join("testdata", "cache-policies-values.yaml"))
+	checkGolden(t, "cache-policies", manifests["squid/templates/cachepolicy-controller.yaml"])
+
+	// squid starts with the files the controller would render without
+	// policies
+	var rules corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(manifests["squid/templates/cache-policies-configmap.yaml"]), &rules); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{cachepolicy.AccessFile, cachepolicy.RefreshFile} {
+		if rules.Data[file] != cachepolicy.Header {
+			t.Errorf("%s = %q, want %q", file, rules.Data[file], cachepolicy.Header)
+		}
+	}
+
+	var config corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(manifests["squid/templates/configmap.yaml"]), &config); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"external_acl_type pod_namespace ",
+		"include /etc/squid/cache-policies/access.conf\n",
+		"include /etc/squid/cache-policies/refresh.conf\n",
+	} {
+		if !strings.Contains(config.Data["squid.conf"], line) {
+			t.Errorf("squid.conf does not contain %q", line)
+		}
+	}
+
+	// Both squid and the reloader's `squid -k parse` read the rules, and the
+	// reloader applies their changes
+	pod := deployment(t, manifests).Spec.Template.Spec
+	for _, container := range pod.Containers {
+		if container.Name != "squid" && container.Name != "config-reloader" {
+			continue
+		}
+		if !slices.ContainsFunc(container.VolumeMounts, func(mount corev1.VolumeMount) bool {
+			return mount.Name == "cache-policies" && mount.MountPath == "/etc/squid/cache-policies"
+		}) {
+			t.Errorf("%s does not mount the cache policies", container.Name)
+		}
+		if container.Name == "config-reloader" && !slices.Contains(container.Args, "/etc/squid/config,/etc/squid/cache-policies") {
+			t.Errorf("config-reloader does not watch the cache policies: %v", container.Args)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package chart renders the squid Helm chart with Helm's template engine and
+// checks the output against golden files in testdata. Run
+// `go test ./tests/chart/ -update` to regenerate them after a deliberate
+// template change.
+package chart
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files")
+
+const chartDir = "../../squid"
+
+// render renders the chart with the given values files layered over
+// values.yaml, the way `helm template -f` does, and returns the manifests by
+// template path, e.g. "squid/templates/hpa.yaml"
+func render(t *testing.T, valuesFiles ...string) map[string]string {
+	t.Helper()
+	chart, err := loader.LoadDir(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]any{}
+	for _, file := range valuesFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overlay := map[string]any{}
+		if err := yaml.Unmarshal(data, &overlay); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		values = chartutil.CoalesceTables(overlay, values)
+	}
+
+	renderValues, err := chartutil.ToRenderValues(chart, values,
+		chartutil.ReleaseOptions{Name: "squid", Namespace: "proxy", IsInstall: true}, chartutil.DefaultCapabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := engine.Render(chart, renderValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifests
+}
+
+// checkGolden compares a manifest with testdata/<name>.golden
+func checkGolden(t *testing.T, name, manifest string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".golden")
+	manifest = strings.TrimSpace(manifest) + "\n"
+	if *update {
+		if err := os.WriteFile(golden, []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if manifest != string(expected) {
+		t.Errorf("%s differs from %s (run with -update if the change is deliberate):\n%s", name, golden, manifest)
+	}
+}
+
+func deployment(t *testing.T, manifests map[string]string) *appsv1.Deployment {
+	t.Helper()
+	var deployment appsv1.Deployment
+	if err := yaml.UnmarshalStrict([]byte(manifests["squid/templates/deployment.yaml"]), &deployment); err != nil {
+		t.Fatal(err)
+	}
+	return &deployment
+}
+
+func TestScalingDisabledByDefault(t *testing.T) {
+	manifests := render(t)
+	for _, template := range []string{"hpa.yaml", "pdb.yaml"} {
+		if manifest := strings.TrimSpace(manifests["squid/templates/"+template]); manifest != "" {
+			t.Errorf("%s should not render by default:\n%s", template, manifest)
+		}
+	}
+
+	spec := deployment(t, manifests).Spec
+	if spec.Replicas == nil || *spec.Replicas != 1 {
+		t.Errorf("replicas = %v, want replicaCount", spec.Replicas)
+	}
+	if len(spec.Template.Spec.TopologySpreadConstraints) != 0 {
+		t.Errorf("unexpected topology spread constraints %v", spec.Template.Spec.TopologySpreadConstraints)
+	}
+}
+
+func TestScalingGolden(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// Whether the values define topology spread constraints
+		topologySpread bool
+	}{
+		{"scaling", true},
+		{"cpu-only", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := render(t, filepath.Join("testdata", tt.name+"-values.yaml"))
+			checkGolden(t, tt.name+"-hpa", manifests["squid/templates/hpa.yaml"])
+			checkGolden(t, tt.name+"-pdb", manifests["squid/templates/pdb.yaml"])
+
+			// The HPA owns the replica count
+			spec := deployment(t, manifests).Spec
+			if spec.Replicas != nil {
+				t.Errorf("replicas = %d, should be left to the HPA", *spec.Replicas)
+			}
+			if !tt.topologySpread {
+				if len(spec.Template.Spec.TopologySpreadConstraints) != 0 {
+					t.Errorf("unexpected topology spread constraints %v", spec.Template.Spec.TopologySpreadConstraints)
+				}
+				return
+			}
+			constraints, err := yaml.Marshal(spec.Template.Spec.TopologySpreadConstraints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.name+"-topology-spread", string(constraints))
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package chart
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNetworkPolicyGolden(t *testing.T) {
+	if manifest := strings.TrimSpace(render(t)["squid/templates/networkpolicy.yaml"]); manifest != "" {
+		t.Errorf("networkpolicy.yaml should not render by default:\n%s", manifest)
+	}
+
+	manifest := render(t, filepath.Join("testdata", "networkpolicy-values.yaml"))["squid/templates/networkpolicy.yaml"]
+	checkGolden(t, "networkpolicy", manifest)
+
+	var names []string
+	for _, document := range strings.Split(manifest, "\n---\n") {
+		var policy networkingv1.NetworkPolicy
+		if err := yaml.UnmarshalStrict([]byte(document), &policy); err != nil {
+			t.Fatalf("invalid NetworkPolicy: %v\n%s", err, document)
+		}
+		names = append(names, policy.Name)
+	}
+	if strings.Join(names, ",") != "squid-ingress,squid-egress" {
+		t.Errorf("got NetworkPolicies %v, want squid-ingress and squid-egress", names)
+	}
+}
@@ -0,0 +1,57 @@
+package chart
+
+import (
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParentProxiesGolden(t *testing.T) {
+	manifests := render(t, filepath.Join("testdata", "parent-proxies-values.yaml"))
+
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(manifests["squid/templates/configmap.yaml"]), &configMap); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "parent-proxies", configMap.Data["parents.conf"])
+
+	// Only the parent with credentials gets its Secret mounted
+	pod := deployment(t, manifests).Spec.Template
+	var volumes []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == "corp-creds" {
+			volumes = append(volumes, volume.Name)
+		}
+	}
+	if len(volumes) != 1 || volumes[0] != "parent-corporate" {
+		t.Errorf("got Secret volumes %v for corp-creds, want parent-corporate", volumes)
+	}
+	mounted := false
+	for _, mount := range squidContainer(t, manifests).VolumeMounts {
+		if mount.Name == "parent-corporate" && mount.MountPath == "/etc/squid/parents/corporate" {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Error("the corporate parent's credentials are not mounted at /etc/squid/parents/corporate")
+	}
+	if pod.Annotations["checksum/parent-proxies"] == "" {
+		t.Error("the pods do not roll when the parent proxies change")
+	}
+}
+
+func TestParentProxiesDisabledByDefault(t *testing.T) {
+	manifests := render(t)
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(manifests["squid/templates/configmap.yaml"]), &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := configMap.Data["parents.conf"]; ok {
+		t.Error("parents.conf should not render by default")
+	}
+	if _, ok := deployment(t, manifests).Spec.Template.Annotations["checksum/parent-proxies"]; ok {
+		t.Error("the pods should not carry a parent proxies checksum by default")
+	}
+}
@@ -0,0 +1,47 @@
+package chart
+
+import (
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// squidContainer returns the squid container of the rendered Deployment
+func squidContainer(t *testing.T, manifests map[string]string) *corev1.Container {
+	t.Helper()
+	for _, container := range deployment(t, manifests).Spec.Template.Spec.Containers {
+		if container.Name == "squid" {
+			return &container
+		}
+	}
+	t.Fatal("the Deployment has no squid container")
+	return nil
+}
+
+func TestProbesGolden(t *testing.T) {
+	squid := squidContainer(t, render(t))
+	probes, err := yaml.Marshal(map[string]*corev1.Probe{
+		"startupProbe":   squid.StartupProbe,
+		"livenessProbe":  squid.LivenessProbe,
+		"readinessProbe": squid.ReadinessProbe,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "probes", string(probes))
+}
+
+func TestProbesDisabled(t *testing.T) {
+	squid := squidContainer(t, render(t, filepath.Join("testdata", "probes-disabled-values.yaml")))
+	if squid.StartupProbe != nil {
+		t.Errorf("startupProbe = %+v, should be disabled", squid.StartupProbe)
+	}
+	if squid.LivenessProbe != nil {
+		t.Errorf("livenessProbe = %+v, should be disabled", squid.LivenessProbe)
+	}
+	if squid.ReadinessProbe != nil {
+		t.Errorf("readinessProbe = %+v, should be disabled", squid.ReadinessProbe)
+	}
+}
@@ -0,0 +1,16 @@
+package chart
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxyInjectionGolden(t *testing.T) {
+	if manifest := strings.TrimSpace(render(t)["squid/templates/proxy-injector.yaml"]); manifest != "" {
+		t.Errorf("proxy-injector.yaml should not render by default:\n%s", manifest)
+	}
+
+	manifest := render(t, filepath.Join("testdata", "proxy-injection-values.yaml"))["squid/templates/proxy-injector.yaml"]
+	checkGolden(t, "proxy-injection", manifest)
+}
@@ -0,0 +1,109 @@
+package e2e_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nativeAccessLogLine matches a line of squid's native access log format
+var nativeAccessLogLine = regexp.MustCompile(`^\d+\.\d{3}\s+\d+ \S+ [A-Z_]+/\d{3} `)
+
+var _ = Describe("JSON Access Logs", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		pod        corev1.Pod
+	)
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		if !strings.Contains(configMap.Data["squid.conf"], "access_log stdio:/dev/stdout logformat=json") {
+			Skip("JSON access logs are not enabled (accessLog.format=squid)")
+		}
+
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		pod = pods[0]
+
+		testServer, err = newTestServer("Hello from access log test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Talk to the replica whose logs are read
+		client, err = testhelpers.NewProxyClient(pod.Status.PodIP + ":3128")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	It("should write every request as a JSON object with the configured fields", func() {
+		testURL := testServer.URL + "/access-log/?" + generateCacheBuster("access-log")
+		req, err := http.NewRequest(http.MethodGet, testURL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		requestID := generateCacheBuster("request-id")
+		req.Header.Set("X-Request-Id", requestID)
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		Eventually(func(g Gomega) {
+			since := int64(120)
+			stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container:    "squid",
+				SinceSeconds: &since,
+			}).Stream(ctx)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer stream.Close()
+			logs, err := io.ReadAll(stream)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			// The container log also carries cache.log from stderr; access log
+			// lines are the JSON objects
+			var found *testhelpers.AccessLogEntry
+			scanner := bufio.NewScanner(bytes.NewReader(logs))
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				line := scanner.Text()
+				g.Expect(nativeAccessLogLine.MatchString(line)).To(BeFalse(), "Access log line in the native format: %s", line)
+				if !strings.HasPrefix(line, "{") {
+					continue
+				}
+				entry, err := testhelpers.ParseAccessLogLine(line)
+				g.Expect(err).NotTo(HaveOccurred(), "Invalid access log line: %s", line)
+				if entry.URL == testURL {
+					found = entry
+				}
+			}
+			g.Expect(scanner.Err()).NotTo(HaveOccurred())
+
+			g.Expect(found).NotTo(BeNil(), "No access log line for %s", testURL)
+			g.Expect(found.Method).To(Equal(http.MethodGet))
+			g.Expect(found.Status).To(Equal(http.StatusOK))
+			g.Expect(found.ResultCode).To(HaveSuffix("_MISS"))
+			g.Expect(found.Bytes).To(BeNumerically(">", 0))
+			// Fetched from the origin directly or, with the cache peer mesh, possibly
+			// after asking the siblings
+			g.Expect(found.Hierarchy).To(HaveSuffix("DIRECT"))
+			g.Expect(found.MimeType).To(Equal("application/json"))
+			g.Expect(found.RequestID).To(Equal(requestID))
+			g.Expect(found.Client).NotTo(BeEmpty())
+		}, timeout, interval).Should(Succeed())
+	})
+})
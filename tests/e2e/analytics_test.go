@@ -0,0 +1,111 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// analyticsSample returns the value of a squid_analytics_* counter in a
+// metrics page, or 0 if the series is not exported yet
+func analyticsSample(g Gomega, metrics []byte, name, label, value, result string) float64 {
+	pattern := regexp.MustCompile(fmt.Sprintf(`(?m)^%s\{%s="%s",result="%s"\} (\S+)$`,
+		name, label, regexp.QuoteMeta(value), result))
+	match := pattern.FindSubmatch(metrics)
+	if match == nil {
+		return 0
+	}
+	sample, err := strconv.ParseFloat(string(match[1]), 64)
+	g.Expect(err).NotTo(HaveOccurred())
+	return sample
+}
+
+var _ = Describe("Access Log Analytics", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		pod        corev1.Pod
+		metricsURL string
+	)
+
+	BeforeEach(func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		pod = pods[0]
+
+		analytics := findContainer(&pod.Spec, "access-log-analytics")
+		if analytics == nil {
+			Skip("access log analytics are not enabled (accessLogAnalytics.enabled=false)")
+		}
+		metricsURL = fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, analytics.Ports[0].ContainerPort)
+
+		testServer, err = newTestServer("Hello from analytics test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// The counters are per replica, so talk to the one being scraped
+		client, err = testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", pod.Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	scrape := func(g Gomega) []byte {
+		resp, err := http.Get(metricsURL)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		body, err := io.ReadAll(resp.Body)
+		g.Expect(err).NotTo(HaveOccurred())
+		return body
+	}
+
+	It("should count hits and misses by domain, namespace and content type", func() {
+		testURL := testServer.URL + "/analytics/?" + generateCacheBuster("analytics")
+		parsed, err := url.Parse(testURL)
+		Expect(err).NotTo(HaveOccurred())
+		domain := parsed.Hostname()
+
+		type series struct{ name, label, value string }
+		counted := []series{
+			{"squid_analytics_domain_requests_total", "domain", domain},
+			{"squid_analytics_content_type_requests_total", "content_type", "application/json"},
+			// The test pods run in the proxy namespace
+			{"squid_analytics_namespace_requests_total", "namespace", namespace},
+		}
+		before := scrape(Default)
+
+		By("Fetching an object twice")
+		for range 2 {
+			resp, _, err := testhelpers.MakeProxyRequest(client, testURL)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)), "Second request should be served from cache")
+
+		By("Waiting for the miss and the hit to be counted")
+		Eventually(func(g Gomega) {
+			after := scrape(g)
+			for _, s := range counted {
+				for _, result := range []string{"miss", "hit"} {
+					g.Expect(analyticsSample(g, after, s.name, s.label, s.value, result)).To(
+						BeNumerically(">=", analyticsSample(g, before, s.name, s.label, s.value, result)+1),
+						"%s{%s=%q,result=%q} should count the request", s.name, s.label, s.value, result)
+				}
+			}
+		}, timeout, interval).Should(Succeed())
+	})
+})
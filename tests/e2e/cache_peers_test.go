@@ -0,0 +1,160 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// findContainer returns the named container of a pod spec, or nil
+func findContainer(spec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+// containerEnv returns the literal value of an environment variable of a
+// container and whether it is set
+func containerEnv(container *corev1.Container, name string) (string, bool) {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return env.Value, true
+		}
+	}
+	return "", false
+}
+
+// cachePeersEnabled reports whether the squid container was deployed with
+// the cache peer mesh (cachePeers.enabled in the chart values)
+func cachePeersEnabled(squidContainer *corev1.Container) bool {
+	_, ok := containerEnv(squidContainer, "SQUID_PEERS_SERVICE")
+	return ok
+}
+
+// readySquidPods lists the squid proxy pods that are currently ready
+func readySquidPods() ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/name=squid,app.kubernetes.io/component=squid-proxy",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready = append(ready, pod)
+			}
+		}
+	}
+	return ready, nil
+}
+
+var _ = Describe("Cache Peer Mesh", func() {
+	var (
+		testServer      *testhelpers.ProxyTestServer
+		pods            []corev1.Pod
+		refreshInterval time.Duration
+	)
+
+	BeforeEach(func() {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+
+		squidContainer := findContainer(&deployment.Spec.Template.Spec, "squid")
+		Expect(squidContainer).NotTo(BeNil(), "squid container should exist")
+		if !cachePeersEnabled(squidContainer) {
+			Skip("cache peer mesh is not enabled (cachePeers.enabled=false)")
+		}
+
+		refreshInterval = 30 * time.Second
+		if value, ok := containerEnv(squidContainer, "SQUID_PEERS_REFRESH_INTERVAL"); ok {
+			seconds, err := strconv.Atoi(value)
+			Expect(err).NotTo(HaveOccurred(), "Invalid SQUID_PEERS_REFRESH_INTERVAL")
+			refreshInterval = time.Duration(seconds) * time.Second
+		}
+
+		pods, err = readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		if len(pods) < 2 {
+			Skip(fmt.Sprintf("cache peer mesh needs at least 2 ready replicas, found %d", len(pods)))
+		}
+
+		testServer, err = newTestServer("Hello from cache peer test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	It("should publish every replica through the headless peers service", func() {
+		service, err := clientset.CoreV1().Services(namespace).Get(ctx, serviceName+"-peers", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-peers service")
+		Expect(service.Spec.ClusterIP).To(Equal(corev1.ClusterIPNone))
+		Expect(service.Spec.PublishNotReadyAddresses).To(BeTrue())
+
+		Eventually(func() int {
+			endpoints, err := clientset.CoreV1().Endpoints(namespace).Get(ctx, serviceName+"-peers", metav1.GetOptions{})
+			if err != nil {
+				return 0
+			}
+			addresses := 0
+			for _, subset := range endpoints.Subsets {
+				addresses += len(subset.Addresses) + len(subset.NotReadyAddresses)
+			}
+			return addresses
+		}, timeout, interval).Should(BeNumerically(">=", len(pods)), "Every replica should be a peers endpoint")
+	})
+
+	It("should serve an object cached by one replica as a sibling hit through another", func() {
+		first, second := pods[0], pods[1]
+
+		firstClient, err := testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", first.Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client for %s", first.Name)
+		secondClient, err := testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", second.Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client for %s", second.Name)
+
+		// Replicas learn about each other on the next peer refresh, so retry
+		// with a fresh URL until the mesh has converged
+		Eventually(func(g Gomega) {
+			testServer.ResetRequestCount()
+			testURL := testServer.URL + "?" + generateCacheBuster("cache-peers")
+
+			By(fmt.Sprintf("Fetching the object through %s", first.Name))
+			resp1, body1, err := testhelpers.MakeProxyRequest(firstClient, testURL)
+			g.Expect(err).NotTo(HaveOccurred(), "First request should succeed")
+			defer resp1.Body.Close()
+			g.Expect(resp1.StatusCode).To(Equal(http.StatusOK))
+			g.Expect(testServer.GetRequestCount()).To(Equal(int32(1)), "Origin should have been fetched once")
+
+			By(fmt.Sprintf("Fetching the same object through %s", second.Name))
+			resp2, body2, err := testhelpers.MakeProxyRequest(secondClient, testURL)
+			g.Expect(err).NotTo(HaveOccurred(), "Second request should succeed")
+			defer resp2.Body.Close()
+			g.Expect(resp2.StatusCode).To(Equal(http.StatusOK))
+
+			g.Expect(testServer.GetRequestCount()).To(Equal(int32(1)),
+				"Origin should not be contacted again when a sibling holds the object")
+			g.Expect(resp2.Header.Values("X-Cache")).To(ContainElement("HIT from "+first.Name),
+				"Second replica should have been served by its sibling")
+			g.Expect(string(body2)).To(Equal(string(body1)), "Sibling hit should return the cached body")
+		}, 2*refreshInterval+timeout, interval).Should(Succeed())
+	})
+})
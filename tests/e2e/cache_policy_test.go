@@ -0,0 +1,184 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	cachingv1alpha1 "github.com/konflux-ci/caching/api/v1alpha1"
+	"github.com/konflux-ci/caching/internal/cachepolicy"
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Cache Policies", func() {
+	const controllerName = "squid-cachepolicy-controller"
+
+	var (
+		testServer  *testhelpers.ProxyTestServer
+		proxyClient *http.Client
+		k8sClient   client.Client
+	)
+
+	// fetchStatus requests a fresh URL under the specs' path and returns the
+	// status code
+	fetchStatus := func(g Gomega) int {
+		resp, _, err := testhelpers.MakeProxyRequest(proxyClient, testServer.URL+"/cache-policy/?"+generateCacheBuster("cache-policy"))
+		g.Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// liftRestriction waits for squid to stop restricting the test pods'
+	// namespace once its policies allowing domains are deleted
+	liftRestriction := func() {
+		Eventually(func(g Gomega) {
+			g.Expect(fetchStatus(g)).To(Equal(http.StatusOK))
+		}, configPropagationTimeout, 5*time.Second).Should(Succeed(), "The restriction should be lifted")
+	}
+
+	BeforeEach(func() {
+		_, err := clientset.AppsV1().Deployments(namespace).Get(ctx, controllerName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			Skip("cache policies are not enabled (cachePolicies.enabled=false)")
+		}
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the CachePolicy controller Deployment")
+		Eventually(func(g Gomega) {
+			current, err := clientset.AppsV1().Deployments(namespace).Get(ctx, controllerName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(current.Status.AvailableReplicas).To(BeNumerically(">=", 1))
+		}, timeout, interval).Should(Succeed(), "The CachePolicy controller should become available")
+
+		scheme := runtime.NewScheme()
+		Expect(cachingv1alpha1.AddToScheme(scheme)).To(Succeed())
+		k8sClient, err = client.New(restConfig, client.Options{Scheme: scheme})
+		Expect(err).NotTo(HaveOccurred(), "Failed to create the CachePolicy client")
+
+		testServer, err = newTestServer("Hello from cache policy test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+		// Registered first, it runs after the specs' cleanups, which need it
+		DeferCleanup(testServer.Close)
+		proxyClient, err = testhelpers.NewSquidProxyClient(serviceName, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	It("should apply a tenant's rules and report the rejected ones", func() {
+		Expect(fetchStatus(Default)).To(Equal(http.StatusOK), "The test server should be reachable before the policy")
+
+		By("Restricting the test pods' namespace to other destinations")
+		// The test server is addressed by IP, which the allowed domain does
+		// not match
+		regex := "^http://cache-policy\\.example\\.com/"
+		policy := &cachingv1alpha1.CachePolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "e2e-cache-policy"},
+			Spec: cachingv1alpha1.CachePolicySpec{
+				RefreshPatterns: []cachingv1alpha1.RefreshPattern{
+					{Regex: regex, Min: 1, Percent: 20, Max: 60},
+					// Not anchored at a site
+					{Regex: `\.rpm$`, Min: 1440, Max: 10080},
+					// Outside the allowed domains
+					{Regex: `^https://quay\.io/`, Min: 1440, Max: 10080},
+				},
+				AllowedDomains: []string{".example.com", "not a domain"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+		DeferCleanup(func() {
+			By("Deleting the policy")
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, policy))).To(Succeed())
+			liftRestriction()
+		})
+
+		By("Checking the rejected rules in the policy's status")
+		Eventually(func(g Gomega) {
+			var current cachingv1alpha1.CachePolicy
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(policy), &current)).To(Succeed())
+			condition := meta.FindStatusCondition(current.Status.Conditions, cachepolicy.ConditionAccepted)
+			g.Expect(condition).NotTo(BeNil())
+			g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			g.Expect(condition.Reason).To(Equal(cachepolicy.ReasonRulesRejected))
+			var rules []string
+			for _, rule := range current.Status.RejectedRules {
+				rules = append(rules, rule.Rule)
+			}
+			g.Expect(rules).To(ConsistOf("refreshPatterns[1]", "refreshPatterns[2]", "allowedDomains[1]"))
+		}, timeout, interval).Should(Succeed())
+
+		By("Checking the rendered configuration")
+		Eventually(func(g Gomega) {
+			configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-cache-policies", metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(configMap.Data[cachepolicy.RefreshFile]).To(ContainSubstring(fmt.Sprintf("refresh_pattern %s 1 20%% 60\n", regex)))
+			g.Expect(configMap.Data[cachepolicy.RefreshFile]).NotTo(ContainSubstring(`\.rpm$`))
+			g.Expect(configMap.Data[cachepolicy.RefreshFile]).NotTo(ContainSubstring(`quay`))
+			g.Expect(configMap.Data[cachepolicy.AccessFile]).To(ContainSubstring(
+				fmt.Sprintf("acl cachepolicy_%s_domains dstdomain -n .example.com\n", namespace)))
+			g.Expect(strings.Count(configMap.Data[cachepolicy.AccessFile], "http_access")).To(Equal(1))
+		}, timeout, interval).Should(Succeed())
+
+		By("Waiting for squid to deny the test server")
+		Eventually(func(g Gomega) {
+			g.Expect(fetchStatus(g)).To(Equal(http.StatusForbidden))
+		}, configPropagationTimeout, 5*time.Second).Should(Succeed())
+	})
+
+	It("should keep the rules of an older policy when a newer one conflicts", func() {
+		regex := "^http://cache-policy-conflict\\.example\\.com/"
+		// Refresh patterns need their host allowed, which restricts the
+		// namespace until the policies are gone
+		DeferCleanup(liftRestriction)
+		older := &cachingv1alpha1.CachePolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "e2e-cache-policy-older"},
+			Spec: cachingv1alpha1.CachePolicySpec{
+				RefreshPatterns: []cachingv1alpha1.RefreshPattern{{Regex: regex, Min: 10, Max: 20}},
+				AllowedDomains:  []string{"cache-policy-conflict.example.com"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, older)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, older))).To(Succeed())
+		})
+		newer := &cachingv1alpha1.CachePolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "e2e-cache-policy-newer"},
+			Spec: cachingv1alpha1.CachePolicySpec{
+				RefreshPatterns: []cachingv1alpha1.RefreshPattern{{Regex: regex, Min: 30, Max: 40}},
+				AllowedDomains:  []string{"cache-policy-conflict.example.com"},
+			},
+		}
+		// Creation timestamps have a resolution of a second
+		time.Sleep(1100 * time.Millisecond)
+		Expect(k8sClient.Create(ctx, newer)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, newer))).To(Succeed())
+		})
+
+		accepted := func(g Gomega, key types.NamespacedName) *metav1.Condition {
+			var current cachingv1alpha1.CachePolicy
+			g.Expect(k8sClient.Get(ctx, key, &current)).To(Succeed())
+			condition := meta.FindStatusCondition(current.Status.Conditions, cachepolicy.ConditionAccepted)
+			g.Expect(condition).NotTo(BeNil())
+			return condition
+		}
+		Eventually(func(g Gomega) {
+			g.Expect(accepted(g, client.ObjectKeyFromObject(older)).Status).To(Equal(metav1.ConditionTrue))
+			g.Expect(accepted(g, client.ObjectKeyFromObject(newer)).Status).To(Equal(metav1.ConditionFalse))
+			configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-cache-policies", metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(configMap.Data[cachepolicy.RefreshFile]).To(ContainSubstring(fmt.Sprintf("refresh_pattern %s 10 0%% 20\n", regex)))
+			g.Expect(configMap.Data[cachepolicy.RefreshFile]).NotTo(ContainSubstring(" 30 0% 40"))
+		}, timeout, interval).Should(Succeed())
+
+		By("Accepting the newer policy's rule once the older one is gone")
+		Expect(k8sClient.Delete(ctx, older)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(accepted(g, client.ObjectKeyFromObject(newer)).Status).To(Equal(metav1.ConditionTrue))
+		}, timeout, interval).Should(Succeed())
+	})
+})
@@ -0,0 +1,85 @@
+package e2e_test
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Collapsed Forwarding", func() {
+	const (
+		// Simultaneous requests for one URL, as from a CI fan-out
+		concurrentRequests = 20
+		// The origin holds its response long enough for every request to
+		// arrive while the first fetch is in flight
+		originDelay = "3s"
+	)
+
+	var testServer *testhelpers.ProxyTestServer
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		if !strings.Contains(configMap.Data["squid.conf"], "collapsed_forwarding on") {
+			Skip("collapsed forwarding is not enabled (collapsedForwarding.enabled=false)")
+		}
+
+		testServer, err = newTestServer("Hello from collapsed forwarding test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// expectCollapsed sends concurrent misses for one URL through client and
+	// checks that the origin saw at most maxFetches of them, and that every
+	// client got one of those fetches' responses
+	expectCollapsed := func(client *http.Client, name string, maxFetches int) {
+		testURL := testServer.URL + "/collapsed-forwarding/?delay=" + originDelay + "&" + generateCacheBuster(name)
+		results := testhelpers.ConcurrentRequests(client, testURL, concurrentRequests)
+
+		requestIDs := map[float64]bool{}
+		for i, result := range results {
+			Expect(result.Err).NotTo(HaveOccurred(), "Request %d failed", i)
+			Expect(result.Response.StatusCode).To(Equal(http.StatusOK), "Request %d failed", i)
+			response, err := testhelpers.ParseTestServerResponse(result.Body)
+			Expect(err).NotTo(HaveOccurred())
+			requestIDs[response.RequestID] = true
+		}
+
+		fetches := int(testServer.GetRequestCount())
+		Expect(fetches).To(BeNumerically(">=", 1))
+		Expect(fetches).To(BeNumerically("<=", maxFetches),
+			"%d concurrent requests should collapse into at most %d origin fetches", concurrentRequests, maxFetches)
+		Expect(len(requestIDs)).To(Equal(fetches), "Every response should come from one of the origin fetches")
+	}
+
+	It("should fetch once from the origin for concurrent misses on one replica", func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		client, err := testhelpers.NewProxyClient(pods[0].Status.PodIP + ":3128")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+		expectCollapsed(client, "collapsed-forwarding-pod", 1)
+	})
+
+	It("should fetch at most once per replica for concurrent misses through the service", func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		client, err := testhelpers.NewSquidProxyClient(serviceName, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+		// The Service spreads the requests over the replicas, each of which
+		// may miss before any of them holds the object
+		expectCollapsed(client, "collapsed-forwarding-service", len(pods))
+	})
+})
@@ -0,0 +1,152 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// configPropagationTimeout bounds how long a ConfigMap update takes to reach
+// the pods (the kubelet sync period) and be applied by the config reloader
+const configPropagationTimeout = 3 * time.Minute
+
+// reloadTestRules deny a path that no other spec uses
+const reloadTestRules = `acl reload_test_denied urlpath_regex ^/reload-denied/
+http_access deny reload_test_denied
+`
+
+var successfulReloads = regexp.MustCompile(`(?m)^squid_reloader_reloads_total\{result="success"\} (\S+)$`)
+
+// updateSquidConf replaces squid.conf in the squid-config ConfigMap
+func updateSquidConf(squidConf string) error {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	configMap.Data["squid.conf"] = squidConf
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// containerRestarts sums the restart counts of a pod's containers
+func containerRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
+
+var _ = Describe("Live Configuration Reload", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		pod        corev1.Pod
+		metricsURL string
+	)
+
+	BeforeEach(func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		pod = pods[0]
+
+		reloader := findContainer(&pod.Spec, "config-reloader")
+		if reloader == nil {
+			Skip("the config reloader is not enabled (configReloader.enabled=false)")
+		}
+		metricsURL = fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, reloader.Ports[0].ContainerPort)
+
+		testServer, err = newTestServer("Hello from config reload test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Talk to a single replica, so that its cache can be observed
+		client, err = testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", pod.Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// fetchStatus requests a fresh URL under path and returns the status code
+	fetchStatus := func(g Gomega, path string) int {
+		resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+path+"?"+generateCacheBuster("config-reload"))
+		g.Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// reloads returns the number of successful reloads reported by the reloader
+	reloads := func(g Gomega) float64 {
+		resp, err := http.Get(metricsURL)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		g.Expect(err).NotTo(HaveOccurred())
+		match := successfulReloads.FindSubmatch(body)
+		g.Expect(match).NotTo(BeNil(), "Reloader should report successful reloads")
+		value, err := strconv.ParseFloat(string(match[1]), 64)
+		g.Expect(err).NotTo(HaveOccurred())
+		return value
+	}
+
+	It("should apply an ACL change without restarting squid or losing the cache", func() {
+		By("Caching an object")
+		cachedURL := testServer.URL + "/reload-cached/?" + generateCacheBuster("config-reload-cached")
+		for range 2 {
+			resp, _, err := testhelpers.MakeProxyRequest(client, cachedURL)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)), "Second request should be served from cache")
+		restarts := containerRestarts(&pod)
+		reloadsBefore := reloads(Default)
+
+		By("Denying a path in the ConfigMap")
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		original := configMap.Data["squid.conf"]
+		Expect(original).To(ContainSubstring("http_access deny manager\n"))
+		Expect(updateSquidConf(strings.Replace(original, "http_access deny manager\n",
+			"http_access deny manager\n"+reloadTestRules, 1))).To(Succeed())
+		DeferCleanup(func() {
+			By("Restoring the ConfigMap")
+			Expect(updateSquidConf(original)).To(Succeed())
+			Eventually(func(g Gomega) {
+				g.Expect(fetchStatus(g, "/reload-denied/")).To(Equal(http.StatusOK))
+			}, configPropagationTimeout, 5*time.Second).Should(Succeed())
+		})
+
+		By("Waiting for the change to take effect")
+		Eventually(func(g Gomega) {
+			g.Expect(fetchStatus(g, "/reload-denied/")).To(Equal(http.StatusForbidden))
+		}, configPropagationTimeout, 5*time.Second).Should(Succeed())
+		Expect(reloads(Default)).To(BeNumerically(">", reloadsBefore), "The reload should be reported")
+
+		By("Verifying the cached object survived")
+		requestsBefore := testServer.GetRequestCount()
+		resp, _, err := testhelpers.MakeProxyRequest(client, cachedURL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(testServer.GetRequestCount()).To(Equal(requestsBefore), "Cached object should still be served from cache")
+		Expect(resp.Header.Values("X-Cache")).To(ContainElement(HavePrefix("HIT from")))
+
+		current, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(containerRestarts(current)).To(Equal(restarts), "No container should have restarted")
+	})
+})
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/konflux-ci/caching/tests/testhelpers"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,7 +21,9 @@ import (
 
 var (
 	clientset *kubernetes.Clientset
-	ctx       context.Context
+	// restConfig is the configuration clientset was created from
+	restConfig *rest.Config
+	ctx        context.Context
 )
 
 const (
@@ -45,6 +49,26 @@ func getPodIP() (string, error) {
 	return podIP, nil
 }
 
+// newTestServer starts a test origin reachable from the squid pods, listening
+// on TEST_SERVER_PORT when set (as under mirrord) or a random port otherwise
+func newTestServer(message string) (*testhelpers.ProxyTestServer, error) {
+	// Get the pod's IP address for cross-pod communication
+	podIP, err := getPodIP()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get test server port from environment, fallback to 0 (random port)
+	testPort := 0
+	if testPortStr := os.Getenv("TEST_SERVER_PORT"); testPortStr != "" {
+		if port, parseErr := strconv.Atoi(testPortStr); parseErr == nil {
+			testPort = port
+		}
+	}
+
+	return testhelpers.NewProxyTestServer(message, podIP, testPort)
+}
+
 var _ = BeforeSuite(func() {
 	ctx = context.Background()
 
@@ -67,6 +91,7 @@ var _ = BeforeSuite(func() {
 		Expect(err).NotTo(HaveOccurred(), "Failed to create kubeconfig from %s", kubeconfig)
 	}
 
+	restConfig = config
 	clientset, err = kubernetes.NewForConfig(config)
 	Expect(err).NotTo(HaveOccurred(), "Failed to create Kubernetes client")
 
@@ -0,0 +1,133 @@
+package e2e_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// egressDenial is the body of the ERR_EGRESS_* error pages
+type egressDenial struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+	URL    string `json:"url"`
+	Method string `json:"method"`
+	Host   string `json:"host"`
+}
+
+var _ = Describe("Egress Policy", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		squidConf  string
+	)
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		squidConf = configMap.Data["squid.conf"]
+		// The specs rely on the deny list entry from tests/e2e/values.yaml
+		if !strings.Contains(squidConf, "acl egress_deny_regex url_regex -i ^http://[^/]+/egress-denied/") {
+			Skip("the egress policy from tests/e2e/values.yaml is not deployed")
+		}
+
+		testServer, err = newTestServer("Hello from egress policy test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Talk to a single replica, so that the audit spec knows whose log to read
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		client, err = testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", pods[0].Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	auditMode := func() bool {
+		return strings.Contains(squidConf, "logformat egress_audit_")
+	}
+
+	// expectDenied verifies that a request was refused with the given error page
+	expectDenied := func(url, page, reason string) {
+		resp, body, err := testhelpers.MakeProxyRequest(client, url)
+		Expect(err).NotTo(HaveOccurred(), "Request should complete")
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		Expect(resp.Header.Get("X-Squid-Error")).To(HavePrefix(page))
+
+		var denial egressDenial
+		Expect(json.Unmarshal(body, &denial)).To(Succeed(), "Deny page should be JSON: %s", body)
+		Expect(denial.Error).To(Equal("egress_denied"))
+		Expect(denial.Reason).To(Equal(reason))
+		Expect(denial.Method).To(Equal(http.MethodGet))
+		Expect(url).To(HavePrefix(strings.SplitN(denial.URL, "?", 2)[0]), "Deny page should name the URL")
+	}
+
+	It("should allow destinations on the allow list", func() {
+		resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"/egress-allowed/?"+generateCacheBuster("egress-allowed"))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+	})
+
+	It("should deny destinations on the deny list", func() {
+		if auditMode() {
+			Skip("the egress policy is in audit mode")
+		}
+		expectDenied(testServer.URL+"/egress-denied/?"+generateCacheBuster("egress-denied"),
+			"ERR_EGRESS_DENYLISTED", "denylisted")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(0)), "Denied requests should not reach the origin")
+	})
+
+	It("should deny destinations on neither list when the default action is deny", func() {
+		if auditMode() || !strings.Contains(squidConf, "deny_info ERR_EGRESS_NOT_ALLOWED") {
+			Skip("unlisted destinations are allowed")
+		}
+		// Outside the allowed networks, and never resolvable anyway
+		expectDenied("http://egress-not-allowed.invalid/?"+generateCacheBuster("egress-unlisted"),
+			"ERR_EGRESS_NOT_ALLOWED", "not-allowlisted")
+	})
+
+	It("should only log violations in audit mode", func() {
+		if !auditMode() {
+			Skip("the egress policy is enforced")
+		}
+		url := testServer.URL + "/egress-denied/?" + generateCacheBuster("egress-audit")
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, url)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK), "Audit mode should not block")
+
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func(g Gomega) {
+			since := int64(120)
+			stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pods[0].Name, &corev1.PodLogOptions{
+				Container:    "squid",
+				SinceSeconds: &since,
+			}).Stream(ctx)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer stream.Close()
+			logs, err := io.ReadAll(stream)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(string(logs)).To(ContainSubstring("EGRESS_AUDIT"))
+			g.Expect(string(logs)).To(ContainSubstring("reason=denylisted"))
+			g.Expect(string(logs)).To(ContainSubstring(strings.SplitN(url, "?", 2)[0]))
+		}, timeout, interval).Should(Succeed())
+	})
+})
@@ -0,0 +1,88 @@
+package e2e_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// squidUp matches the exporter's squid_up sample when it could read the
+// cache manager pages
+var squidUp = regexp.MustCompile(`(?m)^squid_up(\{[^}]*\})? 1$`)
+
+var _ = Describe("Squid Exporter Credentials", func() {
+	var (
+		pod      corev1.Pod
+		exporter *corev1.Container
+		password string
+	)
+
+	BeforeEach(func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		pod = pods[0]
+
+		exporter = findContainer(&pod.Spec, "squid-exporter")
+		if exporter == nil {
+			Skip("the squid exporter is not enabled (squidExporter.enabled=false)")
+		}
+		var secretRef *corev1.SecretKeySelector
+		for _, env := range exporter.Env {
+			if env.Name == "SQUID_PASSWORD" && env.ValueFrom != nil {
+				secretRef = env.ValueFrom.SecretKeyRef
+			}
+		}
+		if secretRef == nil {
+			Skip("cache manager credentials are not configured (squidExporter.squidPassword is empty)")
+		}
+
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, secretRef.Name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get cache manager credentials secret")
+		password = string(secret.Data[secretRef.Key])
+		Expect(password).NotTo(BeEmpty(), "Cache manager password should be set")
+	})
+
+	It("should keep the password out of the Deployment", func() {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+		manifest, err := json.Marshal(deployment)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(manifest)).NotTo(ContainSubstring(password), "The password should only be in the Secret")
+
+		Expect(exporter.Args).NotTo(ContainElement("-squid-password"), "The password should not be passed as an argument")
+		for _, env := range exporter.Env {
+			if env.Name == "SQUID_LOGIN" || env.Name == "SQUID_PASSWORD" {
+				Expect(env.Value).To(BeEmpty(), "%s should come from the Secret", env.Name)
+				Expect(env.ValueFrom).NotTo(BeNil(), "%s should come from the Secret", env.Name)
+			}
+		}
+
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		Expect(configMap.Data["squid.conf"]).NotTo(ContainSubstring(password))
+		Expect(configMap.Data["squid.conf"]).To(ContainSubstring("include /run/squid/cachemgr.conf"),
+			"squid should require the cache manager password")
+	})
+
+	It("should still scrape squid's cache manager", func() {
+		metricsURL := fmt.Sprintf("http://%s:%d/metrics", pod.Status.PodIP, exporter.Ports[0].ContainerPort)
+		Eventually(func(g Gomega) {
+			resp, err := http.Get(metricsURL)
+			g.Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			body, err := io.ReadAll(resp.Body)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(squidUp.Match(body)).To(BeTrue(), "squid_up should be 1 with the credentials from the Secret")
+			g.Expect(string(body)).To(ContainSubstring("squid_client_http_requests_total"))
+		}, timeout, interval).Should(Succeed())
+	})
+})
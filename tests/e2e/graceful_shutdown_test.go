@@ -0,0 +1,168 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Graceful Shutdown", func() {
+	const (
+		downloadSize     = 20 << 20
+		downloadDuration = 60 * time.Second
+		// Old pods wait for their transfers before they go away
+		rolloutTimeout = 5 * time.Minute
+	)
+
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+	)
+
+	BeforeEach(func() {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+		squid := findContainer(&deployment.Spec.Template.Spec, "squid")
+		Expect(squid).NotTo(BeNil(), "squid container should exist")
+		if squid.Lifecycle == nil || squid.Lifecycle.PreStop == nil {
+			Skip("the squid container has no preStop drain hook")
+		}
+
+		testServer, err = newTestServer("Hello from graceful shutdown test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		client, err = testhelpers.NewSquidProxyClient(serviceName, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// mage test:cluster runs the helm upgrade when the spec writes the file
+	// E2E_UPGRADE_REQUEST names, and reports how it went in its .done file
+	It("should finish in-flight downloads and serve new requests during a helm upgrade", func() {
+		request := os.Getenv("E2E_UPGRADE_REQUEST")
+		if request == "" {
+			Skip("E2E_UPGRADE_REQUEST is not set, mage test:cluster sets it")
+		}
+		before, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+		oldPods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(oldPods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		By("Starting a slow download")
+		type result struct {
+			status int
+			size   int
+			err    error
+		}
+		downloaded := make(chan result, 1)
+		downloadClient := *client
+		downloadClient.Timeout = rolloutTimeout
+		go func() {
+			defer GinkgoRecover()
+			url := fmt.Sprintf("%s%s?size=%d&duration=%s&%s", testServer.URL, testhelpers.SlowDownloadPath,
+				downloadSize, downloadDuration, generateCacheBuster("graceful-shutdown"))
+			resp, err := downloadClient.Get(url)
+			if err != nil {
+				downloaded <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			n, err := io.Copy(io.Discard, resp.Body)
+			downloaded <- result{status: resp.StatusCode, size: int(n), err: err}
+		}()
+		Eventually(testServer.GetRequestCount, timeout, interval).Should(BeNumerically(">=", 1),
+			"The download should reach the origin")
+
+		By("Running helm upgrade with a changed pod template")
+		Expect(os.WriteFile(request, nil, 0o644)).To(Succeed())
+		var upgradeError []byte
+		Eventually(func() error {
+			upgradeError, err = os.ReadFile(request + ".done")
+			return err
+		}, 2*time.Minute, interval).Should(Succeed(), "mage should answer the upgrade request")
+		Expect(string(upgradeError)).To(BeEmpty(), "helm upgrade failed")
+		Eventually(func(g Gomega) {
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(deployment.Generation).To(BeNumerically(">", before.Generation))
+		}, timeout, interval).Should(Succeed(), "The upgrade should change the pod template")
+
+		By("Making requests while the pods are replaced")
+		var (
+			mu       sync.Mutex
+			failures []string
+			requests int
+		)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(500 * time.Millisecond):
+				}
+				resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"/?"+generateCacheBuster("graceful-shutdown-request"))
+				mu.Lock()
+				requests++
+				if err != nil {
+					failures = append(failures, err.Error())
+				} else if resp.StatusCode != http.StatusOK {
+					failures = append(failures, fmt.Sprintf("status %d", resp.StatusCode))
+				}
+				mu.Unlock()
+			}
+		}()
+
+		Eventually(func(g Gomega) {
+			terminating := 0
+			for _, pod := range oldPods {
+				current, err := clientset.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+				if err != nil || current.DeletionTimestamp != nil {
+					terminating++
+				}
+			}
+			g.Expect(terminating).To(BeNumerically(">=", 1))
+		}, downloadDuration/2, interval).Should(Succeed(), "An old pod should be terminating while the download runs")
+
+		By("Waiting for the download to finish")
+		var r result
+		Eventually(downloaded, rolloutTimeout).Should(Receive(&r))
+		Expect(r.err).NotTo(HaveOccurred(), "The download should not be cut off")
+		Expect(r.status).To(Equal(http.StatusOK))
+		Expect(r.size).To(Equal(downloadSize), "The download should be complete")
+
+		By("Waiting for the upgrade's rollout to complete")
+		Eventually(func(g Gomega) {
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(deployment.Status.ObservedGeneration).To(BeNumerically(">=", deployment.Generation))
+			g.Expect(deployment.Status.UpdatedReplicas).To(Equal(*deployment.Spec.Replicas))
+			g.Expect(deployment.Status.ReadyReplicas).To(Equal(*deployment.Spec.Replicas))
+			g.Expect(deployment.Status.Replicas).To(Equal(*deployment.Spec.Replicas), "Old pods should be gone")
+		}, rolloutTimeout, interval).Should(Succeed())
+		close(stop)
+		<-done
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(requests).To(BeNumerically(">", 0))
+		Expect(failures).To(BeEmpty(), "%d of %d requests failed during the rollout", len(failures), requests)
+	})
+})
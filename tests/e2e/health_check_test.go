@@ -0,0 +1,84 @@
+package e2e_test
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Health Checks", func() {
+	var squid *corev1.Container
+
+	BeforeEach(func() {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+		squid = findContainer(&deployment.Spec.Template.Spec, "squid")
+		Expect(squid).NotTo(BeNil(), "squid container should exist")
+		if squid.LivenessProbe == nil && squid.ReadinessProbe == nil {
+			Skip("the squid container probes are disabled")
+		}
+	})
+
+	It("should probe squid with squid-health", func() {
+		for name, probe := range map[string]*corev1.Probe{
+			"live":  squid.LivenessProbe,
+			"ready": squid.ReadinessProbe,
+		} {
+			if probe == nil {
+				continue
+			}
+			Expect(probe.Exec).NotTo(BeNil(), "The %s probe should run a command", name)
+			Expect(len(probe.Exec.Command)).To(BeNumerically(">=", 2))
+			Expect(probe.Exec.Command[:2]).To(Equal([]string{"/usr/local/bin/squid-health", name}))
+		}
+	})
+
+	It("should keep healthy pods ready without restarting them", func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		// Probes that fail, e.g. because the synthetic fetch is denied, would
+		// keep the pods unready or have the kubelet restart squid
+		Consistently(func(g Gomega) {
+			pods, err := readySquidPods()
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(pods).NotTo(BeEmpty())
+			for _, pod := range pods {
+				for _, status := range pod.Status.ContainerStatuses {
+					if status.Name == "squid" {
+						g.Expect(status.RestartCount).To(BeZero(), "squid in pod %s should not be restarted", pod.Name)
+					}
+				}
+			}
+		}, 15*time.Second, time.Second).Should(Succeed())
+	})
+
+	It("should leave the probes' synthetic fetches out of the access log", func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		// The probes fetch every few seconds, so two minutes of logs would
+		// hold plenty of them
+		since := int64(120)
+		stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pods[0].Name, &corev1.PodLogOptions{
+			Container:    "squid",
+			SinceSeconds: &since,
+		}).Stream(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		logs, err := io.ReadAll(stream)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, line := range strings.Split(string(logs), "\n") {
+			Expect(line).NotTo(ContainSubstring("/squid-internal-static/"),
+				"Health check requests should not be logged")
+		}
+	})
+})
@@ -0,0 +1,86 @@
+package e2e_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Network Policies", func() {
+	var squidImage corev1.Container
+
+	BeforeEach(func() {
+		_, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get(ctx, "squid-ingress", metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			Skip("network policies are not enabled (networkPolicy.enabled=false)")
+		}
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the ingress NetworkPolicy")
+
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+		squid := findContainer(&deployment.Spec.Template.Spec, "squid")
+		Expect(squid).NotTo(BeNil(), "squid container should exist")
+		// The squid image is known to be available in the cluster and has bash
+		squidImage = corev1.Container{Image: squid.Image, ImagePullPolicy: squid.ImagePullPolicy}
+	})
+
+	// probe connects to the proxy port from a pod in probeNamespace and
+	// returns the exit code: 0 if the connection was established
+	probe := func(probeNamespace string) int32 {
+		target := fmt.Sprintf("%s.%s.svc.cluster.local/%d", serviceName, namespace, 3128)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "network-policy-probe-"},
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers: []corev1.Container{{
+					Name:            "probe",
+					Image:           squidImage.Image,
+					ImagePullPolicy: squidImage.ImagePullPolicy,
+					Command:         []string{"timeout", "10", "bash", "-c", "exec 3<>/dev/tcp/" + target},
+				}},
+			},
+		}
+
+		// A new namespace gets its default service account asynchronously
+		Eventually(func() error {
+			var err error
+			pod, err = clientset.CoreV1().Pods(probeNamespace).Create(ctx, pod, metav1.CreateOptions{})
+			return err
+		}, timeout, interval).Should(Succeed(), "Failed to create probe pod")
+		DeferCleanup(func() {
+			_ = clientset.CoreV1().Pods(probeNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		})
+
+		var exitCode int32
+		Eventually(func(g Gomega) {
+			current, err := clientset.CoreV1().Pods(probeNamespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(current.Status.ContainerStatuses).NotTo(BeEmpty())
+			terminated := current.Status.ContainerStatuses[0].State.Terminated
+			g.Expect(terminated).NotTo(BeNil(), "Probe should have finished")
+			exitCode = terminated.ExitCode
+		}, timeout, interval).Should(Succeed())
+		return exitCode
+	}
+
+	It("should let pods in the proxy namespace connect", func() {
+		Expect(probe(namespace)).To(BeZero(), "Probe from the proxy namespace should connect")
+	})
+
+	It("should refuse proxy clients from namespaces that are not selected", func() {
+		outsider, err := clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "squid-network-policy-"},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to create namespace")
+		DeferCleanup(func() {
+			_ = clientset.CoreV1().Namespaces().Delete(ctx, outsider.Name, metav1.DeleteOptions{})
+		})
+
+		Expect(probe(outsider.Name)).NotTo(BeZero(),
+			"Probe from namespace %s, which lacks the client label, should not connect", outsider.Name)
+	})
+})
@@ -0,0 +1,142 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Parent Proxies", func() {
+	const (
+		// Domain the e2e parents carry, under which the stand-in resolves
+		// hosts to the IP address spelled by their first label
+		routedDomain = "parent-routed.test"
+		// cache_peer name of the stand-in in tests/e2e/values.yaml
+		parentName = "e2e-parent"
+	)
+
+	var (
+		testServer    *testhelpers.ProxyTestServer
+		client        *http.Client
+		parentAddress string
+	)
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		if !strings.Contains(configMap.Data["parents.conf"], "name="+parentName+" ") {
+			Skip("the forward proxy stand-in is not a parent (see parentProxies in tests/e2e/values.yaml)")
+		}
+
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		testServer, err = newTestServer("Hello from parent proxy test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Hits are only guaranteed on the replica holding the object
+		client, err = testhelpers.NewProxyClient(pods[0].Status.PodIP + ":3128")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+
+		parentAddress = fmt.Sprintf("%s-test-parent.%s.svc.cluster.local:3129", deploymentName, namespace)
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// routedURL addresses the test server under the routed domain
+	routedURL := func(path, cacheBuster string) string {
+		origin, err := url.Parse(testServer.URL)
+		Expect(err).NotTo(HaveOccurred())
+		host := strings.ReplaceAll(origin.Hostname(), ".", "-") + "." + routedDomain
+		return "http://" + host + ":" + origin.Port() + path + "?" + cacheBuster
+	}
+
+	// parentRequests returns how many requests the stand-in forwarded for
+	// URLs carrying cacheBuster
+	parentRequests := func(cacheBuster string) int {
+		requests, err := testhelpers.GetForwardProxyRequests(parentAddress)
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the parent's requests")
+		count := 0
+		for requestURL, n := range requests {
+			if strings.Contains(requestURL, cacheBuster) {
+				count += n
+			}
+		}
+		return count
+	}
+
+	It("should route cache misses through the parent and serve hits from the cache", func() {
+		cacheBuster := generateCacheBuster("parent-proxy-routed")
+		testURL := routedURL("/parent-proxy/routed/", cacheBuster)
+
+		resp, body, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		original, err := testhelpers.ParseTestServerResponse(body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parentRequests(cacheBuster)).To(Equal(1), "The miss should go through the parent")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+
+		resp, body, err = testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		response, err := testhelpers.ParseTestServerResponse(body)
+		Expect(err).NotTo(HaveOccurred())
+		testhelpers.ValidateCacheHit(original, response, original.RequestID)
+		Expect(parentRequests(cacheBuster)).To(Equal(1), "The hit should not reach the parent")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+	})
+
+	It("should fetch destinations outside the parents' domains directly", func() {
+		cacheBuster := generateCacheBuster("parent-proxy-direct")
+		testURL := testServer.URL + "/parent-proxy/direct/?" + cacheBuster
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+		Expect(parentRequests(cacheBuster)).To(BeZero(), "The request should not go through the parent")
+	})
+
+	It("should fail over from a parent that is down", func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		parents := configMap.Data["parents.conf"]
+		down := strings.Index(parents, "name="+parentName+"-down ")
+		if down < 0 || down > strings.Index(parents, "name="+parentName+" ") {
+			Skip("no parent that is down ahead of the stand-in (see parentProxies in tests/e2e/values.yaml)")
+		}
+
+		// Several misses, as squid only skips the down parent once it has
+		// counted it dead
+		for i := 0; i < 3; i++ {
+			cacheBuster := generateCacheBuster(fmt.Sprintf("parent-proxy-failover-%d", i))
+			resp, _, err := testhelpers.MakeProxyRequest(client, routedURL("/parent-proxy/failover/", cacheBuster))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK), "Miss %d should fail over to the next parent", i)
+			Expect(parentRequests(cacheBuster)).To(Equal(1))
+		}
+	})
+
+	It("should authenticate to the parent with the credentials from the Secret", func() {
+		// The stand-in rejects requests without the credentials squid sends
+		unauthenticated, err := testhelpers.NewProxyClient(parentAddress)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+		resp, _, err := testhelpers.MakeProxyRequest(unauthenticated,
+			routedURL("/parent-proxy/unauthenticated/", generateCacheBuster("parent-proxy-unauthenticated")))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusProxyAuthRequired))
+		Expect(testServer.GetRequestCount()).To(BeZero())
+	})
+})
@@ -0,0 +1,92 @@
+package e2e_test
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Credentials of the proxyAuth users from tests/e2e/values.yaml
+const (
+	e2eUser               = "e2e-user"
+	e2eUserPassword       = "e2e-user-password"
+	e2eRestrictedUser     = "e2e-restricted"
+	e2eRestrictedPassword = "e2e-restricted-password"
+)
+
+var _ = Describe("Proxy Authentication and Tenant ACLs", func() {
+	var testServer *testhelpers.ProxyTestServer
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		squidConf := configMap.Data["squid.conf"]
+		if !strings.Contains(squidConf, "acl tenant_e2e_users proxy_auth "+e2eUser) ||
+			!strings.Contains(squidConf, "acl tenant_e2e-restricted_users proxy_auth "+e2eRestrictedUser) {
+			Skip("the proxyAuth users and tenants from tests/e2e/values.yaml are not deployed")
+		}
+
+		testServer, err = newTestServer("Hello from proxy auth test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	It("should allow an authenticated user to reach its tenant's destinations", func() {
+		client, err := testhelpers.NewSquidProxyClientWithCredentials(serviceName, namespace, e2eUser, e2eUserPassword)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"?"+generateCacheBuster("auth-allowed"))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+	})
+
+	It("should deny destinations outside the tenant's allowlist", func() {
+		client, err := testhelpers.NewSquidProxyClientWithCredentials(serviceName, namespace, e2eRestrictedUser, e2eRestrictedPassword)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"?"+generateCacheBuster("auth-denied"))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden), "The test server is not in the tenant's allowed domains")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(0)), "Denied requests should not reach the origin")
+	})
+
+	It("should challenge requests with invalid credentials", func() {
+		for _, credentials := range [][2]string{
+			{e2eUser, "not-the-password"},
+			{"unknown-user", e2eUserPassword},
+		} {
+			client, err := testhelpers.NewSquidProxyClientWithCredentials(serviceName, namespace, credentials[0], credentials[1])
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"?"+generateCacheBuster("auth-invalid"))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusProxyAuthRequired), "User %s should be challenged", credentials[0])
+			Expect(resp.Header.Get("Proxy-Authenticate")).To(HavePrefix("Basic realm="))
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(0)), "Unauthenticated requests should not reach the origin")
+	})
+
+	It("should attribute requests without credentials to the client's namespace", func() {
+		// The tests run in the proxy namespace, which the e2e tenant covers
+		client, err := testhelpers.NewSquidProxyClient(serviceName, namespace)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"?"+generateCacheBuster("auth-namespace"))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+})
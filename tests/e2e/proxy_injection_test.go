@@ -0,0 +1,200 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Proxy Injection", func() {
+	const (
+		injectorName = "squid-proxy-injector"
+		// Tenant namespaces are matched by name, so the consumer namespace
+		// has a fixed one (see tenancy in tests/e2e/values.yaml)
+		consumerNamespace = "squid-proxy-injection-e2e"
+	)
+
+	var (
+		testServer *testhelpers.ProxyTestServer
+		squidImage corev1.Container
+	)
+
+	BeforeEach(func() {
+		injector, err := clientset.AppsV1().Deployments(namespace).Get(ctx, injectorName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			Skip("proxy injection is not enabled (proxyInjection.enabled=false)")
+		}
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the proxy injector Deployment")
+		Eventually(func(g Gomega) {
+			current, err := clientset.AppsV1().Deployments(namespace).Get(ctx, injectorName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(current.Status.AvailableReplicas).To(BeNumerically(">=", 1))
+		}, timeout, interval).Should(Succeed(), "The proxy injector should become available")
+		// The injector runs the squid image, which is known to be available
+		// in the cluster and has curl
+		squidImage = injector.Spec.Template.Spec.Containers[0]
+
+		testServer, err = newTestServer("Hello from proxy injection test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// createConsumerNamespace creates the opted-in namespace, waiting for one
+	// left by an earlier run to be deleted
+	createConsumerNamespace := func() {
+		Eventually(func() error {
+			_, err := clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: consumerNamespace,
+					Labels: map[string]string{
+						"caching.konflux-ci.dev/inject-proxy": "true",
+						// Selected by networkPolicy.clients
+						"caching.konflux-ci.dev/proxy-client": "true",
+					},
+				},
+			}, metav1.CreateOptions{})
+			return err
+		}, 2*timeout, interval).Should(Succeed(), "Failed to create namespace %s", consumerNamespace)
+		DeferCleanup(func() {
+			_ = clientset.CoreV1().Namespaces().Delete(ctx, consumerNamespace, metav1.DeleteOptions{})
+		})
+	}
+
+	It("should point pods in opted-in namespaces at the proxy and its CA bundle", func() {
+		createConsumerNamespace()
+		testURL := testServer.URL + "/proxy-injection/?" + generateCacheBuster("proxy-injection")
+
+		// The pod sets no proxy itself: curl only goes through squid, which
+		// the origin sees as the client, if the webhook injected it
+		var pod *corev1.Pod
+		Eventually(func(g Gomega) {
+			created, err := clientset.CoreV1().Pods(consumerNamespace).Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "proxy-injection-"},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:            "fetch",
+						Image:           squidImage.Image,
+						ImagePullPolicy: squidImage.ImagePullPolicy,
+						Command:         []string{"curl", "-fsS", "--max-time", "20", testURL},
+					}},
+				},
+			}, metav1.CreateOptions{})
+			// A new namespace gets its default service account asynchronously
+			g.Expect(err).NotTo(HaveOccurred())
+			if created.Annotations["caching.konflux-ci.dev/proxy-injected"] != "true" {
+				// Admitted unchanged while the webhook's CA was still being
+				// injected: try again
+				_ = clientset.CoreV1().Pods(consumerNamespace).Delete(ctx, created.Name, metav1.DeleteOptions{})
+			}
+			g.Expect(created.Annotations).To(HaveKeyWithValue("caching.konflux-ci.dev/proxy-injected", "true"),
+				"The webhook should have injected the pod")
+			pod = created
+		}, 2*timeout, interval).Should(Succeed())
+
+		By("checking the injected pod spec")
+		proxyURL := fmt.Sprintf("http://%s.%s.svc.cluster.local:3128", serviceName, namespace)
+		env := map[string]string{}
+		for _, variable := range pod.Spec.Containers[0].Env {
+			env[variable.Name] = variable.Value
+		}
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			Expect(env).To(HaveKeyWithValue(name, proxyURL))
+		}
+		Expect(env).To(HaveKeyWithValue("NO_PROXY", ContainSubstring(".svc")))
+		Expect(env).To(HaveKeyWithValue("no_proxy", env["NO_PROXY"]))
+		Expect(env).To(HaveKeyWithValue("SSL_CERT_FILE", "/etc/proxy-ca/ca-bundle.crt"))
+
+		var bundle *corev1.Volume
+		for i := range pod.Spec.Volumes {
+			if pod.Spec.Volumes[i].Name == "proxy-ca-bundle" {
+				bundle = &pod.Spec.Volumes[i]
+			}
+		}
+		Expect(bundle).NotTo(BeNil(), "The CA bundle volume should be injected")
+		Expect(bundle.ConfigMap).NotTo(BeNil())
+		Expect(bundle.ConfigMap.Name).To(Equal(namespace + "-ca-bundle"))
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(And(
+			HaveField("Name", "proxy-ca-bundle"),
+			HaveField("MountPath", "/etc/proxy-ca"),
+		)))
+
+		By("fetching through the proxy from the pod")
+		Eventually(func(g Gomega) {
+			current, err := clientset.CoreV1().Pods(consumerNamespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(current.Status.ContainerStatuses).NotTo(BeEmpty())
+			terminated := current.Status.ContainerStatuses[0].State.Terminated
+			g.Expect(terminated).NotTo(BeNil(), "The fetch should have finished")
+			g.Expect(terminated.ExitCode).To(BeZero(), "The fetch should have succeeded")
+		}, 2*timeout, interval).Should(Succeed())
+
+		logs, err := clientset.CoreV1().Pods(consumerNamespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).Do(ctx).Raw()
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the fetch pod's logs")
+		original, err := testhelpers.ParseTestServerResponse(logs)
+		Expect(err).NotTo(HaveOccurred(), "The pod should have printed the origin's response")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+
+		By("checking that the fetch was cached")
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		cached := false
+		for _, squidPod := range pods {
+			client, err := testhelpers.NewProxyClient(squidPod.Status.PodIP + ":3128")
+			Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+			// Replicas that do not hold the object answer 504 instead of
+			// fetching it
+			req, err := http.NewRequest(http.MethodGet, testURL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Cache-Control", "only-if-cached")
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			Expect(err).NotTo(HaveOccurred())
+			if resp.StatusCode != http.StatusOK {
+				continue
+			}
+			response, err := testhelpers.ParseTestServerResponse(body)
+			Expect(err).NotTo(HaveOccurred())
+			testhelpers.ValidateCacheHit(original, response, original.RequestID)
+			cached = true
+		}
+		Expect(cached).To(BeTrue(), "A squid replica should hold the object fetched by the pod")
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)))
+	})
+
+	It("should leave pods in other namespaces alone", func() {
+		pod, err := clientset.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "proxy-injection-skipped-"},
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers: []corev1.Container{{
+					Name:            "noop",
+					Image:           squidImage.Image,
+					ImagePullPolicy: squidImage.ImagePullPolicy,
+					Command:         []string{"true"},
+				}},
+			},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to create pod")
+		DeferCleanup(func() {
+			_ = clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		})
+
+		Expect(pod.Annotations).NotTo(HaveKey("caching.konflux-ci.dev/proxy-injected"))
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+})
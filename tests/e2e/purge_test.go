@@ -0,0 +1,131 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/konflux-ci/caching/internal/purge"
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Cache Purge API", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		apiURL     string
+		token      string
+	)
+
+	BeforeEach(func() {
+		service, err := clientset.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid service")
+
+		var purgePort int32
+		for _, port := range service.Spec.Ports {
+			if port.Name == "purge" {
+				purgePort = port.Port
+			}
+		}
+		if purgePort == 0 {
+			Skip("purge API is not enabled (purgeApi.enabled=false)")
+		}
+		apiURL = fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", serviceName, namespace, purgePort)
+
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, "squid-purge-api", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get purge API token secret")
+		token = string(secret.Data["token"])
+		Expect(token).NotTo(BeEmpty(), "Purge API token should be set")
+
+		testServer, err = newTestServer("Hello from purge test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		client, err = testhelpers.NewSquidProxyClient(serviceName, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	It("should reject purge requests without a valid token", func() {
+		testURL := testServer.URL + "?" + generateCacheBuster("purge-unauthenticated")
+
+		status, _, err := testhelpers.PurgeRequest(apiURL, "", purge.Request{URL: testURL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(http.StatusUnauthorized))
+
+		status, _, err = testhelpers.PurgeRequest(apiURL, "not-the-token", purge.Request{URL: testURL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should make a purged URL a MISS again on every replica", func() {
+		testURL := testServer.URL + "?" + generateCacheBuster("purge-url")
+
+		By("Caching the URL")
+		resp, _, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		resp, _, err = testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)), "Second request should be served from cache")
+
+		By("Purging the URL through the purge API")
+		status, response, err := testhelpers.PurgeRequest(apiURL, token, purge.Request{URL: testURL})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(http.StatusOK), "Purge should succeed on every replica: %+v", response)
+
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Replicas).To(HaveLen(len(pods)), "Purge should be fanned out to every replica")
+
+		var purged []string
+		for _, replica := range response.Replicas {
+			Expect(replica.Error).To(BeEmpty(), "Replica %s failed to purge", replica.Replica)
+			purged = append(purged, replica.Purged...)
+		}
+		Expect(purged).To(ContainElement(testURL), "The URL should have been purged by the replica holding it")
+
+		By("Verifying the purged URL is fetched from the origin again")
+		resp, _, err = testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(testServer.GetRequestCount()).To(Equal(int32(2)), "Purged URL should be a cache MISS")
+		Expect(resp.Header.Values("X-Cache")).NotTo(ContainElement(ContainSubstring("HIT from")),
+			"No replica should serve the purged URL from cache")
+	})
+
+	It("should purge every object matching a pattern", func() {
+		buster := generateCacheBuster("purge-pattern")
+		urls := []string{
+			testServer.URL + "/purge-pattern/a?" + buster,
+			testServer.URL + "/purge-pattern/b?" + buster,
+		}
+		for _, url := range urls {
+			resp, _, err := testhelpers.MakeProxyRequest(client, url)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(2)))
+
+		status, response, err := testhelpers.PurgeRequest(apiURL, token, purge.Request{
+			Pattern: `/purge-pattern/[ab]\?` + regexp.QuoteMeta(buster) + "$",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(http.StatusOK), "Purge should succeed on every replica: %+v", response)
+
+		for _, url := range urls {
+			resp, _, err := testhelpers.MakeProxyRequest(client, url)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(4)), "Every purged URL should be a cache MISS")
+	})
+})
@@ -0,0 +1,131 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/replay"
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Traffic Replay", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+	)
+
+	BeforeEach(func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		testServer, err = newTestServer("Hello from traffic replay test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Replay through a single replica so that its outcomes do not depend
+		// on how the service balances the requests
+		client, err = testhelpers.NewProxyClient(pods[0].Status.PodIP + ":3128")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	It("should report the cache outcome of every URL of a trace", func() {
+		// Native access log lines, a second apart
+		line := func(second int, status int, bytes int, url string) string {
+			return fmt.Sprintf("%d.000 5 10.0.0.1 TCP_MISS/%03d %d GET %s - HIER_DIRECT/10.0.0.2 application/octet-stream",
+				1700000000+second, status, bytes, url)
+		}
+		trace, err := replay.ReadTrace(strings.NewReader(strings.Join([]string{
+			line(0, 200, 2048, "http://registry.example.com/blobs/a"),
+			line(1, 404, 10, "http://registry.example.com/blobs/missing"),
+			line(1, 200, 2048, "http://registry.example.com/blobs/a"),
+			line(2, 404, 10, "http://registry.example.com/blobs/missing"),
+			line(3, 200, 2048, "http://registry.example.com/blobs/a"),
+		}, "\n")))
+		Expect(err).NotTo(HaveOccurred())
+
+		r, err := replay.New(trace, testServer, replay.Options{Speed: 2})
+		Expect(err).NotTo(HaveOccurred())
+		report, err := r.Run(ctx, client)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("Serving the object last modified a day ago from the cache")
+		Expect(report.URLs["http://registry.example.com/blobs/a"]).To(Equal(replay.Outcome{
+			Requests: 3, Hits: 2, Sequence: "MHH", OriginRequests: 1,
+		}))
+
+		By("Forwarding every request for the missing object, as squid does not cache 404s by default")
+		Expect(report.URLs["http://registry.example.com/blobs/missing"]).To(Equal(replay.Outcome{
+			Requests: 2, Sequence: "MM", OriginRequests: 2,
+		}))
+
+		By("Finding no difference with a baseline of the same outcomes")
+		Expect(replay.Diff(report, report)).To(BeEmpty())
+	})
+
+	// Replays a trace captured from a deployment, given by REPLAY_TRACE, and
+	// compares it with REPLAY_BASELINE when set. mage test:replay sets them.
+	It("should serve a captured trace like its baseline", func() {
+		tracePath := os.Getenv("REPLAY_TRACE")
+		if tracePath == "" {
+			Skip("REPLAY_TRACE is not set")
+		}
+		file, err := os.Open(tracePath)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		trace, err := replay.ReadTrace(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trace).NotTo(BeEmpty(), "No requests found in %s", tracePath)
+
+		options := replay.Options{Speed: 1}
+		if speed := os.Getenv("REPLAY_SPEED"); speed != "" {
+			options.Speed, err = strconv.ParseFloat(speed, 64)
+			Expect(err).NotTo(HaveOccurred(), "Invalid REPLAY_SPEED")
+		}
+		r, err := replay.New(trace, testServer, options)
+		Expect(err).NotTo(HaveOccurred())
+
+		GinkgoWriter.Printf("Replaying %d requests over %s at speed %g\n", len(trace), trace.Duration(), options.Speed)
+		start := time.Now()
+		report, err := r.Run(ctx, client)
+		Expect(err).NotTo(HaveOccurred())
+		GinkgoWriter.Printf("Replayed in %s: hit ratio %.1f%%, %d origin requests, %d errors\n",
+			time.Since(start).Round(time.Second), report.HitRatio()*100, report.OriginRequests, report.Errors)
+
+		if reportPath := os.Getenv("REPLAY_REPORT"); reportPath != "" {
+			out, err := os.Create(reportPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer out.Close()
+			Expect(replay.WriteReport(out, report)).To(Succeed())
+		}
+
+		baselinePath := os.Getenv("REPLAY_BASELINE")
+		if baselinePath == "" {
+			return
+		}
+		in, err := os.Open(baselinePath)
+		Expect(err).NotTo(HaveOccurred())
+		defer in.Close()
+		baseline, err := replay.ReadReport(in)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(baseline.Speed).To(Equal(report.Speed), "The baseline was replayed at another speed")
+
+		changes := replay.Diff(baseline, report)
+		var lines []string
+		for _, change := range changes {
+			lines = append(lines, change.String())
+		}
+		Expect(changes).To(BeEmpty(), "%s:\n%s", replay.Summarize(baseline, report, changes), strings.Join(lines, "\n"))
+	})
+})
@@ -0,0 +1,79 @@
+package e2e_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var _ = Describe("Scaling and Availability", func() {
+	var deployment *appsv1.Deployment
+
+	BeforeEach(func() {
+		var err error
+		deployment, err = clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid deployment")
+	})
+
+	It("should autoscale the deployment within the HPA bounds", func() {
+		hpa, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			Skip("autoscaling is not enabled (autoscaling.enabled=false)")
+		}
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the HorizontalPodAutoscaler")
+
+		Expect(hpa.Spec.ScaleTargetRef.Kind).To(Equal("Deployment"))
+		Expect(hpa.Spec.ScaleTargetRef.Name).To(Equal(deployment.Name))
+		Expect(hpa.Spec.MinReplicas).NotTo(BeNil())
+		Expect(hpa.Spec.Metrics).NotTo(BeEmpty())
+
+		Eventually(func(g Gomega) {
+			dep, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(dep.Status.ReadyReplicas).To(BeNumerically(">=", *hpa.Spec.MinReplicas))
+			g.Expect(dep.Status.ReadyReplicas).To(BeNumerically("<=", hpa.Spec.MaxReplicas))
+		}, timeout, interval).Should(Succeed(), "The deployment should run within the HPA's replica bounds")
+	})
+
+	It("should protect the squid pods with a PodDisruptionBudget", func() {
+		pdb, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			Skip("the PodDisruptionBudget is not enabled (podDisruptionBudget.enabled=false)")
+		}
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the PodDisruptionBudget")
+
+		Expect(pdb.Spec.Selector.MatchLabels).To(Equal(deployment.Spec.Selector.MatchLabels),
+			"The PodDisruptionBudget should select the squid pods")
+
+		Eventually(func(g Gomega) {
+			pdb, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(pdb.Status.ExpectedPods).To(BeNumerically(">=", 1), "The budget should count the squid pods")
+			g.Expect(pdb.Status.CurrentHealthy).To(BeNumerically(">=", pdb.Status.DesiredHealthy))
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("should spread the squid pods across topology domains", func() {
+		constraints := deployment.Spec.Template.Spec.TopologySpreadConstraints
+		if len(constraints) == 0 {
+			Skip("no topology spread constraints are configured (topologySpreadConstraints is empty)")
+		}
+
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		for _, pod := range pods {
+			Expect(pod.Spec.TopologySpreadConstraints).To(Equal(constraints))
+		}
+		for _, constraint := range constraints {
+			Expect(constraint.LabelSelector).NotTo(BeNil(), "Constraints should select the squid pods by default")
+			selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selector.Matches(labels.Set(pods[0].Labels))).To(BeTrue(),
+				"The %s constraint should select the squid pods", constraint.TopologyKey)
+		}
+	})
+})
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/konflux-ci/caching/tests/testhelpers"
@@ -47,6 +46,21 @@ func generateCacheBuster(testName string) string {
 		GinkgoRandomSeed())
 }
 
+// optionalContainers are the sidecars added by optional chart features
+var optionalContainers = []string{"purge-api", "config-reloader", "access-log-analytics"}
+
+// expectSquidContainers verifies that a squid pod spec runs squid and the
+// exporter, plus only sidecars of optional chart features
+func expectSquidContainers(spec *corev1.PodSpec) {
+	var names []string
+	for _, container := range spec.Containers {
+		names = append(names, container.Name)
+	}
+	Expect(names).To(ContainElements("squid", "squid-exporter"))
+	Expect(names).To(HaveEach(BeElementOf(append([]string{"squid", "squid-exporter"}, optionalContainers...))),
+		"Pod should only run squid, squid-exporter and optional sidecars")
+}
+
 var _ = Describe("Squid Helm Chart Deployment", func() {
 
 	Describe("Namespace", func() {
@@ -91,7 +105,7 @@ var _ = Describe("Squid Helm Chart Deployment", func() {
 		})
 
 		It("should have the correct container image and configuration", func() {
-			Expect(deployment.Spec.Template.Spec.Containers).To(HaveLen(2))
+			expectSquidContainers(&deployment.Spec.Template.Spec)
 
 			// Find squid container
 			var squidContainer *corev1.Container
@@ -105,7 +119,13 @@ var _ = Describe("Squid Helm Chart Deployment", func() {
 			Expect(squidContainer.Image).To(ContainSubstring("squid"))
 
 			// Check squid port configuration
-			Expect(squidContainer.Ports).To(HaveLen(1))
+			if cachePeersEnabled(squidContainer) {
+				Expect(squidContainer.Ports).To(HaveLen(2))
+				Expect(squidContainer.Ports[1].Name).To(Equal("peers"))
+				Expect(squidContainer.Ports[1].Protocol).To(Equal(corev1.ProtocolUDP))
+			} else {
+				Expect(squidContainer.Ports).To(HaveLen(1))
+			}
 			Expect(squidContainer.Ports[0].ContainerPort).To(Equal(int32(3128)))
 			Expect(squidContainer.Ports[0].Name).To(Equal("http"))
 
@@ -146,7 +166,13 @@ var _ = Describe("Squid Helm Chart Deployment", func() {
 		})
 
 		It("should have the correct port configuration", func() {
-			Expect(service.Spec.Ports).To(HaveLen(2))
+			var portNames []string
+			for _, port := range service.Spec.Ports {
+				portNames = append(portNames, port.Name)
+			}
+			Expect(portNames).To(ContainElements("http", "metrics"))
+			Expect(portNames).To(HaveEach(BeElementOf("http", "metrics", "purge", "reload-metrics", "analytics")),
+				"Service should only expose squid, metrics and optional purge API, reloader and analytics metrics ports")
 
 			// Find http port (squid)
 			var httpPort *corev1.ServicePort
@@ -233,7 +259,7 @@ var _ = Describe("Squid Helm Chart Deployment", func() {
 
 		It("should have correct resource configuration", func() {
 			for _, pod := range pods.Items {
-				Expect(pod.Spec.Containers).To(HaveLen(2))
+				expectSquidContainers(&pod.Spec)
 
 				// Find squid container
 				var squidContainer *corev1.Container
@@ -303,20 +329,9 @@ var _ = Describe("Squid Helm Chart Deployment", func() {
 		)
 
 		BeforeEach(func() {
-			// Get the pod's IP address for cross-pod communication
-			podIP, err := getPodIP()
-			Expect(err).NotTo(HaveOccurred(), "Failed to get pod IP")
-
-			// Get test server port from environment, fallback to 0 (random port)
-			testPort := 0
-			if testPortStr := os.Getenv("TEST_SERVER_PORT"); testPortStr != "" {
-				if port, parseErr := strconv.Atoi(testPortStr); parseErr == nil {
-					testPort = port
-				}
-			}
-
 			// Create test server using helpers
-			testServer, err = testhelpers.NewProxyTestServer("Hello from test server", podIP, testPort)
+			var err error
+			testServer, err = newTestServer("Hello from test server")
 			Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
 
 			// Create HTTP client configured for Squid proxy using helpers
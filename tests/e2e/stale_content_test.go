@@ -0,0 +1,134 @@
+package e2e_test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Stale Content", func() {
+	const (
+		// Objects expire quickly so that specs can wait for them to go stale
+		maxAge = 2
+		// Long enough for the objects to go stale, short enough for them to
+		// stay within any stale-if-error period below
+		staleWait = 2*maxAge*time.Second + time.Second
+	)
+
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+	)
+
+	BeforeEach(func() {
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+
+		testServer, err = newTestServer("Hello from stale content test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+		// The origin fails from its second request on
+		testServer.FailAfter(1)
+
+		// Revalidate on the replica holding the object
+		client, err = testhelpers.NewProxyClient(pods[0].Status.PodIP + ":3128")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// cacheStaleObject caches an object expiring after maxAge with the given
+	// extra Cache-Control directives, waits for it to go stale and returns
+	// its URL and the origin's response
+	cacheStaleObject := func(path, directives string) (string, *testhelpers.TestServerResponse) {
+		cacheControl := "public, max-age=" + strconv.Itoa(maxAge) + directives
+		testURL := testServer.URL + path + "?cache-control=" + url.QueryEscape(cacheControl) + "&" +
+			generateCacheBuster("stale-content")
+
+		resp, body, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		original, err := testhelpers.ParseTestServerResponse(body)
+		Expect(err).NotTo(HaveOccurred())
+
+		time.Sleep(staleWait)
+		return testURL, original
+	}
+
+	// expectStale checks that resp serves the original, now stale, object
+	expectStale := func(resp *http.Response, body []byte, original *testhelpers.TestServerResponse) {
+		Expect(resp.StatusCode).To(Equal(http.StatusOK), "The stale copy should be served instead of the error")
+		response, err := testhelpers.ParseTestServerResponse(body)
+		Expect(err).NotTo(HaveOccurred())
+		testhelpers.ValidateCacheHit(original, response, original.RequestID)
+
+		// An Age beyond max-age tells clients that the response is stale
+		age, err := strconv.Atoi(resp.Header.Get("Age"))
+		Expect(err).NotTo(HaveOccurred(), "A cached response should carry an Age header")
+		Expect(age).To(BeNumerically(">", maxAge))
+		// Caches that still add the obsoleted Warning header use 110
+		// (Response is stale) or 111 (Revalidation failed)
+		if warning := resp.Header.Get("Warning"); warning != "" {
+			Expect(warning).To(MatchRegexp(`^11[01] `))
+		}
+	}
+
+	It("should serve the stale copy when the origin answers with an error", func() {
+		testURL, original := cacheStaleObject("/stale-content/error/", "")
+
+		resp, body, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		expectStale(resp, body, original)
+		Expect(testServer.GetRequestCount()).To(Equal(int32(2)), "squid should have tried to revalidate")
+	})
+
+	It("should serve the stale copy when the origin is unreachable", func() {
+		testURL, original := cacheStaleObject("/stale-content/unreachable/", "")
+		testServer.Close()
+
+		resp, body, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		expectStale(resp, body, original)
+	})
+
+	It("should serve the stale copy within the stale-if-error period", func() {
+		testURL, original := cacheStaleObject("/stale-content/stale-if-error/", ", stale-if-error=600")
+
+		resp, body, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		expectStale(resp, body, original)
+	})
+
+	It("should pass the error on once the stale-if-error period is over", func() {
+		testURL, _ := cacheStaleObject("/stale-content/stale-if-error-expired/", ", stale-if-error=1")
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should pass the error on for URLs whose refresh pattern allows no staleness", func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		if !strings.Contains(configMap.Data["squid.conf"], "/stale-content/no-stale/ 0 0% 0 max-stale=0") {
+			Skip("no refresh pattern with max-stale=0 for /stale-content/no-stale/ (see tests/e2e/values.yaml)")
+		}
+
+		testURL, _ := cacheStaleObject("/stale-content/no-stale/", "")
+
+		resp, _, err := testhelpers.MakeProxyRequest(client, testURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+})
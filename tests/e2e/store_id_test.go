@@ -0,0 +1,112 @@
+package e2e_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	randomBytes := make([]byte, n)
+	_, err := rand.Read(randomBytes)
+	Expect(err).NotTo(HaveOccurred(), "Failed to generate random bytes")
+	return hex.EncodeToString(randomBytes)
+}
+
+var _ = Describe("Store-ID Rewriting", func() {
+	var (
+		testServer *testhelpers.ProxyTestServer
+		client     *http.Client
+		rules      string
+	)
+
+	BeforeEach(func() {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "squid-config", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid-config ConfigMap")
+		if _, ok := configMap.Data["store-id-rules.yaml"]; !ok {
+			Skip("store-ID rewriting is not enabled (storeId.enabled=false)")
+		}
+		rules = configMap.Data["store-id-rules.yaml"]
+		Expect(configMap.Data["squid.conf"]).To(ContainSubstring("store_id_program /usr/local/bin/store-id-helper"))
+
+		testServer, err = newTestServer("Hello from store-ID test server")
+		Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
+
+		// Sibling queries are keyed by URL rather than store ID, so talk to a
+		// single replica to observe the rewriting itself
+		pods, err := readySquidPods()
+		Expect(err).NotTo(HaveOccurred(), "Failed to list squid pods")
+		Expect(pods).NotTo(BeEmpty(), "No ready squid pods found")
+		client, err = testhelpers.NewProxyClient(fmt.Sprintf("%s:%d", pods[0].Status.PodIP, 3128))
+		Expect(err).NotTo(HaveOccurred(), "Failed to create proxy client")
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	// expectSingleOriginFetch fetches every URL in turn and verifies that only
+	// the first one reached the origin
+	expectSingleOriginFetch := func(urls ...string) {
+		var firstBody []byte
+		for i, url := range urls {
+			By(fmt.Sprintf("Fetching %s", url))
+			resp, body, err := testhelpers.MakeProxyRequest(client, url)
+			Expect(err).NotTo(HaveOccurred(), "Request should succeed")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			if i == 0 {
+				firstBody = body
+				continue
+			}
+			Expect(string(body)).To(Equal(string(firstBody)), "Response should be served from the shared cache entry")
+		}
+		Expect(testServer.GetRequestCount()).To(Equal(int32(1)),
+			"Origin should have been fetched once for all URLs sharing a store ID")
+	}
+
+	It("should cache a digest-addressed blob once across repositories", func() {
+		digest := "sha256:" + randomHex(32)
+
+		// Every URL carries a query string so that mirrord steals it too
+		expectSingleOriginFetch(
+			testServer.URL+"/v2/org/repo-a/blobs/"+digest+"?pull=1",
+			testServer.URL+"/v2/org/repo-b/blobs/"+digest+"?pull=2",
+			testServer.URL+"/v2/mirror/org/repo-a/blobs/"+digest+"?ns=registry.example.com",
+		)
+	})
+
+	It("should not merge blobs with different digests", func() {
+		resp1, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"/v2/org/repo/blobs/sha256:"+randomHex(32)+"?pull=1")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp1.Body.Close()
+		resp2, _, err := testhelpers.MakeProxyRequest(client, testServer.URL+"/v2/org/repo/blobs/sha256:"+randomHex(32)+"?pull=1")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp2.Body.Close()
+
+		Expect(testServer.GetRequestCount()).To(Equal(int32(2)), "Different digests should be fetched separately")
+	})
+
+	It("should cache a query-signed URL once regardless of its signature", func() {
+		if !strings.Contains(rules, "name: e2e-signed") {
+			Skip("the e2e-signed store-ID rule from tests/e2e/values.yaml is not deployed")
+		}
+		object := testServer.URL + "/signed/" + randomHex(8) + "/artifact.tar.gz"
+
+		expectSingleOriginFetch(
+			object+"?expires=1700000000&signature=first",
+			object+"?expires=1700000600&signature=second",
+		)
+	})
+})
@@ -0,0 +1,39 @@
+// forwardproxy runs testhelpers.ForwardProxy as the upstream proxy squid
+// chains to in the e2e deployment (see parentProxies in tests/e2e/values.yaml).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+func main() {
+	port := flag.Int("port", 3129, "Port to listen on")
+	originDomain := flag.String("origin-domain", "parent-routed.test",
+		"Domain whose hosts resolve to the IP address spelled by their first label, e.g. 10-244-0-7.parent-routed.test")
+	flag.Parse()
+
+	// Credentials come from the environment, i.e. from a Secret
+	config := testhelpers.ForwardProxyConfig{
+		Port:         *port,
+		Username:     os.Getenv("PROXY_USERNAME"),
+		Password:     os.Getenv("PROXY_PASSWORD"),
+		OriginDomain: *originDomain,
+	}
+
+	fmt.Printf("🚀 Starting forward proxy on port %d...\n", config.Port)
+	proxy, err := testhelpers.NewForwardProxy(config)
+	if err != nil {
+		fmt.Printf("❌ Failed to create forward proxy: %v\n", err)
+		os.Exit(1)
+	}
+	defer proxy.Close()
+
+	fmt.Printf("✅ Forward proxy listening on %s\n", proxy.Listener.Addr())
+
+	// Keep the proxy running
+	select {}
+}
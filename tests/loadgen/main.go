@@ -0,0 +1,106 @@
+// loadgen drives the request mixes of tests/bench through a squid replica
+// against a test origin it serves itself, and prints the report as JSON on
+// its last line of output. mage test:bench runs it in the cluster.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/bench"
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+func main() {
+	proxy := flag.String("proxy", "", "Address of the squid replica, e.g. 10.244.0.12:3128")
+	var names []string
+	for _, mix := range bench.Mixes {
+		names = append(names, mix.Name)
+	}
+	mixes := flag.String("mixes", strings.Join(names, ","), "Comma-separated request mixes to run")
+	duration := flag.Duration("duration", 30*time.Second, "How long every mix is measured")
+	warmup := flag.Duration("warmup", 10*time.Second, "How long every mix runs before it is measured")
+	concurrency := flag.Int("concurrency", 16, "Requests kept in flight")
+	port := flag.Int("port", 0, "Port the test origin listens on, random if 0")
+	flag.Parse()
+
+	if *proxy == "" {
+		fail("-proxy is required")
+	}
+	var selected []bench.Mix
+	for _, name := range strings.Split(*mixes, ",") {
+		mix, ok := bench.LookupMix(strings.TrimSpace(name))
+		if !ok {
+			fail("unknown mix %q, choose among %s", name, strings.Join(names, ", "))
+		}
+		selected = append(selected, mix)
+	}
+
+	ip, err := podIP()
+	if err != nil {
+		fail("%v", err)
+	}
+	origin, err := testhelpers.NewProxyTestServer("Hello from the load generator", ip, *port)
+	if err != nil {
+		fail("failed to create the test origin: %v", err)
+	}
+	defer origin.Close()
+	client, err := testhelpers.NewProxyClient(*proxy)
+	if err != nil {
+		fail("failed to create the proxy client: %v", err)
+	}
+	// Measure squid rather than connection setup: keep a connection to it
+	// per worker, like long-running clients do
+	transport := client.Transport.(*http.Transport)
+	transport.DisableKeepAlives = false
+	transport.MaxIdleConnsPerHost = *concurrency
+
+	options := bench.Options{Duration: *duration, Warmup: *warmup, Concurrency: *concurrency}
+	report := &bench.Report{}
+	for _, mix := range selected {
+		fmt.Printf("🚀 Running %s through %s for %s after a %s warmup...\n", mix.Name, *proxy, *duration, *warmup)
+		result, err := bench.Run(context.Background(), client, origin, mix, options)
+		if err != nil {
+			fail("%s: %v", mix.Name, err)
+		}
+		fmt.Printf("   %.0f req/s, p50 %.1f ms, p95 %.1f ms, p99 %.1f ms, %.2f%% errors, %.1f%% origin offload\n",
+			result.RPS, result.LatencyP50Ms, result.LatencyP95Ms, result.LatencyP99Ms, result.ErrorRate*100, result.OriginOffload*100)
+		report.Results = append(report.Results, result)
+	}
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		fail("%v", err)
+	}
+	fmt.Println(string(out))
+}
+
+// podIP returns POD_IP when set, otherwise the first non-loopback address of
+// the pod
+func podIP() (string, error) {
+	if ip := os.Getenv("POD_IP"); ip != "" {
+		return ip, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no pod IP address found, set POD_IP")
+}
+
+func fail(format string, args ...any) {
+	fmt.Printf("❌ "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,114 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"testing"
+)
+
+// dashboardFile is the dashboard shipped in the chart's Grafana ConfigMap
+const dashboardFile = "../../squid/dashboards/squid.json"
+
+// grafanaIntervals are the Grafana range variables, which are not valid
+// PromQL until Grafana substitutes them
+var grafanaIntervals = regexp.MustCompile(`\$__(rate_interval|interval|range)`)
+
+// labelValuesQuery matches Grafana's label_values(<selector>, <label>)
+// template variable query
+var labelValuesQuery = regexp.MustCompile(`^label_values\((.+),\s*\w+\)$`)
+
+type dashboard struct {
+	Panels     []panel `json:"panels"`
+	Templating struct {
+		List []variable `json:"list"`
+	} `json:"templating"`
+}
+
+type panel struct {
+	Title   string `json:"title"`
+	Targets []struct {
+		Expr string `json:"expr"`
+	} `json:"targets"`
+	Panels []panel `json:"panels"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Query any    `json:"query"`
+}
+
+// expressions returns the PromQL expressions of the panel and any panels
+// nested in it, keyed by panel title
+func (p panel) expressions() map[string][]string {
+	exprs := map[string][]string{}
+	for _, target := range p.Targets {
+		exprs[p.Title] = append(exprs[p.Title], target.Expr)
+	}
+	for _, nested := range p.Panels {
+		for title, nestedExprs := range nested.expressions() {
+			exprs[title] = append(exprs[title], nestedExprs...)
+		}
+	}
+	return exprs
+}
+
+func TestDashboardMetrics(t *testing.T) {
+	data, err := os.ReadFile(dashboardFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d dashboard
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("parsing %s: %v", dashboardFile, err)
+	}
+
+	exprs := map[string][]string{}
+	for _, p := range d.Panels {
+		for title, panelExprs := range p.expressions() {
+			exprs["panel "+title] = append(exprs["panel "+title], panelExprs...)
+		}
+	}
+	for _, v := range d.Templating.List {
+		if v.Type != "query" {
+			continue
+		}
+		query, _ := v.Query.(string)
+		if q, ok := v.Query.(map[string]any); ok {
+			query, _ = q["query"].(string)
+		}
+		match := labelValuesQuery.FindStringSubmatch(query)
+		if match == nil {
+			t.Errorf("variable %s: query %q is not label_values(<selector>, <label>)", v.Name, query)
+			continue
+		}
+		exprs["variable "+v.Name] = append(exprs["variable "+v.Name], match[1])
+	}
+	if len(exprs) == 0 {
+		t.Fatalf("%s has no queries", dashboardFile)
+	}
+
+	known := exporterMetrics()
+	for source, sourceExprs := range exprs {
+		for _, expr := range sourceExprs {
+			if expr == "" {
+				t.Errorf("%s: empty expression", source)
+				continue
+			}
+			names, err := metricNames(grafanaIntervals.ReplaceAllString(expr, "5m"))
+			if err != nil {
+				t.Errorf("%s: %q: %v", source, expr, err)
+				continue
+			}
+			if len(names) == 0 {
+				t.Errorf("%s: %q selects no metrics", source, expr)
+			}
+			for _, name := range names {
+				if !known[name] {
+					t.Errorf("%s: %s is not emitted by squid-exporter", source, name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// The tables below mirror the metric definitions of squid-exporter
+// (collector/counters.go, collector/infos.go and collector/service_times.go)
+// and must be kept in sync when the exporter image is bumped.
+
+var exporterCounters = []string{
+	"client_http_requests_total",
+	"client_http_hits_total",
+	"client_http_errors_total",
+	"client_http_kbytes_in_kbytes_total",
+	"client_http_kbytes_out_kbytes_total",
+	"client_http_hit_kbytes_out_bytes_total",
+	"swap_ins_total",
+	"swap_outs_total",
+	"swap_files_cleaned_total",
+}
+
+// exporterServerSections each export requests, errors, kbytes_in and
+// kbytes_out counters
+var exporterServerSections = []string{"http", "all", "ftp", "other"}
+
+// exporterInfos are exported as squid_info_<section>, with "%" replaced by
+// "pct"
+var exporterInfos = []string{
+	"Number_of_clients_accessing_cache",
+	"Number_of_HTTP_requests_received",
+	"Number_of_ICP_messages_received",
+	"Number_of_ICP_messages_sent",
+	"Number_of_queued_ICP_replies",
+	"Number_of_HTCP_messages_received",
+	"Number_of_HTCP_messages_sent",
+	"Request_failure_ratio",
+	"Average_HTTP_requests_per_minute_since_start",
+	"Average_ICP_messages_per_minute_since_start",
+	"Select_loop_called",
+	"Hits_as_%_of_all_requests_5min",
+	"Hits_as_%_of_bytes_sent_5min",
+	"Memory_hits_as_%_of_hit_requests_5min",
+	"Disk_hits_as_%_of_hit_requests_5min",
+	"Hits_as_%_of_all_requests_60min",
+	"Hits_as_%_of_bytes_sent_60min",
+	"Memory_hits_as_%_of_hit_requests_60min",
+	"Disk_hits_as_%_of_hit_requests_60min",
+	"Storage_Swap_size",
+	"Storage_Swap_capacity",
+	"Storage_Mem_size",
+	"Storage_Mem_capacity",
+	"Mean_Object_Size",
+	"Requests_given_to_unlinkd",
+	"UP_Time",
+	"CPU_Time",
+	"CPU_Usage",
+	"CPU_Usage_5_minute_avg",
+	"CPU_Usage_60_minute_avg",
+	"Maximum_Resident_Size",
+	"Page_faults_with_physical_i_o",
+	"Total_accounted",
+	"memPoolAlloc_calls",
+	"memPoolFree_calls",
+	"Maximum_number_of_file_descriptors",
+	"Largest_file_desc_currently_in_use",
+	"Number_of_file_desc_currently_in_use",
+	"Files_queued_for_open",
+	"Available_number_of_file_descriptors",
+	"Reserved_number_of_file_descriptors",
+	"Store_Disk_files_open",
+	"StoreEntries",
+	"StoreEntries_with_MemObjects",
+	"Hot_Object_Cache_Items",
+	"on_disk_objects",
+	"service",
+}
+
+// exporterServiceTimes are exported as squid_<section>_<percentile> when
+// service time extraction is enabled; HTTP_Requests goes up to 100
+var exporterServiceTimes = []string{"HTTP_Requests_All", "Cache_Misses", "Cache_Hits", "Near_Hits", "DNS_Lookups"}
+
+// scrapeMetrics are added by Prometheus to every scrape
+var scrapeMetrics = []string{"up"}
+
+// exporterMetrics returns the set of metric names squid-exporter emits, plus
+// the ones Prometheus adds when scraping it
+func exporterMetrics() map[string]bool {
+	metrics := map[string]bool{"squid_up": true}
+	for _, name := range scrapeMetrics {
+		metrics[name] = true
+	}
+	for _, counter := range exporterCounters {
+		metrics["squid_"+counter] = true
+	}
+	for _, section := range exporterServerSections {
+		for _, counter := range []string{"requests_total", "errors_total", "kbytes_in_kbytes_total", "kbytes_out_kbytes_total"} {
+			metrics[fmt.Sprintf("squid_server_%s_%s", section, counter)] = true
+		}
+	}
+	for _, info := range exporterInfos {
+		metrics["squid_info_"+strings.ReplaceAll(info, "%", "pct")] = true
+	}
+	for _, section := range exporterServiceTimes {
+		last := 95
+		if section == "HTTP_Requests_All" {
+			last = 100
+		}
+		for percentile := 5; percentile <= last; percentile += 5 {
+			metrics[fmt.Sprintf("squid_%s_%d", section, percentile)] = true
+		}
+	}
+	return metrics
+}
+
+// metricNames returns the names of the metrics selected by a PromQL expression
+func metricNames(expr string) ([]string, error) {
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		selector, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		if selector.Name != "" {
+			names = append(names, selector.Name)
+			return nil
+		}
+		for _, matcher := range selector.LabelMatchers {
+			if matcher.Name == labels.MetricName && matcher.Type == labels.MatchEqual {
+				names = append(names, matcher.Value)
+			}
+		}
+		return nil
+	})
+	return names, nil
+}
@@ -0,0 +1,331 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/promql/promqltest"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+	"sigs.k8s.io/yaml"
+)
+
+// rulesFile is the rule file embedded into the chart's PrometheusRule
+const rulesFile = "../../squid/prometheus-rules.yaml"
+
+var recordingRuleName = regexp.MustCompile(`^squid:[a-z_]+(:[a-z0-9_]+)?$`)
+
+// unitTestFile is the subset of the promtool unit test format used by the
+// fixtures in testdata/
+type unitTestFile struct {
+	RuleFiles          []string       `json:"rule_files"`
+	EvaluationInterval model.Duration `json:"evaluation_interval"`
+	Tests              []testGroup    `json:"tests"`
+}
+
+type testGroup struct {
+	Name            string           `json:"name"`
+	Interval        model.Duration   `json:"interval"`
+	InputSeries     []series         `json:"input_series"`
+	AlertRuleTests  []alertTestCase  `json:"alert_rule_test"`
+	PromqlExprTests []promqlTestCase `json:"promql_expr_test"`
+}
+
+type series struct {
+	Series string `json:"series"`
+	Values string `json:"values"`
+}
+
+type alertTestCase struct {
+	EvalTime  model.Duration `json:"eval_time"`
+	Alertname string         `json:"alertname"`
+	ExpAlerts []alert        `json:"exp_alerts"`
+}
+
+type alert struct {
+	ExpLabels      map[string]string `json:"exp_labels"`
+	ExpAnnotations map[string]string `json:"exp_annotations"`
+}
+
+type promqlTestCase struct {
+	Expr       string         `json:"expr"`
+	EvalTime   model.Duration `json:"eval_time"`
+	ExpSamples []sample       `json:"exp_samples"`
+}
+
+type sample struct {
+	Labels string  `json:"labels"`
+	Value  float64 `json:"value"`
+}
+
+func TestRulesParse(t *testing.T) {
+	groups, errs := rulefmt.ParseFile(rulesFile, false)
+	if len(errs) > 0 {
+		t.Fatalf("parsing %s: %v", rulesFile, errs)
+	}
+	if len(groups.Groups) == 0 {
+		t.Fatalf("%s has no rule groups", rulesFile)
+	}
+
+	known := exporterMetrics()
+	for _, group := range groups.Groups {
+		for _, rule := range group.Rules {
+			if rule.Record != "" {
+				known[rule.Record] = true
+			}
+		}
+	}
+
+	for _, group := range groups.Groups {
+		for _, rule := range group.Rules {
+			names, err := metricNames(rule.Expr)
+			if err != nil {
+				t.Errorf("rule %s%s: %v", rule.Record, rule.Alert, err)
+			}
+			for _, name := range names {
+				if !known[name] {
+					t.Errorf("rule %s%s: %s is neither emitted by squid-exporter nor recorded by a rule", rule.Record, rule.Alert, name)
+				}
+			}
+
+			if rule.Record != "" {
+				if !recordingRuleName.MatchString(rule.Record) {
+					t.Errorf("recording rule %q does not follow the squid:<metric>:<operation> naming", rule.Record)
+				}
+				continue
+			}
+			if severity := rule.Labels["severity"]; severity != "warning" && severity != "critical" {
+				t.Errorf("alert %s: severity %q, want warning or critical", rule.Alert, severity)
+			}
+			for _, annotation := range []string{"summary", "description"} {
+				if rule.Annotations[annotation] == "" {
+					t.Errorf("alert %s: missing %s annotation", rule.Alert, annotation)
+				}
+			}
+		}
+	}
+}
+
+func TestRulesUnitTests(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no rule unit test fixtures in testdata/")
+	}
+
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file unitTestFile
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+		if file.EvaluationInterval == 0 {
+			file.EvaluationInterval = model.Duration(time.Minute)
+		}
+		ruleFiles := make([]string, len(file.RuleFiles))
+		for i, ruleFile := range file.RuleFiles {
+			ruleFiles[i] = filepath.Join(filepath.Dir(fixture), ruleFile)
+		}
+
+		for _, group := range file.Tests {
+			t.Run(filepath.Base(fixture)+"/"+group.Name, func(t *testing.T) {
+				runTestGroup(t, group, time.Duration(file.EvaluationInterval), ruleFiles)
+			})
+		}
+	}
+}
+
+// runTestGroup evaluates the rule files over the input series of a test group
+// the way promtool does, checking alerts as their eval_time is reached and
+// expressions once all evaluations are done
+func runTestGroup(t *testing.T, group testGroup, evalInterval time.Duration, ruleFiles []string) {
+	if group.Interval == 0 {
+		group.Interval = model.Duration(evalInterval)
+	}
+	load := fmt.Sprintf("load %s\n", group.Interval)
+	for _, s := range group.InputSeries {
+		load += fmt.Sprintf("  %s %s\n", s.Series, s.Values)
+	}
+	store := promqltest.LoadedStorage(t, load)
+	t.Cleanup(func() { store.Close() })
+	engine := promqltest.NewTestEngine(t, false, 0, promqltest.DefaultMaxSamplesPerQuery)
+
+	ctx := context.Background()
+	manager := rules.NewManager(&rules.ManagerOptions{
+		QueryFunc:  rules.EngineQueryFunc(engine, store),
+		Appendable: store,
+		Context:    ctx,
+		NotifyFunc: func(context.Context, string, ...*rules.Alert) {},
+		Logger:     promslog.NewNopLogger(),
+	})
+	loaded, errs := manager.LoadGroups(evalInterval, labels.EmptyLabels(), "", nil, false, ruleFiles...)
+	if len(errs) > 0 {
+		t.Fatalf("loading rules: %v", errs)
+	}
+	groups := orderedGroups(t, loaded, ruleFiles)
+	for _, g := range groups {
+		for _, rule := range g.Rules() {
+			if alertingRule, ok := rule.(*rules.AlertingRule); ok {
+				alertingRule.SetRestored(true)
+			}
+		}
+	}
+
+	alertTests := slices.Clone(group.AlertRuleTests)
+	sort.SliceStable(alertTests, func(i, j int) bool { return alertTests[i].EvalTime < alertTests[j].EvalTime })
+	var maxEvalTime time.Duration
+	for _, tc := range alertTests {
+		maxEvalTime = max(maxEvalTime, time.Duration(tc.EvalTime))
+	}
+	for _, tc := range group.PromqlExprTests {
+		maxEvalTime = max(maxEvalTime, time.Duration(tc.EvalTime))
+	}
+
+	start := time.Unix(0, 0).UTC()
+	next := 0
+	for offset := time.Duration(0); offset <= maxEvalTime; offset += evalInterval {
+		for _, g := range groups {
+			g.Eval(ctx, start.Add(offset))
+			for _, rule := range g.Rules() {
+				if err := rule.LastError(); err != nil {
+					t.Fatalf("rule %s at %s: %v", rule.Name(), offset, err)
+				}
+			}
+		}
+		for ; next < len(alertTests) && time.Duration(alertTests[next].EvalTime) < offset+evalInterval; next++ {
+			checkAlerts(t, alertTests[next], groups)
+		}
+	}
+
+	for _, tc := range group.PromqlExprTests {
+		checkExpr(t, ctx, engine, store, tc, start.Add(time.Duration(tc.EvalTime)))
+	}
+}
+
+// orderedGroups returns the loaded groups in the order they appear in the
+// rule files, so recording rules are evaluated before the alerts using them
+func orderedGroups(t *testing.T, loaded map[string]*rules.Group, ruleFiles []string) []*rules.Group {
+	var groups []*rules.Group
+	for _, file := range ruleFiles {
+		parsed, errs := rulefmt.ParseFile(file, false)
+		if len(errs) > 0 {
+			t.Fatalf("parsing %s: %v", file, errs)
+		}
+		for _, g := range parsed.Groups {
+			groups = append(groups, loaded[rules.GroupKey(file, g.Name)])
+		}
+	}
+	return groups
+}
+
+func checkAlerts(t *testing.T, tc alertTestCase, groups []*rules.Group) {
+	t.Helper()
+
+	var got []string
+	found := false
+	for _, g := range groups {
+		for _, rule := range g.Rules() {
+			alertingRule, ok := rule.(*rules.AlertingRule)
+			if !ok || alertingRule.Name() != tc.Alertname {
+				continue
+			}
+			found = true
+			for _, a := range alertingRule.ActiveAlerts() {
+				if a.State == rules.StateFiring {
+					got = append(got, a.Labels.String()+" "+a.Annotations.String())
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("alert %s: no such alerting rule", tc.Alertname)
+		return
+	}
+
+	var want []string
+	for _, a := range tc.ExpAlerts {
+		expLabels := map[string]string{labels.AlertName: tc.Alertname}
+		for name, value := range a.ExpLabels {
+			expLabels[name] = value
+		}
+		want = append(want, labels.FromMap(expLabels).String()+" "+labels.FromMap(a.ExpAnnotations).String())
+	}
+
+	sort.Strings(got)
+	sort.Strings(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("alert %s at %s:\n  got:  %s\n  want: %s", tc.Alertname, tc.EvalTime,
+			strings.Join(got, "\n        "), strings.Join(want, "\n        "))
+	}
+}
+
+func checkExpr(t *testing.T, ctx context.Context, engine *promql.Engine, queryable storage.Queryable, tc promqlTestCase, ts time.Time) {
+	t.Helper()
+
+	query, err := engine.NewInstantQuery(ctx, queryable, nil, tc.Expr, ts)
+	if err != nil {
+		t.Errorf("expr %q: %v", tc.Expr, err)
+		return
+	}
+	defer query.Close()
+	result := query.Exec(ctx)
+	if result.Err != nil {
+		t.Errorf("expr %q at %s: %v", tc.Expr, tc.EvalTime, result.Err)
+		return
+	}
+	vector, err := result.Vector()
+	if err != nil {
+		t.Errorf("expr %q at %s: %v", tc.Expr, tc.EvalTime, err)
+		return
+	}
+
+	got := map[string]float64{}
+	for _, s := range vector {
+		got[s.Metric.String()] = s.F
+	}
+	want := map[string]float64{}
+	for _, s := range tc.ExpSamples {
+		metric, err := parser.ParseMetric(s.Labels)
+		if err != nil {
+			t.Errorf("expr %q: parsing expected labels %q: %v", tc.Expr, s.Labels, err)
+			return
+		}
+		want[metric.String()] = s.Value
+	}
+
+	for metric, value := range want {
+		gotValue, ok := got[metric]
+		if !ok {
+			t.Errorf("expr %q at %s: missing sample %s", tc.Expr, tc.EvalTime, metric)
+			continue
+		}
+		if math.Abs(gotValue-value) > 1e-9*math.Max(1, math.Abs(value)) {
+			t.Errorf("expr %q at %s: %s = %g, want %g", tc.Expr, tc.EvalTime, metric, gotValue, value)
+		}
+	}
+	for metric, value := range got {
+		if _, ok := want[metric]; !ok {
+			t.Errorf("expr %q at %s: unexpected sample %s = %g", tc.Expr, tc.EvalTime, metric, value)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+// Package replay replays a request trace captured from squid's access log
+// through a deployed proxy, against an origin that synthesizes the traced
+// responses, and compares the cache outcome of every URL with a baseline. It
+// shows what a configuration change, such as a new refresh_pattern, does to
+// real traffic before it is rolled out.
+//
+// The origin is a ProxyTestServer scripted with a route per traced path.
+// Traced URLs are rewritten to <origin>/replay/<run>/<host><path>?<query>,
+// where run is random so that every replay starts with a cold cache. Hence
+// refresh_pattern regexes anchored on a host have to match the rewritten
+// path, e.g. /replay/[^/]+/registry\.example\.com/v2/ rather than
+// ^https://registry\.example\.com/v2/. Unanchored ones apply as they are.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/accesslog"
+	"github.com/konflux-ci/caching/internal/analytics"
+	"github.com/konflux-ci/caching/internal/prewarm"
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+// PathPrefix starts the path of every replayed URL, so that the requests can
+// be told apart from the other test traffic
+const PathPrefix = "/replay/"
+
+// Request is a request of a captured trace
+type Request struct {
+	// Offset is the time since the first request of the trace
+	Offset   time.Duration
+	Method   string
+	URL      string
+	Status   int
+	Bytes    int64
+	MimeType string
+}
+
+// Trace is a sequence of requests, ordered by time
+type Trace []Request
+
+// Duration is the time between the first and the last request
+func (t Trace) Duration() time.Duration {
+	if len(t) == 0 {
+		return 0
+	}
+	return t[len(t)-1].Offset
+}
+
+// ReadTrace parses an access log in squid's native format or as JSON.
+// Lines that are not access log entries, CONNECT tunnels, which squid does not
+// cache, and denied requests, which never reach an origin, are skipped.
+func ReadTrace(r io.Reader) (Trace, error) {
+	var entries []accesslog.Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := accesslog.ParseLine(scanner.Text())
+		if err != nil || entry.Method == http.MethodConnect || analytics.Classify(entry.ResultCode) == analytics.ResultDenied {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the trace: %w", err)
+	}
+
+	// Replicas log into separate streams, which are concatenated
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	trace := make(Trace, 0, len(entries))
+	for _, entry := range entries {
+		trace = append(trace, Request{
+			Offset:   entry.Time.Sub(entries[0].Time),
+			Method:   entry.Method,
+			URL:      entry.URL,
+			Status:   entry.Status,
+			Bytes:    entry.Bytes,
+			MimeType: entry.MimeType,
+		})
+	}
+	return trace, nil
+}
+
+// Options configure a replay
+type Options struct {
+	// Speed divides the time between requests: 1 keeps the original timing,
+	// 60 replays an hour of traffic in a minute and 0 sends every request as
+	// soon as one of Concurrency is free. squid's clock does not speed up,
+	// so objects stay fresh for more of the trace the faster it is replayed:
+	// only compare reports replayed at the same speed.
+	Speed float64
+	// Concurrency bounds the requests in flight, 32 by default
+	Concurrency int
+	// ContentAge is how long before its first request an object was last
+	// modified, which the percent of refresh_pattern is applied to. 24
+	// hours by default.
+	ContentAge time.Duration
+	// Header is added to every origin response, e.g. a Cache-Control
+	// header to replay origins that send one
+	Header http.Header
+}
+
+// Replay is a trace scripted on an origin, ready to be replayed
+type Replay struct {
+	trace   Trace
+	options Options
+	run     string
+	// objects and versions are indexed like trace
+	objects  []*object
+	versions []int
+	byURL    map[string]*object
+}
+
+// object is a traced URL served by the origin
+type object struct {
+	url     string
+	replay  string
+	header  http.Header
+	current atomic.Int32
+
+	mu       sync.Mutex
+	versions []version
+	lastSent time.Time
+	// The origin requests, and those of them that were revalidations
+	originRequests int
+	revalidations  int
+}
+
+// version is the content of an object from one of its requests on, until
+// it changes again
+type version struct {
+	status       int
+	size         int64
+	mimeType     string
+	lastModified time.Time
+}
+
+// New scripts the responses of trace on origin
+func New(trace Trace, origin *testhelpers.ProxyTestServer, options Options) (*Replay, error) {
+	if options.Concurrency < 1 {
+		options.Concurrency = 32
+	}
+	if options.ContentAge == 0 {
+		options.ContentAge = 24 * time.Hour
+	}
+	run := make([]byte, 8)
+	if _, err := rand.Read(run); err != nil {
+		return nil, err
+	}
+	replay := &Replay{trace: trace, options: options, run: hex.EncodeToString(run), byURL: map[string]*object{}}
+
+	paths := map[string]map[string]*object{}
+	for _, request := range trace {
+		replayURL, err := rewrite(origin.URL+PathPrefix+replay.run+"/", request.URL)
+		if err != nil {
+			return nil, err
+		}
+		obj := replay.byURL[request.URL]
+		if obj == nil {
+			obj = &object{url: request.URL, replay: replayURL.String(), header: options.Header}
+			replay.byURL[request.URL] = obj
+			if paths[replayURL.Path] == nil {
+				paths[replayURL.Path] = map[string]*object{}
+			}
+			paths[replayURL.Path][replayURL.RawQuery] = obj
+		}
+		replay.objects = append(replay.objects, obj)
+		replay.versions = append(replay.versions, obj.observe(request))
+	}
+
+	for path, byQuery := range paths {
+		origin.Route(path, func(r *http.Request) testhelpers.RouteResponse {
+			obj := byQuery[r.URL.RawQuery]
+			if obj == nil {
+				return testhelpers.RouteResponse{Status: http.StatusNotFound}
+			}
+			return obj.respond(r)
+		})
+	}
+	return replay, nil
+}
+
+// rewrite maps a traced URL below base
+func rewrite(base, traced string) (*url.URL, error) {
+	u, err := url.Parse(traced)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q in the trace", traced)
+	}
+	replayURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	prefix := replayURL.Path
+	replayURL.Path = prefix + u.Host + u.Path
+	if u.RawPath != "" {
+		replayURL.RawPath = prefix + u.Host + u.RawPath
+	}
+	replayURL.RawQuery = u.RawQuery
+	return replayURL, nil
+}
+
+// observe adds a traced request of the object and returns the version it got.
+// A different status or size means the object changed at the origin. A 304
+// Not Modified went to a client revalidating its own copy and a 206 Partial
+// Content to a range request, which say nothing of the size.
+func (o *object) observe(request Request) int {
+	status, size := request.Status, request.Bytes
+	switch status {
+	case http.StatusNotModified, http.StatusPartialContent:
+		if len(o.versions) > 0 {
+			return len(o.versions) - 1
+		}
+		if status == http.StatusNotModified {
+			size = 0
+		}
+		status = http.StatusOK
+	}
+	if n := len(o.versions); n > 0 && o.versions[n-1].status == status && o.versions[n-1].size == size {
+		return n - 1
+	}
+	o.versions = append(o.versions, version{status: status, size: size, mimeType: request.MimeType})
+	return len(o.versions) - 1
+}
+
+// send makes v the version the origin serves, as of now
+func (o *object) send(v int, now time.Time, contentAge time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.versions[v].lastModified.IsZero() {
+		if o.lastSent.IsZero() {
+			o.versions[v].lastModified = now.Add(-contentAge)
+		} else {
+			// The object changed at some point since it was last requested
+			o.versions[v].lastModified = o.lastSent.Add(now.Sub(o.lastSent) / 2)
+		}
+	}
+	o.lastSent = now
+	o.current.Store(int32(v))
+}
+
+func (o *object) respond(r *http.Request) testhelpers.RouteResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.originRequests++
+	if r.Header.Get("If-Modified-Since") != "" || r.Header.Get("If-None-Match") != "" {
+		o.revalidations++
+	}
+
+	v := int(o.current.Load())
+	current := o.versions[v]
+	header := http.Header{"Etag": {`"` + strconv.Itoa(v) + `"`}}
+	for name, values := range o.header {
+		header[name] = values
+	}
+	if current.mimeType != "" && current.mimeType != "-" {
+		header.Set("Content-Type", current.mimeType)
+	}
+	return testhelpers.RouteResponse{
+		Status:       current.status,
+		Header:       header,
+		Body:         testhelpers.SizedBody(current.size),
+		LastModified: current.lastModified,
+	}
+}
+
+// URL returns the URL a traced URL is replayed as
+func (r *Replay) URL(traced string) string {
+	if obj := r.byURL[traced]; obj != nil {
+		return obj.replay
+	}
+	return ""
+}
+
+// Run sends the requests of the trace through client, which must be
+// configured to use the proxy, and reports their cache outcomes. Redirects
+// are not followed. Run only fails if ctx is done before every request was
+// sent.
+func (r *Replay) Run(ctx context.Context, client *http.Client) (*Report, error) {
+	replayClient := *client
+	replayClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	statuses := make([]string, len(r.trace))
+	slots := make(chan struct{}, r.options.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	var err error
+	for i, request := range r.trace {
+		if r.options.Speed > 0 {
+			if wait := time.Until(start.Add(time.Duration(float64(request.Offset) / r.options.Speed))); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		obj := r.objects[i]
+		obj.send(r.versions[i], time.Now(), r.options.ContentAge)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			statuses[i] = fetch(ctx, &replayClient, request.Method, obj.replay)
+		}()
+	}
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return r.report(statuses), nil
+}
+
+// fetch sends a request and returns its cache status
+func fetch(ctx context.Context, client *http.Client, method, url string) string {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return prewarm.StatusError
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return prewarm.StatusError
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return prewarm.StatusError
+	}
+	// The origin's error statuses are part of the trace, squid's own error
+	// pages are not
+	if resp.Header.Get("X-Squid-Error") != "" {
+		return prewarm.StatusError
+	}
+	return prewarm.CacheStatus(resp)
+}
+
+// Outcome is how the requests for a URL were served
+type Outcome struct {
+	Requests int `json:"requests"`
+	Hits     int `json:"hits"`
+	Errors   int `json:"errors"`
+	// Sequence has a letter per request in trace order: H for a hit, M for
+	// a miss and E for an error
+	Sequence string `json:"sequence"`
+	// OriginRequests counts the requests that reached the origin, including
+	// revalidations
+	OriginRequests int `json:"originRequests"`
+	Revalidations  int `json:"revalidations"`
+}
+
+// Report is the outcome of a replay
+type Report struct {
+	Speed          float64            `json:"speed"`
+	Requests       int                `json:"requests"`
+	Hits           int                `json:"hits"`
+	Errors         int                `json:"errors"`
+	OriginRequests int                `json:"originRequests"`
+	URLs           map[string]Outcome `json:"urls"`
+}
+
+// HitRatio is the share of requests served from the cache
+func (r *Report) HitRatio() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Requests)
+}
+
+func (r *Replay) report(statuses []string) *Report {
+	report := &Report{Speed: r.options.Speed, URLs: map[string]Outcome{}}
+	for i, status := range statuses {
+		outcome := report.URLs[r.trace[i].URL]
+		outcome.Requests++
+		report.Requests++
+		switch status {
+		case prewarm.StatusHit:
+			outcome.Hits++
+			report.Hits++
+		case prewarm.StatusError:
+			outcome.Errors++
+			report.Errors++
+		}
+		outcome.Sequence += status[:1]
+		report.URLs[r.trace[i].URL] = outcome
+	}
+	for u, obj := range r.byURL {
+		outcome := report.URLs[u]
+		obj.mu.Lock()
+		outcome.OriginRequests, outcome.Revalidations = obj.originRequests, obj.revalidations
+		obj.mu.Unlock()
+		report.URLs[u] = outcome
+		report.OriginRequests += outcome.OriginRequests
+	}
+	return report
+}
+
+// WriteReport writes a report as JSON, to be read back as a baseline
+func WriteReport(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// ReadReport reads a report written by WriteReport
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to read the report: %w", err)
+	}
+	return &report, nil
+}
+
+// Change is a URL served differently than in the baseline. Baseline or
+// Current is nil if the URL is only in the other report.
+type Change struct {
+	URL      string
+	Baseline *Outcome
+	Current  *Outcome
+}
+
+func (c Change) String() string {
+	describe := func(outcome *Outcome) string {
+		if outcome == nil {
+			return "not replayed"
+		}
+		return fmt.Sprintf("%s, %d origin requests (%d revalidations)",
+			outcome.Sequence, outcome.OriginRequests, outcome.Revalidations)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.URL, describe(c.Baseline), describe(c.Current))
+}
+
+// Diff lists the URLs whose requests were served differently, or reached the
+// origin a different number of times, than in the baseline, sorted by URL
+func Diff(baseline, current *Report) []Change {
+	var changes []Change
+	for u, outcome := range baseline.URLs {
+		if other, ok := current.URLs[u]; !ok {
+			changes = append(changes, Change{URL: u, Baseline: &outcome})
+		} else if other != outcome {
+			changes = append(changes, Change{URL: u, Baseline: &outcome, Current: &other})
+		}
+	}
+	for u, outcome := range current.URLs {
+		if _, ok := baseline.URLs[u]; !ok {
+			changes = append(changes, Change{URL: u, Current: &outcome})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].URL < changes[j].URL
+	})
+	return changes
+}
+
+// Summarize describes a diff in a line, e.g. for a failure message
+func Summarize(baseline, current *Report, changes []Change) string {
+	return fmt.Sprintf("hit ratio %.1f%% -> %.1f%%, origin requests %d -> %d, %d URLs changed",
+		baseline.HitRatio()*100, current.HitRatio()*100, baseline.OriginRequests, current.OriginRequests, len(changes))
+}
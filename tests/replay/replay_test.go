@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+const accessLog = `1700000001.500      3 10.0.0.1 TCP_MISS/200 1000 GET http://example.com/a - HIER_DIRECT/1.2.3.4 application/octet-stream
+1700000000.000      5 10.0.0.1 TCP_MISS/200 1000 GET http://example.com/a - HIER_DIRECT/1.2.3.4 application/octet-stream
+2024/01/01 00:00:00 kid1| Starting Squid Cache
+1700000001.000      1 10.0.0.2 TCP_TUNNEL/200 4000 CONNECT registry.example.com:443 - HIER_DIRECT/5.6.7.8 -
+1700000001.000      0 10.0.0.2 TCP_DENIED/403 3000 GET http://blocked.example.com/ - HIER_NONE/- text/html
+1700000002.000      4 10.0.0.1 TCP_MISS/404 10 GET http://example.com/missing?x=1 - HIER_DIRECT/1.2.3.4 text/plain
+1700000003.000      2 10.0.0.1 TCP_REFRESH_MODIFIED/200 1200 GET http://example.com/a - HIER_DIRECT/1.2.3.4 application/octet-stream
+`
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader(accessLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Trace{
+		{Offset: 0, Method: "GET", URL: "http://example.com/a", Status: 200, Bytes: 1000, MimeType: "application/octet-stream"},
+		{Offset: 1500 * time.Millisecond, Method: "GET", URL: "http://example.com/a", Status: 200, Bytes: 1000, MimeType: "application/octet-stream"},
+		{Offset: 2 * time.Second, Method: "GET", URL: "http://example.com/missing?x=1", Status: 404, Bytes: 10, MimeType: "text/plain"},
+		{Offset: 3 * time.Second, Method: "GET", URL: "http://example.com/a", Status: 200, Bytes: 1200, MimeType: "application/octet-stream"},
+	}
+	if len(trace) != len(want) {
+		t.Fatalf("ReadTrace() = %+v, want %+v", trace, want)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Errorf("ReadTrace()[%d] = %+v, want %+v", i, trace[i], want[i])
+		}
+	}
+	if trace.Duration() != 3*time.Second {
+		t.Errorf("Duration() = %s, want 3s", trace.Duration())
+	}
+}
+
+func TestRun(t *testing.T) {
+	origin, err := testhelpers.NewProxyTestServer("replay", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+
+	trace, err := ReadTrace(strings.NewReader(accessLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := New(trace, origin, Options{Speed: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := replay.URL("http://example.com/missing?x=1"); u != origin.URL+"/replay/"+replay.run+"/example.com/missing?x=1" {
+		t.Errorf("URL() = %q", u)
+	}
+
+	// Without a proxy in between, every request reaches the origin
+	start := time.Now()
+	report, err := replay.Run(context.Background(), &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("replay took %s, want at least the 3s of the trace sped up 10 times", elapsed)
+	}
+	want := map[string]Outcome{
+		"http://example.com/a":           {Requests: 3, Sequence: "MMM", OriginRequests: 3},
+		"http://example.com/missing?x=1": {Requests: 1, Sequence: "M", OriginRequests: 1},
+	}
+	if len(report.URLs) != len(want) {
+		t.Fatalf("URLs = %+v, want %+v", report.URLs, want)
+	}
+	for u, outcome := range want {
+		if report.URLs[u] != outcome {
+			t.Errorf("URLs[%s] = %+v, want %+v", u, report.URLs[u], outcome)
+		}
+	}
+	if report.Requests != 4 || report.Hits != 0 || report.OriginRequests != 4 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestOriginVersions(t *testing.T) {
+	origin, err := testhelpers.NewProxyTestServer("replay", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+
+	trace := Trace{
+		{Method: "GET", URL: "http://example.com/a", Status: 200, Bytes: 1000},
+		{Offset: time.Second, Method: "GET", URL: "http://example.com/a", Status: 304, Bytes: 200},
+		{Offset: 2 * time.Second, Method: "GET", URL: "http://example.com/a", Status: 200, Bytes: 1200},
+	}
+	replay, err := New(trace, origin, Options{Header: http.Header{"Cache-Control": {"max-age=60"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := replay.byURL["http://example.com/a"]
+	if want := []int{0, 0, 1}; len(replay.versions) != 3 || replay.versions[1] != want[1] || replay.versions[2] != want[2] {
+		t.Errorf("versions = %v, want %v", replay.versions, want)
+	}
+
+	get := func(header http.Header) *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, obj.replay, nil)
+		request.Header = header
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.ContentLength = int64(body.Len())
+		return resp
+	}
+
+	now := time.Now()
+	obj.send(0, now, time.Hour)
+	first := get(nil)
+	if first.ContentLength != 1000 || first.Header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("first version: %d bytes, Cache-Control %q", first.ContentLength, first.Header.Get("Cache-Control"))
+	}
+	if lastModified, _ := http.ParseTime(first.Header.Get("Last-Modified")); !lastModified.Equal(now.Add(-time.Hour).Truncate(time.Second)) {
+		t.Errorf("Last-Modified = %s, want an hour ago", lastModified)
+	}
+
+	// Unchanged: the revalidation gets a 304
+	if resp := get(http.Header{"If-None-Match": {first.Header.Get("Etag")}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", resp.StatusCode)
+	}
+
+	// Changed between its last request and now
+	obj.send(1, now.Add(10*time.Second), time.Hour)
+	resp := get(http.Header{"If-None-Match": {first.Header.Get("Etag")}})
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 1200 {
+		t.Errorf("second version: status %d, %d bytes, want 200 and 1200", resp.StatusCode, resp.ContentLength)
+	}
+	if lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified")); !lastModified.Equal(now.Add(5 * time.Second).Truncate(time.Second)) {
+		t.Errorf("Last-Modified = %s, want halfway between the requests", lastModified)
+	}
+	if obj.originRequests != 3 || obj.revalidations != 2 {
+		t.Errorf("origin requests = %d, revalidations = %d, want 3 and 2", obj.originRequests, obj.revalidations)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	baseline := &Report{Requests: 5, Hits: 2, OriginRequests: 3, URLs: map[string]Outcome{
+		"http://example.com/a":    {Requests: 3, Hits: 2, Sequence: "MHH", OriginRequests: 1},
+		"http://example.com/b":    {Requests: 1, Sequence: "M", OriginRequests: 1},
+		"http://example.com/gone": {Requests: 1, Sequence: "M", OriginRequests: 1},
+	}}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, baseline); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := &Report{Requests: 5, Hits: 2, OriginRequests: 5, URLs: map[string]Outcome{
+		"http://example.com/a":   {Requests: 3, Hits: 2, Sequence: "MHH", OriginRequests: 3, Revalidations: 2},
+		"http://example.com/b":   {Requests: 1, Sequence: "M", OriginRequests: 1},
+		"http://example.com/new": {Requests: 1, Sequence: "M", OriginRequests: 1},
+	}}
+	changes := Diff(baseline, current)
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	want := []string{
+		"http://example.com/a: MHH, 1 origin requests (0 revalidations) -> MHH, 3 origin requests (2 revalidations)",
+		"http://example.com/gone: M, 1 origin requests (0 revalidations) -> not replayed",
+		"http://example.com/new: not replayed -> M, 1 origin requests (0 revalidations)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Diff() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if summary := Summarize(baseline, current, changes); summary != "hit ratio 40.0% -> 40.0%, origin requests 3 -> 5, 3 URLs changed" {
+		t.Errorf("Summarize() = %q", summary)
+	}
+}
@@ -0,0 +1,89 @@
+package testhelpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AccessLogEntry is a line of the JSON access log written with the chart's
+// default accessLog.json.fields
+type AccessLogEntry struct {
+	Timestamp  float64 `json:"timestamp"`
+	Client     string  `json:"client"`
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	Status     int     `json:"status"`
+	ResultCode string  `json:"result_code"`
+	Bytes      int64   `json:"bytes"`
+	ElapsedMs  int64   `json:"elapsed_ms"`
+	Hierarchy  string  `json:"hierarchy"`
+	MimeType   string  `json:"mime_type"`
+	RequestID  string  `json:"request_id"`
+}
+
+// accessLogNumberFields are the default fields written as JSON numbers; all
+// other default fields are strings
+var accessLogNumberFields = map[string]bool{"timestamp": true, "status": true, "bytes": true, "elapsed_ms": true}
+
+// AccessLogFields are the names of the default JSON access log fields
+var AccessLogFields = []string{
+	"timestamp", "client", "method", "url", "status", "result_code",
+	"bytes", "elapsed_ms", "hierarchy", "mime_type", "request_id",
+}
+
+// ParseAccessLogLine parses a JSON access log line, verifying that it is a
+// single JSON object with every default field of the right type. Fields added
+// through accessLog.json.extraFields are allowed.
+func ParseAccessLogLine(line string) (*AccessLogEntry, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("trailing data after the JSON object")
+	}
+
+	for _, name := range AccessLogFields {
+		value, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("missing field %q", name)
+		}
+		switch value.(type) {
+		case json.Number:
+			if !accessLogNumberFields[name] {
+				return nil, fmt.Errorf("field %q: got a number, want a string", name)
+			}
+		case string:
+			if accessLogNumberFields[name] {
+				return nil, fmt.Errorf("field %q: got a string, want a number", name)
+			}
+		default:
+			return nil, fmt.Errorf("field %q: unexpected value %v", name, value)
+		}
+	}
+
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return nil, err
+	}
+	if entry.Method == "" || entry.URL == "" || entry.ResultCode == "" {
+		return nil, fmt.Errorf("method, url and result_code must not be empty")
+	}
+	return &entry, nil
+}
+
+// String formats the entry the way squid writes it with the default fields
+func (e AccessLogEntry) String() string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// Squid does not escape HTML characters
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(e); err != nil {
+		panic(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,89 @@
+package testhelpers
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	// As written by squid: URL characters escaped by the %"... modifier, "-"
+	// for an empty header
+	line := `{"timestamp":1760745600.123,"client":"10.244.0.10","method":"GET","url":"http://example.com/a\"b?c\\d","status":200,"result_code":"TCP_MISS","bytes":1520,"elapsed_ms":12,"hierarchy":"HIER_DIRECT","mime_type":"application/json","request_id":"-"}`
+	entry, err := ParseAccessLogLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AccessLogEntry{
+		Timestamp:  1760745600.123,
+		Client:     "10.244.0.10",
+		Method:     "GET",
+		URL:        `http://example.com/a"b?c\d`,
+		Status:     200,
+		ResultCode: "TCP_MISS",
+		Bytes:      1520,
+		ElapsedMs:  12,
+		Hierarchy:  "HIER_DIRECT",
+		MimeType:   "application/json",
+		RequestID:  "-",
+	}
+	if *entry != want {
+		t.Errorf("ParseAccessLogLine() = %+v, want %+v", *entry, want)
+	}
+	if entry.String() != line {
+		t.Errorf("String() = %s, want %s", entry.String(), line)
+	}
+
+	// Extra fields are allowed
+	extra := strings.Replace(line, "}", `,"user_agent":"curl/8.0"}`, 1)
+	if _, err := ParseAccessLogLine(extra); err != nil {
+		t.Errorf("ParseAccessLogLine() with an extra field: %v", err)
+	}
+
+	for name, invalid := range map[string]string{
+		"native format":   "1760745600.123     12 10.244.0.10 TCP_MISS/200 1520 GET http://example.com/ - HIER_DIRECT/10.0.0.1 application/json",
+		"missing field":   strings.Replace(line, `"hierarchy":"HIER_DIRECT",`, "", 1),
+		"quoted number":   strings.Replace(line, `"status":200`, `"status":"200"`, 1),
+		"unquoted string": strings.Replace(line, `"client":"10.244.0.10"`, `"client":10`, 1),
+		"empty method":    strings.Replace(line, `"method":"GET"`, `"method":""`, 1),
+		"two objects":     line + line,
+	} {
+		if _, err := ParseAccessLogLine(invalid); err == nil {
+			t.Errorf("ParseAccessLogLine() should reject a line with %s", name)
+		}
+	}
+}
+
+func TestAccessLogFieldsMatchChart(t *testing.T) {
+	data, err := os.ReadFile("../../squid/values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values struct {
+		AccessLog struct {
+			JSON struct {
+				Fields []struct {
+					Name string `json:"name"`
+					Type string `json:"type"`
+				} `json:"fields"`
+			} `json:"json"`
+		} `json:"accessLog"`
+	}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, field := range values.AccessLog.JSON.Fields {
+		names = append(names, field.Name)
+		if number := field.Type == "number"; number != accessLogNumberFields[field.Name] {
+			t.Errorf("field %q: number type is %v in the chart", field.Name, number)
+		}
+	}
+	if !reflect.DeepEqual(names, AccessLogFields) {
+		t.Errorf("accessLog.json.fields are %v, want %v", names, AccessLogFields)
+	}
+}
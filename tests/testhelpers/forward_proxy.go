@@ -0,0 +1,211 @@
+package testhelpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ForwardProxyRequestsPath serves the forward proxy's request counts by URL
+const ForwardProxyRequestsPath = "/requests"
+
+// hopByHopHeaders are not forwarded by the forward proxy
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// ForwardProxyConfig configures a ForwardProxy
+type ForwardProxyConfig struct {
+	// Port to listen on, 0 for a random one
+	Port int
+	// Username and Password, when set, are required as Basic proxy credentials
+	Username string
+	Password string
+	// OriginDomain, when set, makes hosts under it resolve to the IP address
+	// spelled by their first label, e.g. 10-244-0-7.origin.test to
+	// 10.244.0.7, so that requests for in-cluster test origins can be routed
+	// by domain
+	OriginDomain string
+}
+
+// ForwardProxy is a minimal HTTP forward proxy standing in for an upstream
+// (corporate) proxy squid chains to. It counts the requests it forwards by
+// URL and serves the counts at ForwardProxyRequestsPath.
+type ForwardProxy struct {
+	*httptest.Server
+	config    ForwardProxyConfig
+	transport *http.Transport
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+// NewForwardProxy starts a forward proxy listening on all interfaces
+func NewForwardProxy(config ForwardProxyConfig) (*ForwardProxy, error) {
+	proxy := &ForwardProxy{config: config, requests: map[string]int{}}
+	proxy.transport = &http.Transport{
+		Proxy:             nil,
+		DialContext:       proxy.dial,
+		DisableKeepAlives: true,
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", config.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener on port %d: %w", config.Port, err)
+	}
+	proxy.Server = httptest.NewUnstartedServer(proxy)
+	proxy.Server.Listener = listener
+	proxy.Server.Start()
+	return proxy, nil
+}
+
+// RequestCount returns how many requests for url the proxy forwarded
+func (p *ForwardProxy) RequestCount(url string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[url]
+}
+
+func (p *ForwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests for the proxy itself rather than through it
+	if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+		if r.URL.Path != ForwardProxyRequestsPath {
+			http.NotFound(w, r)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.requests)
+		return
+	}
+
+	if !p.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="forward-proxy"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	target := r.URL.String()
+	if r.Method == http.MethodConnect {
+		target = r.Host
+	}
+	p.mu.Lock()
+	p.requests[target]++
+	p.mu.Unlock()
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	p.forward(w, r)
+}
+
+func (p *ForwardProxy) authorized(r *http.Request) bool {
+	if p.config.Username == "" && p.config.Password == "" {
+		return true
+	}
+	// Proxy-Authorization has the format of Authorization
+	header := http.Header{"Authorization": r.Header.Values("Proxy-Authorization")}
+	username, password, ok := (&http.Request{Header: header}).BasicAuth()
+	return ok && username == p.config.Username && password == p.config.Password
+}
+
+func (p *ForwardProxy) forward(w http.ResponseWriter, r *http.Request) {
+	outgoing := r.Clone(r.Context())
+	outgoing.RequestURI = ""
+	for _, header := range hopByHopHeaders {
+		outgoing.Header.Del(header)
+	}
+
+	resp, err := p.transport.RoundTrip(outgoing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	for _, header := range hopByHopHeaders {
+		w.Header().Del(header)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *ForwardProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunneling is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// dial connects to address, resolving hosts under the origin domain to the
+// IP address of their first label
+func (p *ForwardProxy) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if domain := p.config.OriginDomain; domain != "" && strings.HasSuffix(host, "."+domain) {
+		label, _, _ := strings.Cut(host, ".")
+		ip := net.ParseIP(strings.ReplaceAll(label, "-", "."))
+		if ip == nil {
+			return nil, fmt.Errorf("%s does not name an IP address", host)
+		}
+		address = net.JoinHostPort(ip.String(), port)
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// GetForwardProxyRequests fetches the request counts by URL of the forward
+// proxy listening on proxyAddress (host:port)
+func GetForwardProxyRequests(proxyAddress string) (map[string]int, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + proxyAddress + ForwardProxyRequestsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forward proxy requests: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("forward proxy requests returned status %d", resp.StatusCode)
+	}
+	var requests map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("failed to parse forward proxy requests: %w", err)
+	}
+	return requests, nil
+}
@@ -0,0 +1,91 @@
+package testhelpers
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestForwardProxy(t *testing.T) {
+	origin, err := NewProxyTestServer("origin", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+
+	proxy, err := NewForwardProxy(ForwardProxyConfig{Username: "user", Password: "secret", OriginDomain: "origin.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	proxyAddress := proxy.Listener.Addr().String()
+
+	client := func(user *url.Userinfo) *http.Client {
+		proxyURL := &url.URL{Scheme: "http", Host: proxyAddress, User: user}
+		return &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			Timeout: 5 * time.Second,
+		}
+	}
+
+	// The origin is addressed under the origin domain
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+	originURL := "http://127-0-0-1.origin.test:" + port + "/artifact"
+
+	resp, body, err := MakeProxyRequest(client(url.UserPassword("user", "secret")), originURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if _, err := ParseTestServerResponse(body); err != nil {
+		t.Error(err)
+	}
+
+	resp, _, err = MakeProxyRequest(client(url.UserPassword("user", "wrong")), originURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("status with wrong credentials = %d, want 407", resp.StatusCode)
+	}
+
+	// HTTPS is tunneled with CONNECT
+	tlsOrigin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tunneled"))
+	}))
+	defer tlsOrigin.Close()
+	resp, body, err = MakeProxyRequest(client(url.UserPassword("user", "secret")), tlsOrigin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if string(body) != "tunneled" {
+		t.Errorf("tunneled body = %q", body)
+	}
+
+	requests, err := GetForwardProxyRequests(proxyAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsHost, _ := url.Parse(tlsOrigin.URL)
+	if requests[originURL] != 1 || requests[tlsHost.Host] != 1 || len(requests) != 2 {
+		t.Errorf("requests = %v, want one each for %s and %s", requests, originURL, tlsHost.Host)
+	}
+	if proxy.RequestCount(originURL) != 1 {
+		t.Errorf("RequestCount(%s) = %d, want 1", originURL, proxy.RequestCount(originURL))
+	}
+	if origin.GetRequestCount() != 1 {
+		t.Errorf("origin request count = %d, want 1", origin.GetRequestCount())
+	}
+}
@@ -1,6 +1,7 @@
 package testhelpers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,9 +9,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/konflux-ci/caching/internal/purge"
 	. "github.com/onsi/gomega"
 )
 
@@ -28,18 +32,52 @@ type ProxyTestServer struct {
 	RequestCount *int32
 	PodIP        string
 	URL          string
+	errorAfter   *atomic.Int32
+	routes       *sync.Map
 }
 
-// NewProxyTestServer creates a new test server configured for cross-pod communication
+// NewProxyTestServer creates a new test server configured for cross-pod communication.
+// A delay query parameter, e.g. ?delay=2s, holds the response for that long,
+// and a cache-control one replaces the default Cache-Control header. Paths
+// scripted with Route get their scripted responses instead.
 func NewProxyTestServer(message string, podIP string, port int) (*ProxyTestServer, error) {
 	var requestCount int32
+	var errorAfter atomic.Int32
+	var routes sync.Map
 
 	// Create HTTP server with request tracking
 	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		count := atomic.AddInt32(&requestCount, 1)
 
+		if after := errorAfter.Load(); after > 0 && count > after {
+			http.Error(w, "origin outage", http.StatusServiceUnavailable)
+			return
+		}
+
+		if script, ok := routes.Load(r.URL.Path); ok {
+			serveRoute(w, r, script.(RouteScript)(r))
+			return
+		}
+
+		if r.URL.Path == SlowDownloadPath {
+			serveSlowDownload(w, r)
+			return
+		}
+
+		if delay, err := time.ParseDuration(r.URL.Query().Get("delay")); err == nil {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
 		// Add cache headers to make content cacheable
-		w.Header().Set("Cache-Control", "public, max-age=300")
+		cacheControl := "public, max-age=300"
+		if override := r.URL.Query().Get("cache-control"); override != "" {
+			cacheControl = override
+		}
+		w.Header().Set("Cache-Control", cacheControl)
 		w.Header().Set("Content-Type", "application/json")
 
 		// Return JSON response with request count
@@ -71,9 +109,120 @@ func NewProxyTestServer(message string, podIP string, port int) (*ProxyTestServe
 		RequestCount: &requestCount,
 		PodIP:        podIP,
 		URL:          serverURL,
+		errorAfter:   &errorAfter,
+		routes:       &routes,
 	}, nil
 }
 
+// RouteResponse is the response a route script returns for a request
+type RouteResponse struct {
+	// Status defaults to 200 OK
+	Status int
+	Header http.Header
+	// Body may be nil for an empty body
+	Body io.ReadSeeker
+	// LastModified, if set, is sent as Last-Modified. Together with an ETag
+	// in Header, it answers conditional requests of a 200 OK response with
+	// 304 Not Modified.
+	LastModified time.Time
+}
+
+// RouteScript returns the response to a request for a scripted path
+type RouteScript func(r *http.Request) RouteResponse
+
+// Route scripts the responses to every request for path, whatever its query.
+// The requests still count towards the request count and FailAfter.
+func (pts *ProxyTestServer) Route(path string, script RouteScript) {
+	pts.routes.Store(path, script)
+}
+
+func serveRoute(w http.ResponseWriter, r *http.Request, response RouteResponse) {
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	body := response.Body
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	if response.Status == 0 || response.Status == http.StatusOK {
+		// ServeContent takes care of conditional, range and HEAD requests
+		http.ServeContent(w, r, "", response.LastModified, body)
+		return
+	}
+
+	if !response.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", response.LastModified.UTC().Format(http.TimeFormat))
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(response.Status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, body)
+	}
+}
+
+// SizedBody returns a body of size bytes without holding them in memory, to
+// script large objects
+func SizedBody(size int64) io.ReadSeeker {
+	return io.NewSectionReader(filler{}, 0, size)
+}
+
+// filler reads as an endless run of x
+type filler struct{}
+
+func (filler) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+// SlowDownloadPath serves an uncacheable download streamed at a steady pace,
+// e.g. /slow-download?size=10485760&duration=30s. size defaults to 1 MiB and
+// duration to 10s.
+const SlowDownloadPath = "/slow-download"
+
+// slowDownloadChunks is the number of writes a slow download is split into
+const slowDownloadChunks = 100
+
+func serveSlowDownload(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = 1 << 20
+	}
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 {
+		duration = 10 * time.Second
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	chunk := bytes.Repeat([]byte("x"), size/slowDownloadChunks+1)
+	for written := 0; written < size; {
+		n := min(len(chunk), size-written)
+		if _, err := w.Write(chunk[:n]); err != nil {
+			return
+		}
+		written += n
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(duration / slowDownloadChunks):
+		}
+	}
+}
+
 // GetRequestCount returns the current request count
 func (pts *ProxyTestServer) GetRequestCount() int32 {
 	return atomic.LoadInt32(pts.RequestCount)
@@ -84,14 +233,54 @@ func (pts *ProxyTestServer) ResetRequestCount() {
 	atomic.StoreInt32(pts.RequestCount, 0)
 }
 
+// FailAfter makes the server answer every request after the first n (counted
+// since it started or the counter was last reset) with 503 Service
+// Unavailable, like an origin in an outage. 0 turns the error mode off.
+func (pts *ProxyTestServer) FailAfter(n int32) {
+	pts.errorAfter.Store(n)
+}
+
 // NewSquidProxyClient creates an HTTP client configured to use the Squid proxy
 func NewSquidProxyClient(serviceName, namespace string) (*http.Client, error) {
-	// Set up proxy URL to squid service
-	proxyURL, err := url.Parse(fmt.Sprintf("http://%s.%s.svc.cluster.local:3128", serviceName, namespace))
+	return NewProxyClient(squidProxyAddress(serviceName, namespace))
+}
+
+// NewSquidProxyClientWithCredentials creates an HTTP client that authenticates
+// to the Squid proxy with the given Basic credentials
+func NewSquidProxyClientWithCredentials(serviceName, namespace, username, password string) (*http.Client, error) {
+	proxyURL, err := parseProxyURL(squidProxyAddress(serviceName, namespace))
+	if err != nil {
+		return nil, err
+	}
+	// The transport sends Proxy-Authorization for credentials in the proxy URL
+	proxyURL.User = url.UserPassword(username, password)
+	return newProxyClient(proxyURL), nil
+}
+
+// NewProxyClient creates an HTTP client configured to use the proxy listening
+// on the given host:port, e.g. a single squid pod rather than the service
+func NewProxyClient(proxyAddress string) (*http.Client, error) {
+	proxyURL, err := parseProxyURL(proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	return newProxyClient(proxyURL), nil
+}
+
+// squidProxyAddress is the host:port of the Squid proxy service
+func squidProxyAddress(serviceName, namespace string) string {
+	return fmt.Sprintf("%s.%s.svc.cluster.local:3128", serviceName, namespace)
+}
+
+func parseProxyURL(proxyAddress string) (*url.URL, error) {
+	proxyURL, err := url.Parse("http://" + proxyAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
 	}
+	return proxyURL, nil
+}
 
+func newProxyClient(proxyURL *url.URL) *http.Client {
 	// Create HTTP client with proxy configuration
 	transport := &http.Transport{
 		Proxy: http.ProxyURL(proxyURL),
@@ -102,7 +291,7 @@ func NewSquidProxyClient(serviceName, namespace string) (*http.Client, error) {
 	return &http.Client{
 		Transport: transport,
 		Timeout:   30 * time.Second,
-	}, nil
+	}
 }
 
 // MakeProxyRequest makes an HTTP request through the Squid proxy and returns the response
@@ -121,6 +310,41 @@ func MakeProxyRequest(client *http.Client, url string) (*http.Response, []byte,
 	return resp, body, nil
 }
 
+// ConcurrentResult is the outcome of one of ConcurrentRequests' requests
+type ConcurrentResult struct {
+	Response *http.Response
+	Body     []byte
+	Err      error
+}
+
+// ConcurrentRequests sends n GET requests for url through client at once,
+// e.g. to reproduce a thundering herd of cache misses, and returns their
+// results in order
+func ConcurrentRequests(client *http.Client, url string, n int) []ConcurrentResult {
+	results := make([]ConcurrentResult, n)
+	start := make(chan struct{})
+	var ready, done sync.WaitGroup
+	for i := range results {
+		ready.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			ready.Done()
+			<-start
+			resp, body, err := MakeProxyRequest(client, url)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			results[i] = ConcurrentResult{Response: resp, Body: body, Err: err}
+		}()
+	}
+	// Release the requests together once every goroutine waits
+	ready.Wait()
+	close(start)
+	done.Wait()
+	return results
+}
+
 // ParseTestServerResponse parses a JSON response from a test server
 func ParseTestServerResponse(body []byte) (*TestServerResponse, error) {
 	var response TestServerResponse
@@ -161,3 +385,38 @@ func ValidateServerHit(response *TestServerResponse, expectedRequestID float64,
 	Expect(server.GetRequestCount()).To(Equal(int32(expectedRequestID)),
 		"Server should have received expected number of requests")
 }
+
+// PurgeRequest sends a purge request to the purge API at apiURL and returns
+// the HTTP status together with the per-replica results
+func PurgeRequest(apiURL, token string, request purge.Request) (int, *purge.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to encode purge request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, apiURL+"/purge", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("purge request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		return resp.StatusCode, nil, nil
+	}
+
+	var response purge.Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to parse purge response: %w", err)
+	}
+	return resp.StatusCode, &response, nil
+}
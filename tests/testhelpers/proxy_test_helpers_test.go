@@ -0,0 +1,194 @@
+package testhelpers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlowDownload(t *testing.T) {
+	server, err := NewProxyTestServer("slow", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + SlowDownloadPath + "?size=12345&duration=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body) != 12345 {
+		t.Errorf("got %d bytes, want 12345", len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("download took %s, want at least the requested 200ms", elapsed)
+	}
+	if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", cacheControl)
+	}
+	if server.GetRequestCount() != 1 {
+		t.Errorf("request count = %d, want 1", server.GetRequestCount())
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	const n = 10
+	// Every request waits until all of them arrived, which only happens if
+	// they are in flight together
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	results := ConcurrentRequests(client, origin.URL, n)
+	if len(results) != n {
+		t.Fatalf("got %d results, want %d", len(results), n)
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("request %d: %v", i, result.Err)
+		}
+		if result.Response.StatusCode != http.StatusOK || string(result.Body) != "ok" {
+			t.Errorf("request %d: status %d, body %q", i, result.Response.StatusCode, result.Body)
+		}
+	}
+}
+
+func TestDelayedResponse(t *testing.T) {
+	server, err := NewProxyTestServer("delayed", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	start := time.Now()
+	resp, body, err := MakeProxyRequest(http.DefaultClient, server.URL+"/?delay=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("response took %s, want at least the requested 200ms", elapsed)
+	}
+	if _, err := ParseTestServerResponse(body); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFailAfter(t *testing.T) {
+	server, err := NewProxyTestServer("outage", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.FailAfter(1)
+
+	testURL := server.URL + "/?cache-control=" + url.QueryEscape("max-age=2, stale-if-error=60")
+	var statuses []int
+	for range 3 {
+		resp, _, err := MakeProxyRequest(http.DefaultClient, testURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+		if resp.StatusCode == http.StatusOK {
+			if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "max-age=2, stale-if-error=60" {
+				t.Errorf("Cache-Control = %q, want the requested one", cacheControl)
+			}
+		}
+	}
+	if want := []int{200, 503, 503}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+
+	server.FailAfter(0)
+	resp, _, err := MakeProxyRequest(http.DefaultClient, testURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d after turning the error mode off, want 200", resp.StatusCode)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	server, err := NewProxyTestServer("routes", "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	server.Route("/object", func(r *http.Request) RouteResponse {
+		if r.URL.Query().Get("v") == "missing" {
+			return RouteResponse{Status: http.StatusNotFound, Body: strings.NewReader("gone")}
+		}
+		return RouteResponse{
+			Header:       http.Header{"Etag": {`"v1"`}, "Content-Type": {"application/octet-stream"}},
+			Body:         SizedBody(1000),
+			LastModified: modified,
+		}
+	})
+
+	resp, body, err := MakeProxyRequest(http.DefaultClient, server.URL+"/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(body) != 1000 {
+		t.Errorf("got status %d and %d bytes, want 200 and 1000", resp.StatusCode, len(body))
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != modified.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q", lastModified)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/object", nil)
+	request.Header.Set("If-None-Match", `"v1"`)
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request status = %d, want 304", resp.StatusCode)
+	}
+
+	resp, body, err = MakeProxyRequest(http.DefaultClient, server.URL+"/object?v=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || string(body) != "gone" {
+		t.Errorf("got status %d and body %q, want 404 and gone", resp.StatusCode, body)
+	}
+
+	// Other paths keep the default response
+	_, body, err = MakeProxyRequest(http.DefaultClient, server.URL+"/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTestServerResponse(body); err != nil {
+		t.Error(err)
+	}
+	if server.GetRequestCount() != 4 {
+		t.Errorf("request count = %d, want 4", server.GetRequestCount())
+	}
+}